@@ -0,0 +1,34 @@
+package extractors
+
+import "github.com/rs/zerolog/log"
+
+// Severities of a Diagnostic.
+const (
+	DiagnosticError   = "error"
+	DiagnosticWarning = "warning"
+)
+
+// Diagnostic is a problem found in a file that kept an image from being extracted, or made the
+// extracted image name unreliable.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Path     string `json:"path"`
+	// Line is the 0-based line the problem was found at, or -1 when it is not known.
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// DiagnosticReporter receives the diagnostics found while extracting images. A nil reporter drops them.
+type DiagnosticReporter func(Diagnostic)
+
+// report logs a diagnostic and passes it on to the reporter.
+func (r DiagnosticReporter) report(diagnostic Diagnostic) {
+	if diagnostic.Line < 0 {
+		log.Warn().Msgf("%s: %s", diagnostic.Path, diagnostic.Message)
+	} else {
+		log.Warn().Msgf("%s line %d: %s", diagnostic.Path, diagnostic.Line+1, diagnostic.Message)
+	}
+	if r != nil {
+		r(diagnostic)
+	}
+}
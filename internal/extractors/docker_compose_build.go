@@ -0,0 +1,136 @@
+package extractors
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// extractComposeBuildImages returns the images pulled by the Dockerfile a compose service builds,
+// with the build args and target of the service applied.
+func extractComposeBuildImages(service *composeService, envFiles map[string]map[string]string, report DiagnosticReporter) []ImageDetails {
+	build := service.build
+	vars := resolveEnvVariables(build.file.FullPath, envFiles)
+	context, dockerfile, buildOptions, ok := interpolateComposeBuild(build, vars, report)
+	if !ok {
+		return nil
+	}
+
+	var images []ImageDetails
+	var err error
+	if build.inline != nil {
+		images, err = extractInlineDockerfileImages(build, envFiles, buildOptions)
+	} else {
+		dockerfilePath, local := composeBuildDockerfile(build.file, context, dockerfile)
+		if !local {
+			log.Debug().Msgf("Skipping remote build context %s of service %s", context, service.name)
+			return nil
+		}
+		images, err = extractImagesFromDockerfile(dockerfilePath, envFiles, buildOptions)
+	}
+	if err != nil {
+		log.Warn().Msgf("could not extract images from the dockerfile of service %s in %s err: %+v", service.name, build.file.RelativePath, err)
+		return nil
+	}
+
+	for i := range images {
+		images[i].Service = service.name
+	}
+	return images
+}
+
+// interpolateComposeBuild expands the variables of the context, dockerfile, target and args of a
+// build. A dockerfile_inline is left as written.
+func interpolateComposeBuild(build *composeBuild, vars map[string]string, report DiagnosticReporter) (string, string, DockerfileBuildOptions, bool) {
+	ok := true
+	expand := func(node *yaml.Node) string {
+		if node == nil {
+			return ""
+		}
+		value, valid := interpolateComposeValue(node, build.file, vars, report)
+		ok = ok && valid
+		return value
+	}
+
+	context, dockerfile := expand(build.context), expand(build.dockerfile)
+	buildOptions := DockerfileBuildOptions{Target: expand(build.target)}
+	if build.args != nil {
+		buildOptions.BuildArgs = make(map[string]string, len(build.args))
+		for key, node := range build.args {
+			buildOptions.BuildArgs[key] = expand(node)
+		}
+	}
+	return context, dockerfile, buildOptions, ok
+}
+
+// composeBuildDockerfile returns the Dockerfile of a build section declared in file, or false when
+// the context is not a local directory.
+func composeBuildDockerfile(file types.FilePath, context, dockerfile string) (types.FilePath, bool) {
+	if context == "" {
+		context = "."
+	}
+	if strings.Contains(context, "://") || strings.HasPrefix(context, "git@") {
+		return types.FilePath{}, false
+	}
+
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	if filepath.IsAbs(dockerfile) {
+		return types.FilePath{FullPath: dockerfile, RelativePath: filepath.ToSlash(dockerfile)}, true
+	}
+	return relatedFile(file, path.Join(filepath.ToSlash(context), filepath.ToSlash(dockerfile))), true
+}
+
+// extractInlineDockerfileImages extracts the images of a dockerfile_inline. When the Dockerfile is a
+// literal block, locations point into the compose file; otherwise they point at the dockerfile_inline value.
+func extractInlineDockerfileImages(build *composeBuild, envFiles map[string]map[string]string, buildOptions DockerfileBuildOptions) ([]ImageDetails, error) {
+	dockerfile, err := parseDockerfileContent([]byte(build.inline.Value))
+	if err != nil {
+		return nil, err
+	}
+	images, err := extractImagesFromDockerfileSource(dockerfile, build.file, envFiles, buildOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	indent := -1
+	if build.inline.Style == yaml.LiteralStyle {
+		indent = blockIndent(build.file.FullPath, build.inline.Line)
+	}
+	for i := range images {
+		location := &images[i].ImageLocations[0]
+		location.Origin = types.DockerComposeFileOrigin
+		if indent == -1 {
+			location.Line, location.StartIndex, location.EndIndex = build.inline.Line-1, -1, -1
+			continue
+		}
+		location.Line += build.inline.Line
+		if location.StartIndex != -1 {
+			location.StartIndex += indent
+			location.EndIndex += indent
+		}
+	}
+	return images, nil
+}
+
+// blockIndent returns the indentation of the content of a block scalar whose indicator is on the
+// given 1-based line, or -1 when it cannot be read.
+func blockIndent(filePath string, line int) int {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return -1
+	}
+	lines := strings.Split(string(content), "\n")
+	for _, text := range lines[min(line, len(lines)):] {
+		if strings.TrimSpace(text) != "" {
+			return len(text) - len(strings.TrimLeft(text, " "))
+		}
+	}
+	return -1
+}
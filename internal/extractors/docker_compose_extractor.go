@@ -13,78 +13,117 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// Service represents a service in docker-compose
-type Service struct {
-	Image string `yaml:"image"`
-	Build *Build `yaml:"build"`
+// ComposeOptions holds the docker compose flags that change which services are run.
+type ComposeOptions struct {
+	// Profiles are the active profiles, like --profile. When Profiles is nil every service is reported.
+	// Otherwise only the services without profiles and the services of an active profile are, as
+	// docker compose runs them. The "*" profile enables every profile.
+	Profiles []string
+	// Services are the services to run, like the service arguments of docker compose up. When set,
+	// only these services and the services they depend on are reported, whatever their profiles.
+	Services []string
 }
 
-// Build represents the build context in docker-compose
-type Build struct {
-	Context string `yaml:"context"`
-}
+// composeImagePattern matches an image reference without a digest, capturing its name and tag.
+var composeImagePattern = regexp.MustCompile(`^([^:@\s]+)(?::([^@\s]+))?$`)
+
+// imageReferencePattern matches a well formed image reference: an optional registry, a lowercase
+// repository path, then an optional tag and digest, following the grammar of docker references.
+var imageReferencePattern = regexp.MustCompile(`^` +
+	`(?:(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*|\[[a-fA-F0-9:]+\])(?::[0-9]+)?/)?` +
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	`(?::[\w][\w.-]{0,127})?` +
+	`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?$`)
 
-// ComposeFile represents a docker-compose file structure
-type ComposeFile struct {
-	Services map[string]Service `yaml:"services"`
+// IsImageReference reports whether ref is a well formed image reference, such as ghcr.io/org/app:1.0.
+func IsImageReference(ref string) bool {
+	return imageReferencePattern.MatchString(ref)
 }
 
 func ExtractImagesFromDockerComposeFiles(filePaths []types.FilePath, envFiles map[string]map[string]string) ([]types.ImageModel, error) {
-	var imageNames []types.ImageModel
+	return ExtractImagesFromDockerComposeFilesWithOptions(filePaths, envFiles, ComposeOptions{}, nil)
+}
 
-	for _, filePath := range filePaths {
-		log.Debug().Msgf("going to extract images from docker compose file %s", filePath)
-		fileImages, err := extractImagesFromDockerComposeFile(filePath, envFiles)
+// ExtractImagesFromDockerComposeFilesWithOptions extracts images from the services of compose files
+// selected by options, passing the problems found while interpolating variables to report. It returns
+// the images of ExtractImagesWithLineNumbersFromDockerComposeFiles, with only the file of each location.
+func ExtractImagesFromDockerComposeFilesWithOptions(filePaths []types.FilePath, envFiles map[string]map[string]string,
+	options ComposeOptions, report DiagnosticReporter) ([]types.ImageModel, error) {
+	images, err := extractImagesFromDockerComposeFiles(filePaths, envFiles, options, report)
+	return withoutLineInfo(ImageModels(images), types.DockerComposeFileOrigin), err
+}
+
+func extractImagesFromDockerComposeFiles(filePaths []types.FilePath, envFiles map[string]map[string]string,
+	options ComposeOptions, report DiagnosticReporter) ([]ImageDetails, error) {
+	var imageNames []ImageDetails
+
+	for _, project := range groupComposeProjects(filePaths) {
+		log.Debug().Msgf("going to extract images from docker compose files %v", project)
+		projectImages, err := extractImagesFromDockerComposeProject(project, envFiles, options, report)
 		if err != nil {
-			log.Warn().Msgf("could not extract images from docker compose file %s err: %+v", filePath, err)
+			log.Warn().Msgf("could not extract images from docker compose files %v err: %+v", project, err)
+			continue
 		}
-		printFoundImagesInFile(filePath.RelativePath, fileImages)
-		imageNames = append(imageNames, fileImages...)
+		printFoundImagesInFile(project[0].RelativePath, ImageModels(projectImages))
+		imageNames = append(imageNames, projectImages...)
 	}
 
 	return imageNames, nil
 }
 
-func extractImagesFromDockerComposeFile(filePath types.FilePath, envFiles map[string]map[string]string) ([]types.ImageModel, error) {
-	var imageNames []types.ImageModel
-
-	file, err := os.Open(filePath.FullPath)
-	if err != nil {
-		return nil, err
-	}
-	defer func(file *os.File) {
-		err = file.Close()
-		if err != nil {
-			log.Warn().Msgf("Could not close docker compose file: %s err: %+v", file.Name(), err)
-		}
-	}(file)
+// extractImagesFromDockerComposeProject extracts the effective image of every selected service of a
+// compose project, with its variables resolved. Each location spans the image value as written, so an
+// image replaced by an override file keeps the locations of all the files that set it. Services that
+// only build an image report the base images of the Dockerfile they build. Images that are not a
+// valid reference once interpolated, such as redis: with an unset tag, are reported and left out.
+func extractImagesFromDockerComposeProject(files []types.FilePath, envFiles map[string]map[string]string,
+	options ComposeOptions, report DiagnosticReporter) ([]ImageDetails, error) {
+	var imageNames []ImageDetails
 
-	var compose ComposeFile
-	decoder := yaml.NewDecoder(bufio.NewReader(file))
-	err = decoder.Decode(&compose)
+	services, err := loadComposeProject(files)
 	if err != nil {
-		log.Err(err).Msg("Error parsing docker-compose file")
 		return nil, err
 	}
 
-	mergedEnvVars := resolveEnvVariables(filePath.FullPath, envFiles)
-
-	// Regex pattern
-	pattern := `^([^:@\s]+)(?::([^@\s]+))?$`
-	re := regexp.MustCompile(pattern)
+	// Variables are resolved from the project directory of each file, which is its own for included files
+	projectEnvVars := make(map[string]map[string]string)
 
-	for serviceName, service := range compose.Services {
-		if service.Image != "" {
-			fmt.Printf("Service: %s, Image: %s\n", serviceName, service.Image)
-		} else if service.Build != nil && service.Build.Context != "" {
-			fmt.Printf("Service: %s, Build Context: %s (no image specified)\n", serviceName, service.Build.Context)
-		} else {
-			fmt.Printf("Service: %s, No image or build context specified\n", serviceName)
+	for _, service := range selectComposeServices(services, options) {
+		if service.image == nil {
+			if service.build == nil {
+				log.Debug().Msgf("Service: %s, No image or build specified", service.name)
+				continue
+			}
+			log.Debug().Msgf("Service: %s, Build Context: %s (no image specified)", service.name, scalarValue(service.build.context))
+			for _, image := range extractComposeBuildImages(service, envFiles, report) {
+				image.Profiles = service.profiles
+				imageNames = append(imageNames, image)
+			}
+			continue
 		}
+		log.Debug().Msgf("Service: %s, Image: %s", service.name, service.image.Value)
 
-		fullImageName := processEnvVars(service.Image, mergedEnvVars)
+		effective := service.imageSources[len(service.imageSources)-1]
+		envVars, ok := projectEnvVars[effective.project.FullPath]
+		if !ok {
+			envVars = resolveEnvVariables(effective.project.FullPath, envFiles)
+			projectEnvVars[effective.project.FullPath] = envVars
+		}
+		fullImageName, ok := interpolateComposeValue(effective.node, effective.file, envVars, report)
+		if !ok {
+			continue
+		}
+		if !imageReferencePattern.MatchString(fullImageName) {
+			report.report(Diagnostic{
+				Severity: DiagnosticError,
+				Path:     effective.file.RelativePath,
+				Line:     effective.node.Line - 1,
+				Message:  fmt.Sprintf("image %q of service %s is not a valid image reference", fullImageName, service.name),
+			})
+			continue
+		}
 
-		if match := re.FindStringSubmatch(fullImageName); match != nil {
+		if match := composeImagePattern.FindStringSubmatch(fullImageName); match != nil {
 			imageName := match[1]
 			tag := match[2]
 
@@ -95,41 +134,38 @@ func extractImagesFromDockerComposeFile(filePath types.FilePath, envFiles map[st
 			fullImageName = fmt.Sprintf("%s:%s", imageName, tag)
 		}
 
-		imageNames = append(imageNames, types.ImageModel{
-			Name: fullImageName,
-			ImageLocations: []types.ImageLocation{
-				{
-					Origin: types.DockerComposeFileOrigin,
-					Path:   filePath.RelativePath,
-				},
-			},
+		imageModel := types.ImageModel{Name: fullImageName}
+		for _, source := range service.imageSources {
+			imageModel.ImageLocations = append(imageModel.ImageLocations, createImageModel(source.node, service.name, source.file).ImageLocations...)
+		}
+		imageNames = append(imageNames, ImageDetails{
+			ImageModel:     imageModel,
+			ComposeDetails: ComposeDetails{Service: service.name, Profiles: service.profiles},
 		})
 	}
 
 	return imageNames, nil
 }
 
-func processEnvVars(extractedImageId string, envVars map[string]string) string {
-	for key, value := range envVars {
-
-		pattern := `(\{\{` + regexp.QuoteMeta(key) + `\}\}|\$\{` + regexp.QuoteMeta(key) + `\})`
-		pattern2 := `\$\{` + regexp.QuoteMeta(key) + `:-[^}]*\}`
-
-		re := regexp.MustCompile(pattern)
-		extractedImageId = re.ReplaceAllString(extractedImageId, value)
-
-		re2 := regexp.MustCompile(pattern2)
-		extractedImageId = re2.ReplaceAllString(extractedImageId, value)
+// interpolateComposeValue expands the variables of a compose value. Variables that are not set are
+// reported as warnings and replaced by an empty string, like docker compose does. Missing required
+// variables and malformed expressions are reported as errors, and the value is not usable.
+func interpolateComposeValue(node *yaml.Node, filePath types.FilePath, vars map[string]string, report DiagnosticReporter) (string, bool) {
+	interpolator := newComposeInterpolator(vars)
+	value, err := interpolator.interpolate(node.Value)
+	if err != nil {
+		report.report(Diagnostic{Severity: DiagnosticError, Path: filePath.RelativePath, Line: node.Line - 1, Message: err.Error()})
+		return "", false
 	}
-
-	defaultImagePattern := `:-(.+)}`
-	re := regexp.MustCompile(defaultImagePattern)
-	match := re.FindStringSubmatch(extractedImageId)
-	if match != nil {
-		return match[1]
+	for _, name := range interpolator.unset {
+		report.report(Diagnostic{
+			Severity: DiagnosticWarning,
+			Path:     filePath.RelativePath,
+			Line:     node.Line - 1,
+			Message:  fmt.Sprintf("variable %s is not set, defaulting to a blank string", name),
+		})
 	}
-
-	return extractedImageId
+	return value, true
 }
 
 // calculateYAMLIndices calculates the start and end index of a value in a specific line of a YAML file
@@ -165,92 +201,53 @@ func calculateYAMLIndices(filePath string, lineNum int, value string) (startIdx,
 }
 
 // ExtractImagesWithLineNumbersFromDockerComposeFile extracts images and their line numbers using yaml.Node.
-func ExtractImagesWithLineNumbersFromDockerComposeFile(filePath types.FilePath) ([]types.ImageModel, error) {
-	var imageNames []types.ImageModel
-
-	file, err := os.Open(filePath.FullPath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	decoder := yaml.NewDecoder(file)
-	var root yaml.Node
-	if err := decoder.Decode(&root); err != nil {
-		return nil, err
-	}
-
-	// Find and process services
-	imageNames = findAndProcessServices(&root, filePath)
-
-	return imageNames, nil
+// Variables are resolved from envFiles, while locations span the image value as written.
+func ExtractImagesWithLineNumbersFromDockerComposeFile(filePath types.FilePath, envFiles map[string]map[string]string) ([]types.ImageModel, error) {
+	images, err := extractImagesFromDockerComposeProject([]types.FilePath{filePath}, envFiles, ComposeOptions{}, nil)
+	return ImageModels(images), err
 }
 
-// findAndProcessServices extracts images from all services in the YAML
-func findAndProcessServices(root *yaml.Node, filePath types.FilePath) []types.ImageModel {
-	var imageNames []types.ImageModel
-
-	// Find the "services" mapping node
-	servicesNode := findServicesNode(root)
-	if servicesNode == nil {
-		return imageNames
-	}
-
-	// Process each service
-	for i := 0; i < len(servicesNode.Content); i += 2 {
-		serviceName := servicesNode.Content[i].Value
-		serviceNode := servicesNode.Content[i+1]
-
-		serviceImages := processService(serviceNode, serviceName, filePath)
-		imageNames = append(imageNames, serviceImages...)
-	}
+// ExtractImagesWithLineNumbersFromDockerComposeFiles extracts images and their line numbers from the
+// services of compose files selected by options, merging override files into their base file like
+// docker compose does. Variables are resolved from envFiles, while locations span the image value as written.
+func ExtractImagesWithLineNumbersFromDockerComposeFiles(filePaths []types.FilePath, envFiles map[string]map[string]string,
+	options ComposeOptions, report DiagnosticReporter) ([]types.ImageModel, error) {
+	images, err := extractImagesFromDockerComposeFiles(filePaths, envFiles, options, report)
+	return ImageModels(images), err
+}
 
-	return imageNames
+// ExtractImageDetailsFromDockerComposeFiles is ExtractImagesWithLineNumbersFromDockerComposeFiles
+// returning every occurrence of an image with the service using it and the profiles of the service.
+func ExtractImageDetailsFromDockerComposeFiles(filePaths []types.FilePath, envFiles map[string]map[string]string,
+	options ComposeOptions, report DiagnosticReporter) ([]ImageDetails, error) {
+	images, err := extractImagesFromDockerComposeFiles(filePaths, envFiles, options, report)
+	return splitImageDetails(images), err
 }
 
-// findServicesNode locates the services mapping in the YAML root
-func findServicesNode(root *yaml.Node) *yaml.Node {
-	for i := 0; i < len(root.Content); i++ {
-		node := root.Content[i]
-		if node.Kind == yaml.MappingNode {
-			servicesNode := findServicesInMapping(node)
-			if servicesNode != nil {
-				return servicesNode
+// withoutLineInfo keeps only the origin and file of the locations of images with the given origin, such
+// as the compose file locations. Locations in the Dockerfiles built by services keep their line info,
+// like the images of any other Dockerfile.
+func withoutLineInfo(images []types.ImageModel, origin string) []types.ImageModel {
+	for i := range images {
+		for j, location := range images[i].ImageLocations {
+			if location.Origin == origin {
+				images[i].ImageLocations[j] = types.ImageLocation{Origin: location.Origin, Path: location.Path, FinalStage: location.FinalStage}
 			}
 		}
 	}
-	return nil
+	return images
 }
 
-// findServicesInMapping searches for the "services" key in a mapping node
-func findServicesInMapping(mappingNode *yaml.Node) *yaml.Node {
-	for j := 0; j < len(mappingNode.Content); j += 2 {
-		key := mappingNode.Content[j]
-		value := mappingNode.Content[j+1]
-		if key.Value == "services" && value.Kind == yaml.MappingNode {
-			return value
+// findServicesNode locates the services mapping in the YAML root
+func findServicesNode(root *yaml.Node) *yaml.Node {
+	for _, document := range root.Content {
+		if services := mappingValue(document, "services"); services != nil && services.Kind == yaml.MappingNode {
+			return services
 		}
 	}
 	return nil
 }
 
-// processService extracts images from a single service
-func processService(serviceNode *yaml.Node, serviceName string, filePath types.FilePath) []types.ImageModel {
-	var imageNames []types.ImageModel
-
-	for i := 0; i < len(serviceNode.Content); i += 2 {
-		fieldKey := serviceNode.Content[i]
-		fieldValue := serviceNode.Content[i+1]
-
-		if fieldKey.Value == "image" {
-			imageModel := createImageModel(fieldValue, serviceName, filePath)
-			imageNames = append(imageNames, imageModel)
-		}
-	}
-
-	return imageNames
-}
-
 // createImageModel creates an ImageModel from a YAML image field
 func createImageModel(fieldValue *yaml.Node, serviceName string, filePath types.FilePath) types.ImageModel {
 	log.Debug().Msgf("Found image %s for service %s at line %d", fieldValue.Value, serviceName, fieldValue.Line)
@@ -1,8 +1,10 @@
 package extractors
 
 import (
-	"github.com/Checkmarx/containers-types/types"
+	"reflect"
 	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
 )
 
 func TestExtractImagesFromDockerComposeFiles(t *testing.T) {
@@ -61,7 +63,7 @@ func TestExtractImagesFromDockerComposeFile(t *testing.T) {
 		},
 	}
 
-	images, err := extractImagesFromDockerComposeFile(filePath, envVars)
+	images, err := ExtractImagesFromDockerComposeFiles([]types.FilePath{filePath}, envVars)
 	if err != nil {
 		t.Errorf("Error extracting images: %v", err)
 	}
@@ -130,3 +132,404 @@ func TestExtractImagesFromDockerComposeFiles_NoImagesFound(t *testing.T) {
 		t.Errorf("Expected 0 images, but got %d", len(images))
 	}
 }
+
+func TestExtractImagesFromDockerComposeFiles_Override(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/override/compose.override.yaml", RelativePath: "compose.override.yaml"},
+		{FullPath: "../../test_files/compose-testcases/override/compose.prod.yaml", RelativePath: "compose.prod.yaml"},
+		{FullPath: "../../test_files/compose-testcases/override/compose.yaml", RelativePath: "compose.yaml"},
+	}
+
+	images, err := ExtractImagesWithLineNumbersFromDockerComposeFiles(filePaths, nil, ComposeOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	expected := []types.ImageModel{
+		{Name: "ghcr.io/org/api:2.0", ImageLocations: []types.ImageLocation{
+			{Origin: types.DockerComposeFileOrigin, Path: "compose.prod.yaml", Line: 2, StartIndex: 11, EndIndex: 30},
+		}},
+		{Name: "ghcr.io/org/api:dev", ImageLocations: []types.ImageLocation{
+			{Origin: types.DockerComposeFileOrigin, Path: "compose.yaml", Line: 2, StartIndex: 11, EndIndex: 30},
+			{Origin: types.DockerComposeFileOrigin, Path: "compose.override.yaml", Line: 2, StartIndex: 11, EndIndex: 30},
+		}},
+		{Name: "postgres:16", ImageLocations: []types.ImageLocation{
+			{Origin: types.DockerComposeFileOrigin, Path: "compose.yaml", Line: 6, StartIndex: 11, EndIndex: 22},
+		}},
+		{Name: "redis:7", ImageLocations: []types.ImageLocation{
+			{Origin: types.DockerComposeFileOrigin, Path: "compose.override.yaml", Line: 4, StartIndex: 11, EndIndex: 18},
+		}},
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+
+	images, err = ExtractImagesFromDockerComposeFiles(filePaths, map[string]map[string]string{})
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	expectedNames := []string{"ghcr.io/org/api:2.0", "ghcr.io/org/api:dev", "postgres:16", "redis:7"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Expected images %v but got %v", expectedNames, names)
+	}
+	if len(images) > 1 && len(images[1].ImageLocations) != 2 {
+		t.Errorf("Expected the overridden image to keep both locations, got %+v", images[1].ImageLocations)
+	}
+}
+
+func TestGroupComposeProjects(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "a/docker-compose.override.yml"},
+		{FullPath: "a/docker-compose.yml"},
+		{FullPath: "a/compose.yaml"},
+		{FullPath: "b/compose.override.yaml"},
+		{FullPath: "c/docker-compose-2.yaml"},
+	}
+
+	expected := [][]types.FilePath{
+		{{FullPath: "a/docker-compose.yml"}},
+		{{FullPath: "a/compose.yaml"}, {FullPath: "a/docker-compose.override.yml"}},
+		{{FullPath: "b/compose.override.yaml"}},
+		{{FullPath: "c/docker-compose-2.yaml"}},
+	}
+
+	projects := groupComposeProjects(filePaths)
+	if !reflect.DeepEqual(projects, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, projects)
+	}
+}
+
+func TestGroupComposeProjects_IncludedFiles(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/include/compose.yaml", RelativePath: "compose.yaml"},
+		{FullPath: "../../test_files/compose-testcases/include/db/compose.yaml", RelativePath: "db/compose.yaml"},
+	}
+
+	expected := [][]types.FilePath{filePaths[:1]}
+	if projects := groupComposeProjects(filePaths); !reflect.DeepEqual(projects, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, projects)
+	}
+
+	images, err := ExtractImagesFromDockerComposeFiles(filePaths, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	if expectedNames := []string{"postgres:16", "ghcr.io/org/app:1.0"}; !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Expected images %v but got %v", expectedNames, names)
+	}
+}
+
+func TestExtractImagesWithLineNumbersFromDockerComposeFiles_SharedInclude(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/include-shared/compose.yaml", RelativePath: "compose.yaml"},
+		{FullPath: "../../test_files/compose-testcases/include-shared/compose.override.yaml", RelativePath: "compose.override.yaml"},
+	}
+
+	images, err := ExtractImagesWithLineNumbersFromDockerComposeFiles(filePaths, nil, ComposeOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	location := func(path string, line, start, end int) []types.ImageLocation {
+		return []types.ImageLocation{{Origin: types.DockerComposeFileOrigin, Path: path, Line: line, StartIndex: start, EndIndex: end}}
+	}
+	expected := []types.ImageModel{
+		{Name: "postgres:16", ImageLocations: location("common/db.yaml", 2, 11, 22)},
+		{Name: "redis:7.2", ImageLocations: location("common/db.yaml", 6, 11, 20)},
+		{Name: "ghcr.io/org/web:1.0", ImageLocations: location("web.yaml", 5, 11, 30)},
+		{Name: "ghcr.io/org/worker:1.0", ImageLocations: location("worker.yaml", 5, 11, 33)},
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+
+	services, err := loadComposeProject(filePaths)
+	if err != nil {
+		t.Fatalf("Error loading project: %v", err)
+	}
+	for _, service := range services {
+		if service.name == "db" && !reflect.DeepEqual(service.dependsOn, []string{"cache"}) {
+			t.Errorf("Expected db to depend on cache once but got %v", service.dependsOn)
+		}
+	}
+}
+
+func TestExtractImagesFromDockerComposeFiles_IncludedFileEnvironment(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/include-env/compose.yaml", RelativePath: "compose.yaml"},
+		{FullPath: "../../test_files/compose-testcases/include-env/db/compose.yaml", RelativePath: "db/compose.yaml"},
+	}
+	envFiles := map[string]map[string]string{
+		"../../test_files/compose-testcases/include-env":    {"TAG": "1.0"},
+		"../../test_files/compose-testcases/include-env/db": {"TAG": "16"},
+	}
+
+	images, err := ExtractImagesFromDockerComposeFiles(filePaths, envFiles)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	// The included file is interpolated with the .env of its own directory
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	expected := []string{"postgres:16", "ghcr.io/org/app:1.0"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected images %v but got %v", expected, names)
+	}
+}
+
+func TestExtractImagesWithLineNumbersFromDockerComposeFiles_IncludeAndExtends(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/extends/app/compose.yaml", RelativePath: "app/compose.yaml"},
+	}
+
+	images, err := ExtractImagesWithLineNumbersFromDockerComposeFiles(filePaths, nil, ComposeOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	location := func(path string, line, start, end int) []types.ImageLocation {
+		return []types.ImageLocation{{Origin: types.DockerComposeFileOrigin, Path: path, Line: line, StartIndex: start, EndIndex: end}}
+	}
+	expected := []types.ImageModel{
+		{Name: "prom/prometheus:v2.53.0", ImageLocations: location("shared/monitoring.yaml", 2, 11, 34)},
+		{Name: "ghcr.io/org/base:1.4", ImageLocations: location("common/services.yaml", 1, 9, 29)},
+		{Name: "ghcr.io/org/worker:3.1", ImageLocations: location("common/services.yaml", 8, 11, 33)},
+		{Name: "ghcr.io/org/worker:3.1", ImageLocations: location("common/services.yaml", 8, 11, 33)},
+		{Name: "ghcr.io/org/api:5.0", ImageLocations: location("app/compose.yaml", 20, 11, 30)},
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
+
+func TestExtractImageDetailsFromDockerComposeFiles_BuildOnlyServices(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/build/compose.yaml", RelativePath: "compose.yaml"},
+	}
+
+	images, err := ExtractImageDetailsFromDockerComposeFiles(filePaths, nil, ComposeOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	expected := []ImageDetails{
+		{
+			ImageModel: types.ImageModel{Name: "golang:1.23", ImageLocations: []types.ImageLocation{
+				{Origin: types.DockerFileOrigin, Path: "api/build/api.Dockerfile", FinalStage: true, Line: 1, StartIndex: 5, EndIndex: 25},
+			}},
+			DockerfileDetails: DockerfileDetails{Usage: DockerfileEdgeFrom},
+			ComposeDetails:    ComposeDetails{Service: "api"},
+		},
+		{
+			ImageModel: types.ImageModel{Name: "nginx:1.27-alpine", ImageLocations: []types.ImageLocation{
+				{Origin: types.DockerFileOrigin, Path: "web/Dockerfile", FinalStage: true, Line: 0, StartIndex: 5, EndIndex: 22},
+			}},
+			DockerfileDetails: DockerfileDetails{Usage: DockerfileEdgeFrom},
+			ComposeDetails:    ComposeDetails{Service: "web"},
+		},
+		{
+			ImageModel: types.ImageModel{Name: "alpine:3.20", ImageLocations: []types.ImageLocation{
+				{Origin: types.DockerComposeFileOrigin, Path: "compose.yaml", FinalStage: true, Line: 14, StartIndex: 13, EndIndex: 24},
+			}},
+			DockerfileDetails: DockerfileDetails{Usage: DockerfileEdgeFrom},
+			ComposeDetails:    ComposeDetails{Service: "tool"},
+		},
+		{
+			ImageModel: types.ImageModel{Name: "postgres:16", ImageLocations: []types.ImageLocation{
+				{Origin: types.DockerComposeFileOrigin, Path: "compose.yaml", Line: 19, StartIndex: 11, EndIndex: 22},
+			}},
+			ComposeDetails: ComposeDetails{Service: "db"},
+		},
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+
+	models, err := ExtractImagesFromDockerComposeFiles(filePaths, map[string]map[string]string{})
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	var names []string
+	for _, image := range models {
+		names = append(names, image.Name)
+	}
+	expectedNames := []string{"golang:1.23", "nginx:1.27-alpine", "alpine:3.20", "postgres:16"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Expected images %v but got %v", expectedNames, names)
+	}
+}
+
+func TestExtractImagesFromDockerComposeFiles_Interpolation(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/interpolation/docker-compose.yaml", RelativePath: "docker-compose.yaml"},
+	}
+	envVars := map[string]map[string]string{
+		"../../test_files/compose-testcases/interpolation": {
+			"REGISTRY": "ghcr.io/org",
+			"TAG":      "",
+			"SUFFIX":   "debug",
+		},
+	}
+
+	var diagnostics []Diagnostic
+	images, err := ExtractImagesFromDockerComposeFilesWithOptions(filePaths, envVars, ComposeOptions{}, func(diagnostic Diagnostic) {
+		diagnostics = append(diagnostics, diagnostic)
+	})
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	expectedNames := []string{"ghcr.io/org/api:latest", "ghcr.io/org/web:1.0", "ghcr.io/org/worker-debug:stable", "nginx:1.27"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Expected images %v but got %v", expectedNames, names)
+	}
+
+	expectedDiagnostics := []Diagnostic{
+		{Severity: DiagnosticError, Path: "docker-compose.yaml", Line: 8, Message: "required variable TOOLS_IMAGE is missing a value"},
+		{Severity: DiagnosticWarning, Path: "docker-compose.yaml", Line: 10, Message: "variable MIRROR is not set, defaulting to a blank string"},
+		{Severity: DiagnosticWarning, Path: "docker-compose.yaml", Line: 12, Message: "variable REDIS_TAG is not set, defaulting to a blank string"},
+		{Severity: DiagnosticError, Path: "docker-compose.yaml", Line: 12, Message: `image "redis:" of service cache is not a valid image reference`},
+	}
+	if !reflect.DeepEqual(diagnostics, expectedDiagnostics) {
+		t.Errorf("Expected diagnostics %+v but got %+v", expectedDiagnostics, diagnostics)
+	}
+}
+
+func TestImageReferencePattern(t *testing.T) {
+	valid := []string{
+		"nginx", "nginx:1.27", "library/nginx:latest", "ghcr.io/org/api:1.0", "localhost:5000/app",
+		"registry.example.com:443/team/app_name:v1.2.3-rc.1", "[::1]:5000/app:1",
+		"alpine@sha256:4edbd2beb5f78b1014028f4fbb99f3237d9561100b6881aabbf5acce2c4f9454",
+		"alpine:3.19@sha256:4edbd2beb5f78b1014028f4fbb99f3237d9561100b6881aabbf5acce2c4f9454",
+	}
+	for _, image := range valid {
+		if !imageReferencePattern.MatchString(image) {
+			t.Errorf("Expected %s to be a valid image reference", image)
+		}
+	}
+	invalid := []string{"", "redis:", ":1.0", "/api:1.0", "ghcr.io/org/", "ghcr.io/Org/api", "nginx:1 .0", "alpine@sha256:123", "api::1.0"}
+	for _, image := range invalid {
+		if imageReferencePattern.MatchString(image) {
+			t.Errorf("Expected %s not to be a valid image reference", image)
+		}
+	}
+}
+
+func TestExtractImageDetailsFromDockerComposeFiles_Profiles(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/profiles/compose.yaml", RelativePath: "compose.yaml"},
+	}
+
+	tests := []struct {
+		name     string
+		options  ComposeOptions
+		expected []string
+	}{
+		{"all services", ComposeOptions{}, []string{"web", "api", "cache", "debugger", "migrations"}},
+		{"no active profile", ComposeOptions{Profiles: []string{}}, []string{"web", "api"}},
+		{"active profile", ComposeOptions{Profiles: []string{"debug"}}, []string{"web", "api", "debugger"}},
+		{"every profile", ComposeOptions{Profiles: []string{"*"}}, []string{"web", "api", "cache", "debugger", "migrations"}},
+		{"selected services", ComposeOptions{Services: []string{"api", "migrations"}}, []string{"api", "cache", "migrations"}},
+		{"unknown service", ComposeOptions{Services: []string{"missing"}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images, err := ExtractImageDetailsFromDockerComposeFiles(filePaths, nil, tt.options, nil)
+			if err != nil {
+				t.Fatalf("Error extracting images: %v", err)
+			}
+			var services []string
+			for _, image := range images {
+				services = append(services, image.Service)
+			}
+			if !reflect.DeepEqual(services, tt.expected) {
+				t.Errorf("Expected services %v but got %v", tt.expected, services)
+			}
+		})
+	}
+
+	images, err := ExtractImageDetailsFromDockerComposeFiles(filePaths, nil, ComposeOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	if len(images) != 5 || !reflect.DeepEqual(images[3].Profiles, []string{"debug", "tooling"}) || images[0].Profiles != nil {
+		t.Errorf("Expected the images to record the profiles of their service, got %+v", images)
+	}
+}
+
+func TestExtractImagesFromDockerComposeFilesWithOptions_Profiles(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/profiles/compose.yaml", RelativePath: "compose.yaml"},
+	}
+
+	images, err := ExtractImagesFromDockerComposeFilesWithOptions(filePaths, nil, ComposeOptions{Profiles: []string{"tooling"}}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	expected := []string{"nginx:1.27", "ghcr.io/org/api:2.0", "ghcr.io/org/debugger:0.3", "ghcr.io/org/migrations:1.1"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v but got %v", expected, names)
+	}
+}
+
+func TestExtractImagesWithLineNumbersFromDockerComposeFiles_Interpolation(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/interpolation/docker-compose.yaml", RelativePath: "docker-compose.yaml"},
+	}
+	envVars := map[string]map[string]string{
+		"../../test_files/compose-testcases/interpolation": {
+			"REGISTRY": "ghcr.io/org",
+			"WEB_TAG":  "2.1",
+		},
+	}
+
+	images, err := ExtractImagesWithLineNumbersFromDockerComposeFiles(filePaths, envVars, ComposeOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	location := func(line, start, end int) []types.ImageLocation {
+		return []types.ImageLocation{{Origin: types.DockerComposeFileOrigin, Path: "docker-compose.yaml", Line: line, StartIndex: start, EndIndex: end}}
+	}
+	expected := []types.ImageModel{
+		{Name: "ghcr.io/org/api:latest", ImageLocations: location(2, 11, 84)},
+		{Name: "ghcr.io/org/web:2.1", ImageLocations: location(4, 11, 39)},
+		{Name: "ghcr.io/org/worker:stable", ImageLocations: location(6, 11, 78)},
+		{Name: "nginx:1.27", ImageLocations: location(10, 11, 30)},
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+
+	models, err := ExtractImagesFromDockerComposeFilesWithOptions(filePaths, envVars, ComposeOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	for i, model := range models {
+		if model.Name != expected[i].Name {
+			t.Errorf("Expected both extraction modes to report %s but got %s", expected[i].Name, model.Name)
+		}
+	}
+}
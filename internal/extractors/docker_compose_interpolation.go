@@ -0,0 +1,158 @@
+package extractors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// composeInterpolator expands variables in compose values following the Compose Specification:
+// $VAR and ${VAR}, the ${VAR:-default}, ${VAR-default}, ${VAR:+alt}, ${VAR+alt}, ${VAR:?err} and
+// ${VAR?err} modifiers, whose values may hold further interpolations, and $$ for a literal $.
+type composeInterpolator struct {
+	lookup func(name string) (string, bool)
+	// unset collects the variables that were referenced without a default while unset.
+	unset []string
+}
+
+// composeRequiredError is returned when a ${VAR:?err} or ${VAR?err} variable is missing.
+type composeRequiredError struct {
+	name    string
+	message string
+}
+
+func (e *composeRequiredError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("required variable %s is missing a value", e.name)
+	}
+	return fmt.Sprintf("required variable %s is missing a value: %s", e.name, e.message)
+}
+
+func newComposeInterpolator(vars map[string]string) *composeInterpolator {
+	return &composeInterpolator{lookup: func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}}
+}
+
+func (c *composeInterpolator) interpolate(value string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' {
+			sb.WriteByte(value[i])
+			continue
+		}
+		if i+1 == len(value) {
+			return "", fmt.Errorf("invalid interpolation format for %q", value)
+		}
+
+		switch next := value[i+1]; {
+		case next == '$':
+			sb.WriteByte('$')
+			i++
+		case next == '{':
+			end := closingBrace(value, i+2)
+			if end == -1 {
+				return "", fmt.Errorf("invalid interpolation format for %q: missing closing brace", value)
+			}
+			expanded, err := c.expandBraced(value[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(expanded)
+			i = end
+		case isVariableStart(next):
+			end := i + 1
+			for end < len(value) && isVariableChar(value[end]) {
+				end++
+			}
+			sb.WriteString(c.variable(value[i+1 : end]))
+			i = end - 1
+		default:
+			return "", fmt.Errorf("invalid interpolation format for %q", value)
+		}
+	}
+	return sb.String(), nil
+}
+
+// expandBraced expands the content of a ${...} expression.
+func (c *composeInterpolator) expandBraced(expression string) (string, error) {
+	nameEnd := 0
+	for nameEnd < len(expression) && isVariableChar(expression[nameEnd]) {
+		nameEnd++
+	}
+	name, rest := expression[:nameEnd], expression[nameEnd:]
+	if name == "" || !isVariableStart(name[0]) {
+		return "", fmt.Errorf("invalid interpolation format for ${%s}", expression)
+	}
+	if rest == "" {
+		return c.variable(name), nil
+	}
+
+	value, set := c.lookup(name)
+	checkEmpty := strings.HasPrefix(rest, ":")
+	operator := strings.TrimPrefix(rest, ":")
+	if operator == "" {
+		return "", fmt.Errorf("invalid interpolation format for ${%s}", expression)
+	}
+	missing := !set || (checkEmpty && value == "")
+
+	switch operator[0] {
+	case '-':
+		if missing {
+			return c.interpolate(operator[1:])
+		}
+		return value, nil
+	case '+':
+		if missing {
+			return "", nil
+		}
+		return c.interpolate(operator[1:])
+	case '?':
+		if missing {
+			message, err := c.interpolate(operator[1:])
+			if err != nil {
+				return "", err
+			}
+			return "", &composeRequiredError{name: name, message: message}
+		}
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid interpolation format for ${%s}", expression)
+	}
+}
+
+func (c *composeInterpolator) variable(name string) string {
+	value, ok := c.lookup(name)
+	if !ok {
+		c.unset = append(c.unset, name)
+	}
+	return value
+}
+
+// closingBrace returns the index of the } closing a ${ whose content starts at start, or -1.
+func closingBrace(value string, start int) int {
+	depth := 1
+	for i := start; i < len(value); i++ {
+		switch {
+		case value[i] == '$' && i+1 < len(value) && value[i+1] == '$':
+			i++
+		case value[i] == '$' && i+1 < len(value) && value[i+1] == '{':
+			depth++
+			i++
+		case value[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isVariableStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isVariableChar(c byte) bool {
+	return isVariableStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,71 @@
+package extractors
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestComposeInterpolator(t *testing.T) {
+	vars := map[string]string{
+		"REGISTRY": "ghcr.io/org",
+		"EMPTY":    "",
+		"TAG":      "1.2",
+	}
+
+	tests := []struct {
+		value    string
+		expected string
+		unset    []string
+		required bool
+		invalid  bool
+	}{
+		{value: "nginx:1.27", expected: "nginx:1.27"},
+		{value: "$REGISTRY/app", expected: "ghcr.io/org/app"},
+		{value: "${REGISTRY}/app:${TAG}", expected: "ghcr.io/org/app:1.2"},
+		{value: "app:${MISSING:-latest}", expected: "app:latest"},
+		{value: "app:${EMPTY:-latest}", expected: "app:latest"},
+		{value: "app:${EMPTY-latest}", expected: "app:"},
+		{value: "app:${MISSING-latest}", expected: "app:latest"},
+		{value: "app${TAG:+-$TAG}", expected: "app-1.2"},
+		{value: "app${EMPTY:+-x}", expected: "app"},
+		{value: "app${EMPTY+-x}", expected: "app-x"},
+		{value: "app:${MISSING:-${OTHER:-${TAG}}}", expected: "app:1.2"},
+		{value: "app:${TAG:-${MISSING}}", expected: "app:1.2"},
+		{value: "price$$1", expected: "price$1"},
+		{value: "$${REGISTRY}", expected: "${REGISTRY}"},
+		{value: "${MISSING}/app", expected: "/app", unset: []string{"MISSING"}},
+		{value: "app:${TAG:?tag is required}", expected: "app:1.2"},
+		{value: "app:${EMPTY?}", expected: "app:"},
+		{value: "app:${EMPTY:?tag is required}", required: true},
+		{value: "app:${MISSING?}", required: true},
+		{value: "app:${TAG", invalid: true},
+		{value: "app:${-x}", invalid: true},
+		{value: "app:$", invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			interpolator := newComposeInterpolator(vars)
+			result, err := interpolator.interpolate(test.value)
+
+			var requiredErr *composeRequiredError
+			switch {
+			case test.required:
+				if !errors.As(err, &requiredErr) {
+					t.Errorf("Expected a required variable error, got %v", err)
+				}
+			case test.invalid:
+				if err == nil || errors.As(err, &requiredErr) {
+					t.Errorf("Expected an invalid format error, got %v", err)
+				}
+			case err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			case result != test.expected:
+				t.Errorf("Expected %q but got %q", test.expected, result)
+			case !reflect.DeepEqual(interpolator.unset, test.unset):
+				t.Errorf("Expected unset variables %v but got %v", test.unset, interpolator.unset)
+			}
+		})
+	}
+}
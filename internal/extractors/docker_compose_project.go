@@ -0,0 +1,522 @@
+package extractors
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// composeDefaultFiles are the file names docker compose loads when no -f flag is given, in order of preference.
+var composeDefaultFiles = []string{"compose.yaml", "compose.yml", "docker-compose.yml", "docker-compose.yaml"}
+
+// composeOverrideFiles are the file names docker compose merges on top of the default file, in order of preference.
+var composeOverrideFiles = []string{"compose.override.yml", "compose.override.yaml", "docker-compose.override.yml", "docker-compose.override.yaml"}
+
+// composeService is a service of a compose project, merged across the files of the project.
+type composeService struct {
+	name string
+	// image is the effective image node of the service, nil when no file sets an image.
+	image *yaml.Node
+	// imageSources are the image nodes of every file that sets the image, in file order, with the file they come from.
+	imageSources []composeImageSource
+	// build is the effective build section of the service, nil when no file sets one.
+	build *composeBuild
+	// profiles are the profiles enabling the service, empty when the service is always enabled.
+	profiles []string
+	// dependsOn are the names of the services listed in depends_on.
+	dependsOn []string
+}
+
+// composeBuild is the build section of a compose service. Its values are the nodes as written, nil when not set.
+type composeBuild struct {
+	// file is the compose file declaring the build, which context is relative to.
+	file       types.FilePath
+	context    *yaml.Node
+	dockerfile *yaml.Node
+	target     *yaml.Node
+	// inline is the dockerfile_inline node, nil when the Dockerfile is a file.
+	inline *yaml.Node
+	args   map[string]*yaml.Node
+}
+
+type composeImageSource struct {
+	file types.FilePath
+	node *yaml.Node
+	// project is the file whose directory is the project directory of the source, where its variables
+	// come from: the first file of the project, or the included file that declares the service.
+	project types.FilePath
+}
+
+// groupComposeProjects groups compose files the way docker compose loads them by default: in each
+// directory the preferred default file is merged with the preferred override file. Every other
+// file is a project of its own, except files included by another one, which are loaded with it.
+func groupComposeProjects(filePaths []types.FilePath) [][]types.FilePath {
+	included := includedComposeFiles(filePaths)
+	filePaths = slices.DeleteFunc(slices.Clone(filePaths), func(filePath types.FilePath) bool {
+		return included[filepath.Clean(filePath.FullPath)]
+	})
+
+	bases := make(map[string]string)
+	overrides := make(map[string]types.FilePath)
+	for _, filePath := range filePaths {
+		dir, name := filepath.Split(filePath.FullPath)
+		if preferredComposeFile(composeDefaultFiles, name, bases[dir]) {
+			bases[dir] = name
+		}
+		if preferredComposeFile(composeOverrideFiles, name, filepath.Base(overrides[dir].FullPath)) {
+			overrides[dir] = filePath
+		}
+	}
+
+	var projects [][]types.FilePath
+	for _, filePath := range filePaths {
+		dir, name := filepath.Split(filePath.FullPath)
+		override, hasOverride := overrides[dir]
+		switch {
+		case hasOverride && bases[dir] == name:
+			projects = append(projects, []types.FilePath{filePath, override})
+		case hasOverride && bases[dir] != "" && override == filePath:
+			continue
+		default:
+			projects = append(projects, []types.FilePath{filePath})
+		}
+	}
+	return projects
+}
+
+// includedComposeFiles returns the cleaned FullPath of the files included, directly or through other
+// included files, by the compose files of filePaths. A file included by a file that is itself
+// included is not followed again, so that include cycles keep one of their files.
+func includedComposeFiles(filePaths []types.FilePath) map[string]bool {
+	loader := &composeLoader{documents: make(map[string]*yaml.Node)}
+	included := make(map[string]bool)
+
+	var follow func(root string, filePath types.FilePath)
+	follow = func(root string, filePath types.FilePath) {
+		document, err := loader.document(filePath)
+		if err != nil {
+			return
+		}
+		for _, include := range composeIncludes(document, filePath) {
+			path := filepath.Clean(include.FullPath)
+			if path == root || included[path] {
+				continue
+			}
+			included[path] = true
+			follow(root, include)
+		}
+	}
+	for _, filePath := range filePaths {
+		if path := filepath.Clean(filePath.FullPath); !included[path] {
+			follow(path, filePath)
+		}
+	}
+	return included
+}
+
+// preferredComposeFile reports whether name is one of names and comes before current in it.
+func preferredComposeFile(names []string, name, current string) bool {
+	for _, candidate := range names {
+		if candidate == current {
+			return false
+		}
+		if candidate == name {
+			return true
+		}
+	}
+	return false
+}
+
+// composeLoader loads the services of a compose project, following include and extends to other files.
+type composeLoader struct {
+	services []*composeService
+	byName   map[string]*composeService
+	// documents caches the parsed files by FullPath.
+	documents map[string]*yaml.Node
+	// including holds the files whose includes are being loaded, to stop include cycles.
+	including map[string]bool
+	// loaded holds the files already loaded by FullPath, so that a file included by several files of
+	// the project is loaded once.
+	loaded map[string]bool
+}
+
+type yamlEntry struct {
+	key   *yaml.Node
+	value *yaml.Node
+}
+
+// loadComposeProject reads the services of a compose project. Files are merged in order, so a later
+// file replaces the image of a service declared in an earlier one. Services of included files are
+// added to the project, and services that extend another service inherit the image it sets.
+func loadComposeProject(files []types.FilePath) ([]*composeService, error) {
+	loader := &composeLoader{
+		byName:    make(map[string]*composeService),
+		documents: make(map[string]*yaml.Node),
+		including: make(map[string]bool),
+		loaded:    make(map[string]bool),
+	}
+
+	for _, filePath := range files {
+		if err := loader.loadFile(filePath, files[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	return loader.services, nil
+}
+
+// loadFile loads the services of a file of the project directory of project. A file it includes is
+// the project directory of its own services, like docker compose loads it.
+func (l *composeLoader) loadFile(filePath, project types.FilePath) error {
+	if l.loaded[filepath.Clean(filePath.FullPath)] {
+		return nil
+	}
+	root, err := l.document(filePath)
+	if err != nil {
+		return err
+	}
+	l.loaded[filepath.Clean(filePath.FullPath)] = true
+
+	l.including[filePath.FullPath] = true
+	defer delete(l.including, filePath.FullPath)
+
+	for _, included := range composeIncludes(root, filePath) {
+		if l.including[included.FullPath] {
+			log.Warn().Msgf("Skipping include cycle on docker compose file %s", included.FullPath)
+			continue
+		}
+		if err := l.loadFile(included, included); err != nil {
+			log.Warn().Msgf("could not load docker compose file %s included by %s err: %+v", included.FullPath, filePath.RelativePath, err)
+		}
+	}
+
+	for _, entry := range mappingEntries(findServicesNode(root)) {
+		name := entry.key.Value
+		service, ok := l.byName[name]
+		if !ok {
+			service = &composeService{name: name}
+			l.byName[name] = service
+			l.services = append(l.services, service)
+		}
+
+		image, imageFile := l.serviceField(filePath, entry.value, "image", make(map[string]bool))
+		if image != nil {
+			service.image = image
+			service.imageSources = append(service.imageSources, composeImageSource{file: imageFile, node: image, project: project})
+		}
+
+		if build, buildFile := l.serviceField(filePath, entry.value, "build", make(map[string]bool)); build != nil {
+			service.build = parseComposeBuild(build, buildFile)
+		}
+		if profiles, _ := l.serviceField(filePath, entry.value, "profiles", make(map[string]bool)); profiles != nil {
+			service.profiles = sequenceValues(profiles)
+		}
+		if dependsOn, _ := l.serviceField(filePath, entry.value, "depends_on", make(map[string]bool)); dependsOn != nil {
+			service.dependsOn = append(service.dependsOn, composeDependencies(dependsOn)...)
+		}
+	}
+
+	return nil
+}
+
+// serviceField returns a field of a service and the file it is set in. A field the service does not
+// set itself is looked up in the service it extends, which may live in another file.
+func (l *composeLoader) serviceField(filePath types.FilePath, service *yaml.Node, key string, visited map[string]bool) (*yaml.Node, types.FilePath) {
+	if value := mappingValue(service, key); value != nil {
+		return value, filePath
+	}
+
+	extends := mappingValue(service, "extends")
+	if extends == nil {
+		return nil, filePath
+	}
+
+	baseName, baseFile := extends.Value, filePath
+	if extends.Kind == yaml.MappingNode {
+		baseName = scalarValue(mappingValue(extends, "service"))
+		if file := scalarValue(mappingValue(extends, "file")); file != "" {
+			baseFile = relatedFile(filePath, file)
+		}
+	}
+
+	visitKey := baseFile.FullPath + "#" + baseName
+	if baseName == "" || visited[visitKey] {
+		return nil, filePath
+	}
+	visited[visitKey] = true
+
+	root, err := l.document(baseFile)
+	if err != nil {
+		log.Warn().Msgf("could not load docker compose file %s extended by %s err: %+v", baseFile.FullPath, filePath.RelativePath, err)
+		return nil, filePath
+	}
+	base := mappingValue(findServicesNode(root), baseName)
+	if base == nil {
+		log.Warn().Msgf("Service %s extended in %s was not found in %s", baseName, filePath.RelativePath, baseFile.RelativePath)
+		return nil, filePath
+	}
+
+	return l.serviceField(baseFile, base, key, visited)
+}
+
+// composeDependencies returns the service names of a depends_on, either a list or a mapping.
+func composeDependencies(node *yaml.Node) []string {
+	if node.Kind != yaml.MappingNode {
+		return sequenceValues(node)
+	}
+	var names []string
+	for _, entry := range mappingEntries(node) {
+		names = append(names, entry.key.Value)
+	}
+	return names
+}
+
+// selectComposeServices returns the services docker compose would run with the given options.
+func selectComposeServices(services []*composeService, options ComposeOptions) []*composeService {
+	if len(options.Services) == 0 && options.Profiles == nil {
+		return services
+	}
+
+	byName := make(map[string]*composeService, len(services))
+	for _, service := range services {
+		byName[service.name] = service
+	}
+
+	selected := make(map[string]bool)
+	if len(options.Services) > 0 {
+		pending := append([]string{}, options.Services...)
+		for len(pending) > 0 {
+			name := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			service, ok := byName[name]
+			if !ok || selected[name] {
+				continue
+			}
+			selected[name] = true
+			pending = append(pending, service.dependsOn...)
+		}
+	} else {
+		for _, service := range services {
+			selected[service.name] = profileEnabled(service.profiles, options.Profiles)
+		}
+	}
+
+	var result []*composeService
+	for _, service := range services {
+		if selected[service.name] {
+			result = append(result, service)
+		}
+	}
+	return result
+}
+
+// profileEnabled reports whether a service with the given profiles runs when active profiles are enabled.
+func profileEnabled(profiles, active []string) bool {
+	if len(profiles) == 0 {
+		return true
+	}
+	for _, profile := range active {
+		if profile == "*" || slices.Contains(profiles, profile) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseComposeBuild reads a build section, either a context path or a mapping.
+func parseComposeBuild(node *yaml.Node, filePath types.FilePath) *composeBuild {
+	build := &composeBuild{file: filePath}
+	if node.Kind != yaml.MappingNode {
+		build.context = scalarNode(node)
+		return build
+	}
+
+	build.context = scalarNode(mappingValue(node, "context"))
+	build.dockerfile = scalarNode(mappingValue(node, "dockerfile"))
+	build.inline = scalarNode(mappingValue(node, "dockerfile_inline"))
+	build.target = scalarNode(mappingValue(node, "target"))
+
+	args := mappingValue(node, "args")
+	if args == nil {
+		return build
+	}
+	build.args = make(map[string]*yaml.Node)
+	switch args.Kind {
+	case yaml.MappingNode:
+		for _, entry := range mappingEntries(args) {
+			if value := scalarNode(entry.value); value != nil && value.Tag != "!!null" {
+				build.args[entry.key.Value] = value
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range args.Content {
+			if key, value, ok := strings.Cut(scalarValue(item), "="); ok {
+				build.args[key] = &yaml.Node{Kind: yaml.ScalarNode, Value: value, Line: item.Line, Column: item.Column}
+			}
+		}
+	}
+	return build
+}
+
+func (l *composeLoader) document(filePath types.FilePath) (*yaml.Node, error) {
+	if root, ok := l.documents[filePath.FullPath]; ok {
+		return root, nil
+	}
+	root, err := readComposeFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	l.documents[filePath.FullPath] = root
+	return root, nil
+}
+
+// composeIncludes returns the files listed in the top-level include of a compose file. Entries are
+// either a path or a mapping whose path is a path or a list of paths.
+func composeIncludes(root *yaml.Node, filePath types.FilePath) []types.FilePath {
+	var includes []types.FilePath
+	for _, document := range root.Content {
+		include := resolveAlias(mappingValue(document, "include"))
+		if include == nil || include.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, item := range include.Content {
+			item = resolveAlias(item)
+			paths := []*yaml.Node{item}
+			if item.Kind == yaml.MappingNode {
+				paths = []*yaml.Node{resolveAlias(mappingValue(item, "path"))}
+				if paths[0] != nil && paths[0].Kind == yaml.SequenceNode {
+					paths = paths[0].Content
+				}
+			}
+			for _, pathNode := range paths {
+				if ref := scalarValue(pathNode); ref != "" {
+					includes = append(includes, relatedFile(filePath, ref))
+				}
+			}
+		}
+	}
+	return includes
+}
+
+// mappingEntries returns the entries of a mapping node, resolving aliases and merge keys. Keys set
+// explicitly take precedence over merged ones, and earlier merged mappings over later ones.
+func mappingEntries(node *yaml.Node) []yamlEntry {
+	node = resolveAlias(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var entries, merged []yamlEntry
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Tag == "!!merge" {
+			value = resolveAlias(value)
+			sources := []*yaml.Node{value}
+			if value.Kind == yaml.SequenceNode {
+				sources = value.Content
+			}
+			for _, source := range sources {
+				merged = append(merged, mappingEntries(source)...)
+			}
+			continue
+		}
+		seen[key.Value] = true
+		entries = append(entries, yamlEntry{key: key, value: value})
+	}
+
+	for _, entry := range merged {
+		if !seen[entry.key.Value] {
+			seen[entry.key.Value] = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// mappingValue returns the value of key in a mapping node, resolving aliases and merge keys.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for _, entry := range mappingEntries(node) {
+		if entry.key.Value == key {
+			return resolveAlias(entry.value)
+		}
+	}
+	return nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+func scalarValue(node *yaml.Node) string {
+	if node = scalarNode(node); node == nil {
+		return ""
+	}
+	return node.Value
+}
+
+// sequenceValues returns the scalar values of a sequence node, or of a single scalar node.
+func sequenceValues(node *yaml.Node) []string {
+	node = resolveAlias(node)
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.ScalarNode {
+		return []string{node.Value}
+	}
+	var values []string
+	for _, item := range node.Content {
+		if value := scalarValue(item); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// scalarSpan returns the 0-based line of a scalar and the span of its value, without quotes.
+func scalarSpan(node *yaml.Node) (line, start, end int) {
+	start = node.Column - 1
+	if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0 {
+		start++
+	}
+	return node.Line - 1, start, start + len(node.Value)
+}
+
+// scalarNode resolves node to a scalar, or nil when it is not one.
+func scalarNode(node *yaml.Node) *yaml.Node {
+	node = resolveAlias(node)
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return nil
+	}
+	return node
+}
+
+func readComposeFile(filePath types.FilePath) (*yaml.Node, error) {
+	file, err := os.Open(filePath.FullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Warn().Msgf("Could not close docker compose file: %s err: %+v", file.Name(), err)
+		}
+	}(file)
+
+	var root yaml.Node
+	if err := yaml.NewDecoder(file).Decode(&root); err != nil && !errors.Is(err, io.EOF) {
+		log.Err(err).Msg("Error parsing docker-compose file")
+		return nil, err
+	}
+	return &root, nil
+}
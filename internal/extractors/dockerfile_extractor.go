@@ -1,178 +1,407 @@
 package extractors
 
 import (
-	"bufio"
 	"fmt"
-	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/Checkmarx/containers-types/types"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
 	"github.com/rs/zerolog/log"
 )
 
+// DockerfileBuildOptions holds the docker build flags that change which images a Dockerfile pulls.
+type DockerfileBuildOptions struct {
+	// BuildArgs overrides the value of declared ARGs, like --build-arg.
+	BuildArgs map[string]string
+	// Target is the stage to build, like --target. When empty the last stage is built.
+	Target string
+	// Platform is the platform to build for, like --platform. It is the value of TARGETPLATFORM
+	// and the platform of stages whose FROM has no --platform flag. It defaults to BuildPlatform.
+	Platform string
+	// BuildPlatform is the platform of the machine running the build, the value of BUILDPLATFORM.
+	BuildPlatform string
+}
+
+// dockerfileStage is a build stage of a Dockerfile together with the stages it needs.
+type dockerfileStage struct {
+	name string
+	// baseName is the expanded value of the FROM instruction.
+	baseName string
+	// platform is the expanded FROM --platform flag, or the target platform when the flag is not set.
+	// It is empty when the platform is not known.
+	platform string
+	// line is the 0-based line of the FROM instruction.
+	line int
+	// parent is the index of the stage this stage is built FROM, or -1 when it is built from an image.
+	parent int
+	// dependencies are the indexes of the stages used by COPY --from and RUN --mount=from.
+	dependencies []int
+	// image is the base image of the stage, nil for stages built from another stage or from scratch.
+	image *ImageDetails
+	// referencedImages are the images used by COPY --from and RUN --mount=from.
+	referencedImages []ImageDetails
+	// references are all the COPY --from and RUN --mount=from values of the stage, in order.
+	references []stageReference
+}
+
+// stageReference is a COPY --from or RUN --mount=from value.
+type stageReference struct {
+	kind string
+	ref  string
+	// stage is the index of the referenced stage, or -1 when ref is an image.
+	stage int
+	line  int
+}
+
 func ExtractImagesFromDockerfiles(filePaths []types.FilePath, envFiles map[string]map[string]string) ([]types.ImageModel, error) {
-	var imageNames []types.ImageModel
+	return ExtractImagesFromDockerfilesWithOptions(filePaths, envFiles, nil)
+}
+
+// ExtractImagesFromDockerfilesWithOptions extracts images from Dockerfiles, applying the build options
+// found in buildOptions under the Dockerfile RelativePath.
+func ExtractImagesFromDockerfilesWithOptions(filePaths []types.FilePath, envFiles map[string]map[string]string,
+	buildOptions map[string]DockerfileBuildOptions) ([]types.ImageModel, error) {
+	details, err := ExtractImageDetailsFromDockerfiles(filePaths, envFiles, buildOptions)
+	return ImageModels(details), err
+}
+
+// ExtractImageDetailsFromDockerfiles is ExtractImagesFromDockerfilesWithOptions returning every
+// occurrence of an image with its platform and usage.
+func ExtractImageDetailsFromDockerfiles(filePaths []types.FilePath, envFiles map[string]map[string]string,
+	buildOptions map[string]DockerfileBuildOptions) ([]ImageDetails, error) {
+	var imageNames []ImageDetails
 
 	for _, filePath := range filePaths {
 		log.Debug().Msgf("going to extract images from dockerfile %s", filePath)
 
-		fileImages, err := extractImagesFromDockerfile(filePath, envFiles)
+		fileImages, err := extractImagesFromDockerfile(filePath, envFiles, buildOptions[filePath.RelativePath])
 		if err != nil {
 			log.Warn().Msgf("could not extract images from dockerfile %s err: %+v", filePath, err)
 		}
-		printFoundImagesInFile(filePath.RelativePath, fileImages)
+		printFoundImagesInFile(filePath.RelativePath, ImageModels(fileImages))
 		imageNames = append(imageNames, fileImages...)
 	}
 
 	return imageNames, nil
 }
 
-func extractImagesFromDockerfile(filePath types.FilePath, envFiles map[string]map[string]string) ([]types.ImageModel, error) {
-	var imageNames []types.ImageModel
-	aliases := make(map[string]string)
-	argsAndEnv := make(map[string]string)
-	mergedEnvVars := resolveEnvVariables(filePath.FullPath, envFiles)
+func extractImagesFromDockerfile(filePath types.FilePath, envFiles map[string]map[string]string, buildOptions DockerfileBuildOptions) ([]ImageDetails, error) {
+	dockerfile, err := parseDockerfile(filePath.FullPath)
+	if err != nil {
+		return nil, err
+	}
+	return extractImagesFromDockerfileSource(dockerfile, filePath, envFiles, buildOptions)
+}
+
+// extractImagesFromDockerfileSource extracts the images of a parsed Dockerfile, reporting them against filePath.
+func extractImagesFromDockerfileSource(dockerfile *dockerfileSource, filePath types.FilePath, envFiles map[string]map[string]string,
+	buildOptions DockerfileBuildOptions) ([]ImageDetails, error) {
+	stages := parseDockerfileStages(dockerfile, filePath, envFiles, buildOptions)
+	if len(stages) == 0 {
+		return nil, nil
+	}
 
-	file, err := os.Open(filePath.FullPath)
+	target, err := resolveTargetStage(stages, buildOptions.Target)
 	if err != nil {
 		return nil, err
 	}
-	defer func(file *os.File) {
-		err = file.Close()
-		if err != nil {
-			log.Warn().Msgf("Could not close dockerfile: %s err: %+v", file.Name(), err)
+
+	// A target stage built FROM scratch ships no image, so none is marked as the final stage
+	if finalImage := stageBaseImage(stages, target); finalImage != nil {
+		finalImage.ImageLocations[0].FinalStage = true
+	}
+
+	reachable := reachableStages(stages, target)
+
+	var imageNames []ImageDetails
+	if dockerfile.syntax != "" {
+		imageNames = append(imageNames, newDockerfileImage(dockerfile.syntax, DockerfileSyntax, buildOptions.BuildPlatform, filePath, dockerfile.syntaxSpan))
+	}
+	for i, stage := range stages {
+		if buildOptions.Target != "" && !reachable[i] {
+			continue
 		}
-	}(file)
-
-	scanner := bufio.NewScanner(file)
-	lineNum := -1 // Start from -1 so first line becomes 0 (0-based indexing)
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineNum++ // Increment at the beginning to ensure it's always updated
-
-		// Parse ARG and ENV lines within the Dockerfile
-		if match := regexp.MustCompile(`^\s*(ARG|ENV)\s+(\w+)=([^\s]+)`).FindStringSubmatch(line); match != nil {
-			varName := match[2]
-			varValue := match[3]
-			argsAndEnv[varName] = varValue
+		if stage.image != nil {
+			imageNames = append(imageNames, *stage.image)
 		}
+		imageNames = append(imageNames, stage.referencedImages...)
+	}
 
-		// Replace placeholders with values from mergedEnvVars and argsAndEnv
-		line = replacePlaceholders(line, mergedEnvVars, argsAndEnv)
+	return imageNames, nil
+}
+
+// parseDockerfileStages walks the instructions of a Dockerfile and returns its build stages in order.
+func parseDockerfileStages(dockerfile *dockerfileSource, filePath types.FilePath, envFiles map[string]map[string]string, buildOptions DockerfileBuildOptions) []*dockerfileStage {
+	var stages []*dockerfileStage
+	mergedEnvVars := resolveEnvVariables(filePath.FullPath, envFiles)
+
+	scope := newDockerfileScope(dockerfile.escapeToken, mergedEnvVars, buildOptions.BuildArgs)
+	scope.definePlatformArgs(buildOptions.BuildPlatform, targetPlatform(buildOptions))
 
-		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+	for _, node := range dockerfile.nodes {
+		instruction, err := instructions.ParseInstruction(expandMountFroms(node, scope))
+		if err != nil {
+			log.Debug().Msgf("Skipping unparsable instruction at line %d of dockerfile %s: %v", node.StartLine, filePath.RelativePath, err)
 			continue
 		}
 
-		// Parse FROM instructions
-		if match := regexp.MustCompile(`(?i)\bFROM\s+(?:--platform=[^\s]+\s+)?([^\s:@]+(?::[^\s@]+)?(?:@sha256:[a-fA-F0-9]{64})?)(?:\s+AS\s+([a-zA-Z0-9][a-zA-Z0-9_.-]*))?`).FindStringSubmatch(line); match != nil {
-			imageName := match[1]
-			alias := match[2]
-
-			if imageName == "scratch" {
+		switch cmd := instruction.(type) {
+		case *instructions.ArgCommand:
+			scope.declareArgs(cmd)
+		case *instructions.EnvCommand:
+			scope.declareEnv(cmd)
+		case *instructions.CopyCommand:
+			if len(stages) == 0 || cmd.From == "" {
 				continue
 			}
-
-			if alias != "" {
-				realName := resolveAlias(alias, aliases)
-				if realName != "" {
-					aliases[alias] = realName
-				} else {
-					aliases[alias] = imageName
+			flagSpans, _ := dockerfile.locateNode(node)
+			for i, flag := range node.Flags {
+				if strings.HasPrefix(flag, "--from=") {
+					span := flagValueSpan(flag, flagSpans[i], "--from=", cmd.From)
+					addStageReference(stages, DockerfileEdgeCopy, scope.expand(cmd.From), filePath, span)
 				}
 			}
-		}
-
-		if match := regexp.MustCompile(`\bFROM\s+(?:--platform=[^\s]+\s+)?([^\s:@]+)(?::([^\s@]+))?(@sha256:[a-fA-F0-9]{64})?\b`).FindStringSubmatch(line); match != nil {
-			imageName := match[1]
-			tag := match[2]
-			digest := match[3]
-
-			if imageName == "scratch" {
+		case *instructions.RunCommand:
+			if len(stages) == 0 {
 				continue
 			}
-
-			fullImageName := imageName
-			if tag != "" {
-				fullImageName += ":" + tag
-			} else {
-				fullImageName += ":latest"
+			flagSpans, _ := dockerfile.locateNode(node)
+			mountFlags := mountFlagIndexes(node.Flags)
+			for i, mount := range instructions.GetMounts(cmd) {
+				if mount.From == "" || i >= len(mountFlags) {
+					continue
+				}
+				flag := node.Flags[mountFlags[i]]
+				span := flagValueSpan(flag, flagSpans[mountFlags[i]], "from=", mountFromValue(flag))
+				addStageReference(stages, DockerfileEdgeMount, mount.From, filePath, span)
 			}
-			if digest != "" {
-				fullImageName += digest
+		case *instructions.Stage:
+			baseName := scope.expandFrom(cmd.BaseName)
+			stage := &dockerfileStage{
+				name:     cmd.Name,
+				baseName: baseName,
+				platform: targetPlatform(buildOptions),
+				line:     node.StartLine - 1,
+				parent:   findStage(stages, strings.ToLower(baseName), len(stages), false),
 			}
-
-			if realName, ok := aliases[imageName]; ok {
-				if realName != imageName {
-					continue
+			if cmd.Platform != "" {
+				// A platform that still refers to an unset variable, such as $BUILDPLATFORM when the
+				// caller supplies no platforms, is not known.
+				stage.platform = scope.expandFrom(cmd.Platform)
+				if strings.Contains(stage.platform, "$") {
+					stage.platform = ""
 				}
 			}
-			log.Debug().Msgf("Found image %s at line %d (will assign to ImageLocation.Line/StartIndex/EndIndex)", fullImageName, lineNum)
-
-			// Robust regex to find the start and end index of the image name in the line
-			re := regexp.MustCompile(`FROM\s+([^\s]+)`)
-			indices := re.FindStringSubmatchIndex(line)
-			imgIdx, imgEnd := -1, -1
-			if len(indices) >= 4 {
-				imgIdx = indices[2]
-				imgEnd = indices[3]
+			stages = append(stages, stage)
+
+			if stage.parent != -1 {
+				scope.startStage(cmd.Name, stages[stage.parent].name)
+			} else {
+				scope.startStage(cmd.Name, "")
 			}
 
-			isSha := false
-			if digest != "" {
-				isSha = true
+			if stage.parent != -1 || baseName == "scratch" {
+				continue
 			}
 
-			imageNames = append(imageNames, types.ImageModel{
-				Name: fullImageName,
-				ImageLocations: []types.ImageLocation{
-					{
-						Origin:     types.DockerFileOrigin,
-						Path:       filePath.RelativePath,
-						FinalStage: false,
-						Line:       lineNum,
-						StartIndex: imgIdx,
-						EndIndex:   imgEnd,
-					},
+			_, argSpans := dockerfile.locateNode(node)
+			image := newDockerfileImage(baseName, DockerfileEdgeFrom, stage.platform, filePath, argSpans[0])
+			stage.image = &image
+		}
+	}
+
+	return stages
+}
+
+// newDockerfileImage builds the details of an image found at span.
+func newDockerfileImage(ref, usage, platform string, filePath types.FilePath, span sourceSpan) ImageDetails {
+	fullImageName, isSha := formatDockerfileImage(ref)
+	log.Debug().Msgf("Found image %s at line %d (will assign to ImageLocation.Line/StartIndex/EndIndex)", fullImageName, span.line)
+
+	return ImageDetails{
+		ImageModel: types.ImageModel{
+			Name: fullImageName,
+			ImageLocations: []types.ImageLocation{
+				{
+					Origin:     types.DockerFileOrigin,
+					Path:       filePath.RelativePath,
+					FinalStage: false,
+					Line:       span.line,
+					StartIndex: span.start,
+					EndIndex:   span.end,
 				},
-				IsSha: isSha,
-			})
+			},
+			IsSha: isSha,
+		},
+		DockerfileDetails: DockerfileDetails{Platform: platform, Usage: usage},
+	}
+}
+
+// targetPlatform returns the platform the build targets, like TARGETPLATFORM.
+func targetPlatform(buildOptions DockerfileBuildOptions) string {
+	if buildOptions.Platform != "" {
+		return buildOptions.Platform
+	}
+	return buildOptions.BuildPlatform
+}
+
+// addStageReference records a COPY --from or RUN --mount=from value of the last stage, once expanded.
+// A value that names or numbers an earlier stage becomes a dependency on that stage; any other value
+// is an image the build pulls, unless it still refers to an unset variable.
+func addStageReference(stages []*dockerfileStage, kind, ref string, filePath types.FilePath, span sourceSpan) {
+	current := stages[len(stages)-1]
+	stage := findStage(stages, strings.ToLower(ref), len(stages)-1, true)
+	current.references = append(current.references, stageReference{kind: kind, ref: ref, stage: stage, line: span.line})
+	if stage != -1 {
+		current.dependencies = append(current.dependencies, stage)
+		return
+	}
+	if ref == "scratch" {
+		return
+	}
+	if ref == "" || strings.Contains(ref, "$") {
+		log.Debug().Msgf("Skipping unresolved %s reference %q at line %d of dockerfile %s", kind, ref, span.line, filePath.RelativePath)
+		return
+	}
+
+	current.referencedImages = append(current.referencedImages, newDockerfileImage(ref, kind, current.platform, filePath, span))
+}
+
+// mountFlagIndexes returns the positions of the --mount flags of a RUN instruction, in the order
+// instructions.GetMounts returns the parsed mounts.
+func mountFlagIndexes(flags []string) []int {
+	var indexes []int
+	for i, flag := range flags {
+		if strings.HasPrefix(flag, "--mount=") {
+			indexes = append(indexes, i)
 		}
 	}
+	return indexes
+}
 
-	if len(imageNames) > 0 {
-		lastImage := imageNames[len(imageNames)-1]
-		if len(lastImage.ImageLocations) > 0 {
-			lastImage.ImageLocations[len(lastImage.ImageLocations)-1].FinalStage = true
+// expandMountFroms returns a RUN instruction with the from= values of its --mount flags expanded with
+// the variables of scope, so that --mount=from=${BUILDER} references the stage BUILDER names like
+// COPY --from=${BUILDER} does. The BuildKit parser rejects a from= value holding a variable. Other
+// instructions are returned as is.
+func expandMountFroms(node *parser.Node, scope *dockerfileScope) *parser.Node {
+	if !strings.EqualFold(node.Value, "run") {
+		return node
+	}
+	var flags []string
+	for i, flag := range node.Flags {
+		from := mountFromValue(flag)
+		if !strings.HasPrefix(flag, "--mount=") || !strings.Contains(from, "$") {
+			continue
+		}
+		if flags == nil {
+			flags = append([]string{}, node.Flags...)
 		}
+		flags[i] = strings.Replace(flag, "from="+from, "from="+scope.expand(from), 1)
 	}
+	if flags == nil {
+		return node
+	}
+	expanded := *node
+	expanded.Flags = flags
+	return &expanded
+}
 
-	if err = scanner.Err(); err != nil {
-		return nil, err
+// mountFromValue returns the raw from= value of a --mount flag, or an empty string when it has none.
+func mountFromValue(flag string) string {
+	for _, field := range strings.Split(strings.TrimPrefix(flag, "--mount="), ",") {
+		if value, ok := strings.CutPrefix(field, "from="); ok {
+			return value
+		}
 	}
-	return imageNames, nil
+	return ""
+}
+
+// flagValueSpan narrows the span of a flag such as --from=image or --mount=type=bind,from=image down
+// to the raw value that follows key.
+func flagValueSpan(flag string, span sourceSpan, key, value string) sourceSpan {
+	keyIndex := strings.Index(flag, key)
+	if span.start == -1 || keyIndex == -1 {
+		return span
+	}
+	valueIndex := strings.Index(flag[keyIndex+len(key):], value)
+	if valueIndex == -1 {
+		return span
+	}
+	start := span.start + keyIndex + len(key) + valueIndex
+	return sourceSpan{line: span.line, start: start, end: start + len(value)}
 }
 
-func replacePlaceholders(line string, envVars, argsAndEnv map[string]string) string {
-	for varName, varValue := range envVars {
-		placeholderWithBraces := fmt.Sprintf("${%s}", varName)
-		line = strings.ReplaceAll(line, placeholderWithBraces, varValue)
+// resolveTargetStage returns the index of the stage built for target, the last stage when target is empty.
+func resolveTargetStage(stages []*dockerfileStage, target string) (int, error) {
+	if target == "" {
+		return len(stages) - 1, nil
+	}
+	index := findStage(stages, strings.ToLower(target), len(stages), false)
+	if index == -1 {
+		return -1, fmt.Errorf("target stage %s not found", target)
+	}
+	return index, nil
+}
 
-		placeholderWithoutBraces := fmt.Sprintf("$%s", varName)
-		line = strings.ReplaceAll(line, placeholderWithoutBraces, varValue)
+// findStage returns the index of the stage a FROM, COPY --from or RUN --mount=from value refers to,
+// or -1 when it refers to an image. Only the first count stages are visible. Stages are referenced by
+// name, and by their position in the Dockerfile when byIndex is set, as BuildKit only allows for
+// COPY --from and RUN --mount=from.
+func findStage(stages []*dockerfileStage, ref string, count int, byIndex bool) int {
+	for i := count - 1; i >= 0; i-- {
+		if stages[i].name != "" && stages[i].name == ref {
+			return i
+		}
+	}
+	if !byIndex {
+		return -1
+	}
+	if index, err := strconv.Atoi(ref); err == nil && index >= 0 && index < count {
+		return index
 	}
+	return -1
+}
 
-	for varName, varValue := range argsAndEnv {
-		placeholderWithBraces := fmt.Sprintf("${%s}", varName)
-		line = strings.ReplaceAll(line, placeholderWithBraces, varValue)
+// stageBaseImage follows the FROM chain of a stage down to the image it is ultimately built from.
+func stageBaseImage(stages []*dockerfileStage, index int) *ImageDetails {
+	for stages[index].parent != -1 {
+		index = stages[index].parent
+	}
+	return stages[index].image
+}
 
-		placeholderWithoutBraces := fmt.Sprintf("$%s", varName)
-		line = strings.ReplaceAll(line, placeholderWithoutBraces, varValue)
+// reachableStages marks every stage the given stage needs, directly or transitively, including itself.
+func reachableStages(stages []*dockerfileStage, index int) map[int]bool {
+	reachable := make(map[int]bool)
+	pending := []int{index}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[current] {
+			continue
+		}
+		reachable[current] = true
+		if stages[current].parent != -1 {
+			pending = append(pending, stages[current].parent)
+		}
+		pending = append(pending, stages[current].dependencies...)
 	}
+	return reachable
+}
 
-	return line
+// formatDockerfileImage adds the implicit latest tag to an image reference and reports whether it is pinned by digest.
+func formatDockerfileImage(reference string) (string, bool) {
+	name, digest, isSha := strings.Cut(reference, "@")
+	if !strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		name += ":latest"
+	}
+	if isSha {
+		return name + "@" + digest, true
+	}
+	return name, false
 }
 
 func resolveEnvVariables(dockerfilePath string, envFiles map[string]map[string]string) map[string]string {
@@ -212,20 +441,6 @@ func isRootDir(dir string) bool {
 	return (len(dir) == 3 && strings.HasSuffix(dir, `:\`)) || (len(dir) >= 2 && strings.HasPrefix(dir, `\\`))
 }
 
-func resolveAlias(alias string, aliases map[string]string) string {
-	realName, ok := aliases[alias]
-	if !ok {
-		return "" // Alias not found
-	}
-
-	// Check if the real name is also an alias, resolve recursively
-	if resolvedRealName, ok := aliases[realName]; ok {
-		return resolveAlias(resolvedRealName, aliases)
-	}
-
-	return realName
-}
-
 func printFoundImagesInFile(filePath string, imageNames []types.ImageModel) {
 	if len(imageNames) > 0 {
 		log.Debug().Msgf("Successfully found images in file: %s images are: %v\n", filePath, strings.Join(func() []string {
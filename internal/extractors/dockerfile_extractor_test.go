@@ -1,6 +1,7 @@
 package extractors
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/Checkmarx/containers-types/types"
@@ -66,8 +67,10 @@ func TestExtractImagesFromDockerfiles(t *testing.T) {
 		"mcr.microsoft.com/dotnet/aspnet:6.0": {Origin: types.DockerFileOrigin, Path: "Dockerfile", FinalStage: true},
 		"nginx:latest":                        {Origin: types.DockerFileOrigin, Path: "Dockerfile-2", FinalStage: false},
 		"mcr.microsoft.com/dotnet/aspnet:4.0": {Origin: types.DockerFileOrigin, Path: "Dockerfile-2", FinalStage: true},
+		"publish:latest":                      {Origin: types.DockerFileOrigin, Path: "Dockerfile-2", FinalStage: false},
 		"tonistiigi/xx:1.2.1":                 {Origin: types.DockerFileOrigin, Path: "Dockerfile-3", FinalStage: false},
 		"golang:1.20.8-alpine3.18":            {Origin: types.DockerFileOrigin, Path: "Dockerfile-3", FinalStage: false},
+		"docker/dockerfile:1":                 {Origin: types.DockerFileOrigin, Path: "Dockerfile-3", FinalStage: false},
 		"alpine:3.18":                         {Origin: types.DockerFileOrigin, Path: "Dockerfile-3", FinalStage: true},
 		"test:ubi9":                           {Origin: types.DockerFileOrigin, Path: "Dockerfile.ubi9", FinalStage: true},
 	}
@@ -177,6 +180,259 @@ func TestExtractImagesFromDockerfiles_WithMultipleEnvFiles(t *testing.T) {
 	checkResult(t, images, expectedImages)
 }
 
+func TestExtractImagesFromDockerfiles_ParserConstructs(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.parser", RelativePath: "Dockerfile.parser"},
+	}
+
+	images, err := ExtractImagesFromDockerfiles(filePaths, map[string]map[string]string{})
+	if err != nil {
+		t.Errorf("Error extracting images: %v", err)
+	}
+
+	expectedImages := map[string]types.ImageLocation{
+		"golang:1.22-alpine":                            {Origin: types.DockerFileOrigin, Path: "Dockerfile.parser", FinalStage: false, Line: 3, StartIndex: 4, EndIndex: 22},
+		"mcr.microsoft.com/windows/nanoserver:ltsc2022": {Origin: types.DockerFileOrigin, Path: "Dockerfile.parser", FinalStage: true, Line: 12, StartIndex: 2, EndIndex: 47},
+	}
+
+	checkResult(t, images, expectedImages)
+	checkLineInfo(t, images, expectedImages)
+}
+
+func TestExtractImagesFromDockerfiles_VariableExpansion(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.variables", RelativePath: "Dockerfile.variables"},
+	}
+
+	images, err := ExtractImagesFromDockerfiles(filePaths, map[string]map[string]string{})
+	if err != nil {
+		t.Errorf("Error extracting images: %v", err)
+	}
+
+	expectedImages := map[string]types.ImageLocation{
+		"docker.io/library/golang:1.22-alpine3.19": {Origin: types.DockerFileOrigin, Path: "Dockerfile.variables", FinalStage: false},
+		"ghcr.io/org/base:stable":                  {Origin: types.DockerFileOrigin, Path: "Dockerfile.variables", FinalStage: true},
+	}
+
+	checkResult(t, images, expectedImages)
+}
+
+func TestExtractImagesFromDockerfiles_StageReferences(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.references", RelativePath: "Dockerfile.references"},
+	}
+
+	images, err := ExtractImagesFromDockerfiles(filePaths, map[string]map[string]string{})
+	if err != nil {
+		t.Errorf("Error extracting images: %v", err)
+	}
+
+	expectedImages := map[string]types.ImageLocation{
+		"golang:1.22":           {Origin: types.DockerFileOrigin, Path: "Dockerfile.references", FinalStage: false, Line: 0, StartIndex: 5, EndIndex: 16},
+		"ghcr.io/org/tool:1.2":  {Origin: types.DockerFileOrigin, Path: "Dockerfile.references", FinalStage: false, Line: 1, StartIndex: 12, EndIndex: 32},
+		"busybox:1.36":          {Origin: types.DockerFileOrigin, Path: "Dockerfile.references", FinalStage: false, Line: 2, StartIndex: 27, EndIndex: 39},
+		"alpine:3.19":           {Origin: types.DockerFileOrigin, Path: "Dockerfile.references", FinalStage: true, Line: 4, StartIndex: 5, EndIndex: 16},
+		"ghcr.io/org/tools:2.0": {Origin: types.DockerFileOrigin, Path: "Dockerfile.references", FinalStage: false, Line: 8, StartIndex: 17, EndIndex: 38},
+	}
+
+	checkResult(t, images, expectedImages)
+	checkLineInfo(t, images, expectedImages)
+}
+
+func TestExtractImagesFromDockerfiles_UnresolvedStageReferences(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.unresolved", RelativePath: "Dockerfile.unresolved"},
+	}
+
+	images, err := ExtractImagesFromDockerfiles(filePaths, map[string]map[string]string{})
+	if err != nil {
+		t.Errorf("Error extracting images: %v", err)
+	}
+
+	// COPY --from=$UNSET and RUN --mount=from=${TOOLS} name no stage and no image a build would pull
+	expectedImages := map[string]types.ImageLocation{
+		"golang:1.22": {Origin: types.DockerFileOrigin, Path: "Dockerfile.unresolved", FinalStage: false, Line: 0, StartIndex: 5, EndIndex: 16},
+		"alpine:3.19": {Origin: types.DockerFileOrigin, Path: "Dockerfile.unresolved", FinalStage: true, Line: 4, StartIndex: 5, EndIndex: 16},
+	}
+
+	checkResult(t, images, expectedImages)
+	checkLineInfo(t, images, expectedImages)
+}
+
+func TestExtractImagesFromDockerfiles_ScratchFinalStage(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.scratch", RelativePath: "Dockerfile.scratch"},
+	}
+
+	images, err := ExtractImagesFromDockerfiles(filePaths, map[string]map[string]string{})
+	if err != nil {
+		t.Errorf("Error extracting images: %v", err)
+	}
+
+	// The final stage is built FROM scratch, so neither its build stage nor its COPY --from image is final
+	expectedImages := map[string]types.ImageLocation{
+		"golang:1.22":  {Origin: types.DockerFileOrigin, Path: "Dockerfile.scratch", FinalStage: false, Line: 0, StartIndex: 5, EndIndex: 16},
+		"busybox:1.36": {Origin: types.DockerFileOrigin, Path: "Dockerfile.scratch", FinalStage: false, Line: 5, StartIndex: 12, EndIndex: 24},
+	}
+
+	checkResult(t, images, expectedImages)
+	checkLineInfo(t, images, expectedImages)
+}
+
+func TestExtractImagesFromDockerfilesWithOptions(t *testing.T) {
+	envVars := map[string]map[string]string{
+		"../../test_files/imageExtraction/dockerfiles": {
+			"GO_VERSION": "1.20.8",
+		},
+	}
+
+	t.Run("TargetStage", func(t *testing.T) {
+		filePaths := []types.FilePath{
+			{FullPath: "../../test_files/imageExtraction/dockerfiles/Dockerfile-3", RelativePath: "Dockerfile-3"},
+		}
+		buildOptions := map[string]DockerfileBuildOptions{
+			"Dockerfile-3": {Target: "build"},
+		}
+
+		images, err := ExtractImagesFromDockerfilesWithOptions(filePaths, envVars, buildOptions)
+		if err != nil {
+			t.Errorf("Error extracting images: %v", err)
+		}
+
+		expectedImages := map[string]types.ImageLocation{
+			"golang:1.20.8-alpine3.18": {Origin: types.DockerFileOrigin, Path: "Dockerfile-3", FinalStage: true},
+			"docker/dockerfile:1":      {Origin: types.DockerFileOrigin, Path: "Dockerfile-3", FinalStage: false},
+		}
+
+		checkResult(t, images, expectedImages)
+	})
+
+	t.Run("ScratchTargetStage", func(t *testing.T) {
+		filePaths := []types.FilePath{
+			{FullPath: "../../test_files/imageExtraction/dockerfiles/Dockerfile-3", RelativePath: "Dockerfile-3"},
+		}
+		buildOptions := map[string]DockerfileBuildOptions{
+			"Dockerfile-3": {Target: "binary"},
+		}
+
+		images, err := ExtractImagesFromDockerfilesWithOptions(filePaths, envVars, buildOptions)
+		if err != nil {
+			t.Errorf("Error extracting images: %v", err)
+		}
+
+		expectedImages := map[string]types.ImageLocation{
+			"golang:1.20.8-alpine3.18": {Origin: types.DockerFileOrigin, Path: "Dockerfile-3", FinalStage: false},
+			"docker/dockerfile:1":      {Origin: types.DockerFileOrigin, Path: "Dockerfile-3", FinalStage: false},
+		}
+
+		checkResult(t, images, expectedImages)
+	})
+
+	t.Run("BuildArgsAndTarget", func(t *testing.T) {
+		filePaths := []types.FilePath{
+			{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.variables", RelativePath: "Dockerfile.variables"},
+		}
+		buildOptions := map[string]DockerfileBuildOptions{
+			"Dockerfile.variables": {BuildArgs: map[string]string{"GO_VERSION": "1.23", "ALPINE_VERSION": "3.20"}, Target: "BUILD"},
+		}
+
+		images, err := ExtractImagesFromDockerfilesWithOptions(filePaths, nil, buildOptions)
+		if err != nil {
+			t.Errorf("Error extracting images: %v", err)
+		}
+
+		expectedImages := map[string]types.ImageLocation{
+			"docker.io/library/golang:1.23-alpine3.20": {Origin: types.DockerFileOrigin, Path: "Dockerfile.variables", FinalStage: true},
+		}
+
+		checkResult(t, images, expectedImages)
+	})
+
+	t.Run("UnknownTargetStage", func(t *testing.T) {
+		filePaths := []types.FilePath{
+			{FullPath: "../../test_files/imageExtraction/dockerfiles/Dockerfile", RelativePath: "Dockerfile"},
+		}
+		buildOptions := map[string]DockerfileBuildOptions{
+			"Dockerfile": {Target: "missing"},
+		}
+
+		images, err := ExtractImagesFromDockerfilesWithOptions(filePaths, nil, buildOptions)
+		if err != nil {
+			t.Errorf("Error extracting images: %v", err)
+		}
+
+		if len(images) != 0 {
+			t.Errorf("Expected 0 images, but got %d", len(images))
+		}
+	})
+}
+
+func TestExtractImageDetailsFromDockerfiles_Platform(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.platform", RelativePath: "Dockerfile.platform"},
+	}
+
+	location := func(line, start, end int, finalStage bool) []types.ImageLocation {
+		return []types.ImageLocation{{Origin: types.DockerFileOrigin, Path: "Dockerfile.platform", FinalStage: finalStage, Line: line, StartIndex: start, EndIndex: end}}
+	}
+
+	tests := []struct {
+		name         string
+		buildOptions map[string]DockerfileBuildOptions
+		expected     []ImageDetails
+	}{
+		{
+			name: "CallerPlatforms",
+			buildOptions: map[string]DockerfileBuildOptions{
+				"Dockerfile.platform": {BuildPlatform: "linux/amd64", Platform: "linux/arm64"},
+			},
+			expected: []ImageDetails{
+				{ImageModel: types.ImageModel{Name: "docker/dockerfile:1.7", ImageLocations: location(0, 9, 30, false)}, DockerfileDetails: DockerfileDetails{Platform: "linux/amd64", Usage: DockerfileSyntax}},
+				{ImageModel: types.ImageModel{Name: "golang:1.22", ImageLocations: location(2, 31, 42, false)}, DockerfileDetails: DockerfileDetails{Platform: "linux/amd64", Usage: DockerfileEdgeFrom}},
+				{ImageModel: types.ImageModel{Name: "alpine:3.19", ImageLocations: location(6, 28, 35, false)}, DockerfileDetails: DockerfileDetails{Platform: "linux/arm64", Usage: DockerfileEdgeFrom}},
+				{ImageModel: types.ImageModel{Name: "alpine:3.19", ImageLocations: location(7, 5, 12, true)}, DockerfileDetails: DockerfileDetails{Platform: "linux/arm64", Usage: DockerfileEdgeFrom}},
+			},
+		},
+		{
+			name: "NoCallerPlatforms",
+			expected: []ImageDetails{
+				{ImageModel: types.ImageModel{Name: "docker/dockerfile:1.7", ImageLocations: location(0, 9, 30, false)}, DockerfileDetails: DockerfileDetails{Usage: DockerfileSyntax}},
+				{ImageModel: types.ImageModel{Name: "golang:1.22", ImageLocations: location(2, 31, 42, false)}, DockerfileDetails: DockerfileDetails{Usage: DockerfileEdgeFrom}},
+				{ImageModel: types.ImageModel{Name: "alpine:3.19", ImageLocations: location(6, 28, 35, false)}, DockerfileDetails: DockerfileDetails{Platform: "linux/arm64", Usage: DockerfileEdgeFrom}},
+				{ImageModel: types.ImageModel{Name: "alpine:3.19", ImageLocations: location(7, 5, 12, true)}, DockerfileDetails: DockerfileDetails{Usage: DockerfileEdgeFrom}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			images, err := ExtractImageDetailsFromDockerfiles(filePaths, nil, test.buildOptions)
+			if err != nil {
+				t.Fatalf("Error extracting images: %v", err)
+			}
+			if !reflect.DeepEqual(images, test.expected) {
+				t.Errorf("Expected %+v but got %+v", test.expected, images)
+			}
+		})
+	}
+}
+
+func checkLineInfo(t *testing.T, images []types.ImageModel, expectedImages map[string]types.ImageLocation) {
+	for _, image := range images {
+		expectedLocation, ok := expectedImages[image.Name]
+		if !ok || len(image.ImageLocations) != 1 {
+			continue
+		}
+
+		location := image.ImageLocations[0]
+		if location.Line != expectedLocation.Line || location.StartIndex != expectedLocation.StartIndex || location.EndIndex != expectedLocation.EndIndex {
+			t.Errorf("Expected image %s at line %d [%d:%d], but got line %d [%d:%d]", image.Name,
+				expectedLocation.Line, expectedLocation.StartIndex, expectedLocation.EndIndex,
+				location.Line, location.StartIndex, location.EndIndex)
+		}
+	}
+}
+
 func checkResult(t *testing.T, images []types.ImageModel, expectedImages map[string]types.ImageLocation) {
 	if len(images) != len(expectedImages) {
 		t.Errorf("Expected %d images, but got %d", len(expectedImages), len(images))
@@ -0,0 +1,212 @@
+package extractors
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/rs/zerolog/log"
+)
+
+// Kinds of the edges of a DockerfileGraph.
+const (
+	DockerfileEdgeFrom  = "from"
+	DockerfileEdgeCopy  = "copy"
+	DockerfileEdgeMount = "mount"
+)
+
+// DockerfileGraph is the multi-stage build graph of a Dockerfile.
+type DockerfileGraph struct {
+	Path string `json:"path"`
+	// Syntax is the BuildKit frontend image selected by a # syntax= directive.
+	Syntax string `json:"syntax,omitempty"`
+	// Target is the index of the stage being built, the last stage unless a target was requested.
+	Target int                    `json:"target"`
+	Stages []DockerfileGraphStage `json:"stages"`
+	Edges  []DockerfileGraphEdge  `json:"edges"`
+}
+
+// DockerfileGraphStage is a build stage of a Dockerfile.
+type DockerfileGraphStage struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+	// BaseImage is the image the stage is built FROM, empty when it is built from another stage.
+	BaseImage string `json:"baseImage,omitempty"`
+	// Platform is the platform the stage is built for, see ImageDetails.Platform.
+	Platform string `json:"platform,omitempty"`
+	// BaseStage is the index of the stage this stage is built FROM, or -1 when it is built from an image.
+	BaseStage int `json:"baseStage"`
+	// Line is the 0-based line of the FROM instruction.
+	Line int `json:"line"`
+	// Required reports whether building the target needs this stage, directly or transitively.
+	Required bool `json:"required"`
+	// Shipped reports whether the stage is on the FROM chain of the target, so its base image is part
+	// of the final artifact. Required stages that are not shipped are build-only.
+	Shipped bool `json:"shipped"`
+}
+
+// DockerfileGraphEdge is a FROM, COPY --from or RUN --mount=from reference of a stage.
+type DockerfileGraphEdge struct {
+	Kind string `json:"kind"`
+	// From is the index of the stage holding the reference.
+	From int `json:"from"`
+	// ToStage is the index of the referenced stage, or -1 when the reference is an image.
+	ToStage int `json:"toStage"`
+	// ToImage is the referenced image when ToStage is -1.
+	ToImage string `json:"toImage,omitempty"`
+	// Line is the 0-based line of the reference.
+	Line int `json:"line"`
+}
+
+// ExtractDockerfileGraphs returns the build graph of each Dockerfile, applying the build options
+// found in buildOptions under the Dockerfile RelativePath.
+func ExtractDockerfileGraphs(filePaths []types.FilePath, envFiles map[string]map[string]string,
+	buildOptions map[string]DockerfileBuildOptions) ([]DockerfileGraph, error) {
+	var graphs []DockerfileGraph
+
+	for _, filePath := range filePaths {
+		graph, err := extractDockerfileGraph(filePath, envFiles, buildOptions[filePath.RelativePath])
+		if err != nil {
+			log.Warn().Msgf("could not extract build graph from dockerfile %s err: %+v", filePath, err)
+			continue
+		}
+		graphs = append(graphs, graph)
+	}
+
+	return graphs, nil
+}
+
+func extractDockerfileGraph(filePath types.FilePath, envFiles map[string]map[string]string, buildOptions DockerfileBuildOptions) (DockerfileGraph, error) {
+	graph := DockerfileGraph{Path: filePath.RelativePath, Target: -1}
+
+	dockerfile, err := parseDockerfile(filePath.FullPath)
+	if err != nil {
+		return graph, err
+	}
+	stages := parseDockerfileStages(dockerfile, filePath, envFiles, buildOptions)
+	if dockerfile.syntax != "" {
+		graph.Syntax, _ = formatDockerfileImage(dockerfile.syntax)
+	}
+	if len(stages) == 0 {
+		return graph, nil
+	}
+
+	graph.Target, err = resolveTargetStage(stages, buildOptions.Target)
+	if err != nil {
+		return graph, err
+	}
+
+	required := reachableStages(stages, graph.Target)
+	shipped := make(map[int]bool)
+	for i := graph.Target; i != -1; i = stages[i].parent {
+		shipped[i] = true
+	}
+
+	for i, stage := range stages {
+		graphStage := DockerfileGraphStage{
+			Index:     i,
+			Name:      stage.name,
+			Platform:  stage.platform,
+			BaseStage: stage.parent,
+			Line:      stage.line,
+			Required:  required[i],
+			Shipped:   shipped[i],
+		}
+		edge := DockerfileGraphEdge{Kind: DockerfileEdgeFrom, From: i, ToStage: stage.parent, Line: stage.line}
+		if stage.parent == -1 {
+			graphStage.BaseImage = stage.baseName
+			if stage.image != nil {
+				graphStage.BaseImage = stage.image.Name
+			}
+			edge.ToImage = graphStage.BaseImage
+		}
+		graph.Stages = append(graph.Stages, graphStage)
+		graph.Edges = append(graph.Edges, edge)
+
+		for _, reference := range stage.references {
+			edge := DockerfileGraphEdge{Kind: reference.kind, From: i, ToStage: reference.stage, Line: reference.line}
+			if reference.stage == -1 {
+				edge.ToImage = reference.ref
+				if reference.ref != "scratch" {
+					edge.ToImage, _ = formatDockerfileImage(reference.ref)
+				}
+			}
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+
+	return graph, nil
+}
+
+// JSON returns the graph encoded as indented JSON.
+func (g DockerfileGraph) JSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
+
+// DOT returns the graph in the Graphviz DOT language. Edges point from a stage to what it needs.
+// Shipped stages and their base image are drawn bold, and stages the target does not need are dashed.
+func (g DockerfileGraph) DOT() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "digraph %s {\n", dotQuote(g.Path))
+	sb.WriteString("  rankdir=LR;\n")
+
+	shippedImages := make(map[string]bool)
+	for _, stage := range g.Stages {
+		var attrs []string
+		label := stage.Name
+		if label == "" {
+			label = fmt.Sprintf("stage %d", stage.Index)
+		}
+		attrs = append(attrs, "shape=box", "label="+dotQuote(label))
+		switch {
+		case stage.Shipped:
+			attrs = append(attrs, "style=bold")
+			if stage.BaseImage != "" {
+				shippedImages[stage.BaseImage] = true
+			}
+		case !stage.Required:
+			attrs = append(attrs, "style=dashed")
+		}
+		if stage.Index == g.Target {
+			attrs = append(attrs, "peripheries=2")
+		}
+		fmt.Fprintf(&sb, "  %s [%s];\n", dotQuote(dotStageID(stage.Index)), strings.Join(attrs, ", "))
+	}
+
+	seenImages := make(map[string]bool)
+	for _, edge := range g.Edges {
+		if edge.ToStage != -1 || seenImages[edge.ToImage] {
+			continue
+		}
+		seenImages[edge.ToImage] = true
+		attrs := []string{"shape=ellipse", "label=" + dotQuote(edge.ToImage)}
+		if shippedImages[edge.ToImage] {
+			attrs = append(attrs, "style=bold")
+		}
+		fmt.Fprintf(&sb, "  %s [%s];\n", dotQuote(dotImageID(edge.ToImage)), strings.Join(attrs, ", "))
+	}
+
+	for _, edge := range g.Edges {
+		to := dotImageID(edge.ToImage)
+		if edge.ToStage != -1 {
+			to = dotStageID(edge.ToStage)
+		}
+		fmt.Fprintf(&sb, "  %s -> %s [label=%s];\n", dotQuote(dotStageID(edge.From)), dotQuote(to), dotQuote(edge.Kind))
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func dotStageID(index int) string {
+	return fmt.Sprintf("stage:%d", index)
+}
+
+func dotImageID(image string) string {
+	return "image:" + image
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
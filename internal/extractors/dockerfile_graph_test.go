@@ -0,0 +1,130 @@
+package extractors
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
+)
+
+func TestExtractDockerfileGraphs(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.references", RelativePath: "Dockerfile.references"},
+	}
+
+	graphs, err := ExtractDockerfileGraphs(filePaths, nil, nil)
+	if err != nil {
+		t.Fatalf("Error extracting graphs: %v", err)
+	}
+	if len(graphs) != 1 {
+		t.Fatalf("Expected 1 graph but got %d", len(graphs))
+	}
+
+	expected := DockerfileGraph{
+		Path:   "Dockerfile.references",
+		Target: 1,
+		Stages: []DockerfileGraphStage{
+			{Index: 0, Name: "build", BaseImage: "golang:1.22", BaseStage: -1, Line: 0, Required: true, Shipped: false},
+			{Index: 1, BaseImage: "alpine:3.19", BaseStage: -1, Line: 4, Required: true, Shipped: true},
+		},
+		Edges: []DockerfileGraphEdge{
+			{Kind: DockerfileEdgeFrom, From: 0, ToStage: -1, ToImage: "golang:1.22", Line: 0},
+			{Kind: DockerfileEdgeCopy, From: 0, ToStage: -1, ToImage: "ghcr.io/org/tool:1.2", Line: 1},
+			{Kind: DockerfileEdgeMount, From: 0, ToStage: -1, ToImage: "busybox:1.36", Line: 2},
+			{Kind: DockerfileEdgeFrom, From: 1, ToStage: -1, ToImage: "alpine:3.19", Line: 4},
+			{Kind: DockerfileEdgeCopy, From: 1, ToStage: 0, Line: 5},
+			{Kind: DockerfileEdgeCopy, From: 1, ToStage: 0, Line: 6},
+			{Kind: DockerfileEdgeMount, From: 1, ToStage: -1, ToImage: "ghcr.io/org/tools:2.0", Line: 8},
+		},
+	}
+	if !reflect.DeepEqual(graphs[0], expected) {
+		t.Errorf("Expected %+v but got %+v", expected, graphs[0])
+	}
+
+	data, err := graphs[0].JSON()
+	if err != nil {
+		t.Fatalf("Error encoding graph: %v", err)
+	}
+	var decoded DockerfileGraph
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Error decoding graph: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected JSON round trip to give %+v but got %+v", expected, decoded)
+	}
+
+	dot := graphs[0].DOT()
+	for _, line := range []string{
+		`digraph "Dockerfile.references" {`,
+		`"stage:0" [shape=box, label="build"];`,
+		`"stage:1" [shape=box, label="stage 1", style=bold, peripheries=2];`,
+		`"image:alpine:3.19" [shape=ellipse, label="alpine:3.19", style=bold];`,
+		`"image:golang:1.22" [shape=ellipse, label="golang:1.22"];`,
+		`"stage:1" -> "stage:0" [label="copy"];`,
+		`"stage:0" -> "image:busybox:1.36" [label="mount"];`,
+	} {
+		if !strings.Contains(dot, line) {
+			t.Errorf("Expected DOT output to contain %s, got:\n%s", line, dot)
+		}
+	}
+}
+
+func TestExtractDockerfileGraphs_Target(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/imageExtraction/dockerfiles/Dockerfile", RelativePath: "Dockerfile"},
+		{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.references", RelativePath: "Dockerfile.references"},
+	}
+	buildOptions := map[string]DockerfileBuildOptions{
+		"Dockerfile":            {Target: "missing"},
+		"Dockerfile.references": {Target: "build"},
+	}
+
+	graphs, err := ExtractDockerfileGraphs(filePaths, nil, buildOptions)
+	if err != nil {
+		t.Fatalf("Error extracting graphs: %v", err)
+	}
+	if len(graphs) != 1 {
+		t.Fatalf("Expected 1 graph but got %d", len(graphs))
+	}
+
+	graph := graphs[0]
+	if graph.Target != 0 {
+		t.Errorf("Expected target stage 0 but got %d", graph.Target)
+	}
+	if !graph.Stages[0].Required || !graph.Stages[0].Shipped {
+		t.Errorf("Expected the target stage to be required and shipped, got %+v", graph.Stages[0])
+	}
+	if graph.Stages[1].Required || graph.Stages[1].Shipped {
+		t.Errorf("Expected the last stage to be unused when building the build stage, got %+v", graph.Stages[1])
+	}
+	if !strings.Contains(graph.DOT(), `"stage:1" [shape=box, label="stage 1", style=dashed];`) {
+		t.Errorf("Expected the unused stage to be dashed, got:\n%s", graph.DOT())
+	}
+}
+
+func TestExtractDockerfileGraphs_StageReferences(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.stagerefs", RelativePath: "Dockerfile.stagerefs"},
+	}
+
+	graphs, err := ExtractDockerfileGraphs(filePaths, nil, nil)
+	if err != nil {
+		t.Fatalf("Error extracting graphs: %v", err)
+	}
+	if len(graphs) != 1 {
+		t.Fatalf("Expected 1 graph but got %d", len(graphs))
+	}
+
+	// FROM 0 is an image, while RUN --mount=from=${BUILDER} and COPY --from=0 are the build stage
+	expected := []DockerfileGraphEdge{
+		{Kind: DockerfileEdgeFrom, From: 0, ToStage: -1, ToImage: "golang:1.22", Line: 1},
+		{Kind: DockerfileEdgeFrom, From: 1, ToStage: -1, ToImage: "0:latest", Line: 3},
+		{Kind: DockerfileEdgeMount, From: 1, ToStage: 0, Line: 5},
+		{Kind: DockerfileEdgeCopy, From: 1, ToStage: 0, Line: 6},
+	}
+	if !reflect.DeepEqual(graphs[0].Edges, expected) {
+		t.Errorf("Expected edges %+v but got %+v", expected, graphs[0].Edges)
+	}
+}
@@ -0,0 +1,119 @@
+package extractors
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+// dockerfileSource is a Dockerfile parsed into BuildKit's instruction AST, together with
+// the original lines so positions can be reported against the file as written.
+type dockerfileSource struct {
+	lines       []string
+	escapeToken rune
+	nodes       []*parser.Node
+	// syntax is the frontend image selected by a # syntax= directive, empty when there is none.
+	syntax     string
+	syntaxSpan sourceSpan
+}
+
+// sourceSpan is a 0-based line and a [start, end) column range in the original file.
+type sourceSpan struct {
+	line  int
+	start int
+	end   int
+}
+
+func parseDockerfile(path string) (*dockerfileSource, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseDockerfileContent(content)
+}
+
+func parseDockerfileContent(content []byte) (*dockerfileSource, error) {
+	result, err := parser.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+
+	dockerfile := &dockerfileSource{
+		lines:       lines,
+		escapeToken: result.EscapeToken,
+		nodes:       result.AST.Children,
+	}
+	if syntax, _, location, ok := parser.DetectSyntax(content); ok && len(location) > 0 {
+		dockerfile.syntax = syntax
+		dockerfile.syntaxSpan = sourceSpan{line: location[0].Start.Line - 1, start: -1, end: -1}
+		if line := dockerfile.syntaxSpan.line; line >= 0 && line < len(lines) {
+			if idx := strings.Index(lines[line], syntax); idx != -1 {
+				dockerfile.syntaxSpan.start, dockerfile.syntaxSpan.end = idx, idx+len(syntax)
+			}
+		}
+	}
+
+	return dockerfile, nil
+}
+
+// nodeArgs returns the whitespace separated arguments of an instruction, without its flags.
+func nodeArgs(node *parser.Node) []string {
+	var args []string
+	for n := node.Next; n != nil; n = n.Next {
+		args = append(args, n.Value)
+	}
+	return args
+}
+
+// locateNode finds the flags and arguments of an instruction in the original source. Words are
+// searched in order across all the physical lines of the instruction, so a word moved to a
+// continuation line still gets its exact line and column. Words that cannot be found get a span
+// whose start and end are -1 on the first line of the instruction.
+func (d *dockerfileSource) locateNode(node *parser.Node) (flags, args []sourceSpan) {
+	words := append(append([]string{}, node.Flags...), nodeArgs(node)...)
+	spans := make([]sourceSpan, len(words))
+
+	line := node.StartLine - 1
+	col := d.keywordEnd(node)
+	lastLine := min(node.EndLine-1, len(d.lines)-1)
+
+	for i, word := range words {
+		spans[i] = sourceSpan{line: node.StartLine - 1, start: -1, end: -1}
+		if word == "" {
+			continue
+		}
+		for l, c := line, col; l <= lastLine; l, c = l+1, 0 {
+			if c > len(d.lines[l]) {
+				continue
+			}
+			if idx := strings.Index(d.lines[l][c:], word); idx != -1 {
+				spans[i] = sourceSpan{line: l, start: c + idx, end: c + idx + len(word)}
+				line, col = l, c+idx+len(word)
+				break
+			}
+		}
+	}
+
+	return spans[:len(node.Flags)], spans[len(node.Flags):]
+}
+
+// keywordEnd returns the column right after the instruction keyword on the first line of the node.
+func (d *dockerfileSource) keywordEnd(node *parser.Node) int {
+	if node.StartLine < 1 || node.StartLine > len(d.lines) {
+		return 0
+	}
+	line := d.lines[node.StartLine-1]
+	start := strings.IndexFunc(line, func(r rune) bool { return !unicode.IsSpace(r) })
+	if start == -1 || !strings.EqualFold(line[start:min(start+len(node.Value), len(line))], node.Value) {
+		return 0
+	}
+	return start + len(node.Value)
+}
@@ -0,0 +1,208 @@
+package extractors
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+	"github.com/rs/zerolog/log"
+)
+
+// plainVariablePattern matches a $NAME or ${NAME} reference that carries no default or alternative value.
+var plainVariablePattern = regexp.MustCompile(`^\$(?:([A-Za-z_][A-Za-z0-9_]*)|\{([A-Za-z_][A-Za-z0-9_]*)\})`)
+
+// dockerfileScope resolves variables the way docker build does: ARGs declared before the first
+// FROM are only visible in FROM lines, ARGs declared in a stage are visible to the rest of that
+// stage, and ENVs are visible to the rest of the stage and to every stage built on top of it.
+// Build args override the value of declared ARGs. Values found in .env files are treated like build
+// args too; for compatibility they also resolve variables that the Dockerfile never declares.
+type dockerfileScope struct {
+	lexer       *shell.Lex
+	escapeToken rune
+	buildArgs   map[string]string
+	ambient     map[string]string
+	metaArgs    map[string]string
+	stageArgs   map[string]string
+	stageEnv    map[string]string
+	stagesEnv   map[string]map[string]string
+}
+
+func newDockerfileScope(escapeToken rune, ambient, buildArgs map[string]string) *dockerfileScope {
+	return &dockerfileScope{
+		lexer:       shell.NewLex(escapeToken),
+		escapeToken: escapeToken,
+		buildArgs:   buildArgs,
+		ambient:     ambient,
+		metaArgs:    make(map[string]string),
+		stagesEnv:   make(map[string]map[string]string),
+	}
+}
+
+// definePlatformArgs defines the automatic platform ARGs BuildKit provides in the global scope,
+// such as BUILDPLATFORM and TARGETARCH, from the platforms supplied by the caller. Platforms that
+// are not supplied stay undefined, so references to them are reported as written.
+func (s *dockerfileScope) definePlatformArgs(buildPlatform, targetPlatform string) {
+	for prefix, platform := range map[string]string{"BUILD": buildPlatform, "TARGET": targetPlatform} {
+		if platform == "" {
+			continue
+		}
+		os, rest, _ := strings.Cut(platform, "/")
+		arch, variant, _ := strings.Cut(rest, "/")
+		s.metaArgs[prefix+"PLATFORM"] = platform
+		s.metaArgs[prefix+"OS"] = os
+		s.metaArgs[prefix+"ARCH"] = arch
+		s.metaArgs[prefix+"VARIANT"] = variant
+	}
+}
+
+// inStage reports whether a FROM instruction has been seen yet.
+func (s *dockerfileScope) inStage() bool {
+	return s.stageEnv != nil
+}
+
+// declareArgs records an ARG instruction in the current scope.
+func (s *dockerfileScope) declareArgs(cmd *instructions.ArgCommand) {
+	for _, arg := range cmd.Args {
+		value, ok := s.buildArgs[arg.Key]
+		if !ok {
+			value, ok = s.ambient[arg.Key]
+		}
+		if !ok && arg.Value != nil {
+			value, ok = s.expand(*arg.Value), true
+		}
+		if !ok && s.inStage() {
+			value, ok = s.metaArgs[arg.Key]
+		}
+		if !ok {
+			continue
+		}
+
+		if s.inStage() {
+			s.stageArgs[arg.Key] = value
+		} else {
+			s.metaArgs[arg.Key] = value
+		}
+	}
+}
+
+// declareEnv records an ENV instruction in the current stage. ENV before the first FROM is
+// rejected by docker build, so it is ignored here as well.
+func (s *dockerfileScope) declareEnv(cmd *instructions.EnvCommand) {
+	if !s.inStage() {
+		return
+	}
+	for _, env := range cmd.Env {
+		s.stageEnv[env.Key] = s.expand(env.Value)
+	}
+}
+
+// startStage opens a new stage. A stage built on top of another stage inherits its ENVs.
+func (s *dockerfileScope) startStage(name, parent string) {
+	s.stageArgs = make(map[string]string)
+	s.stageEnv = make(map[string]string)
+	for k, v := range s.stagesEnv[parent] {
+		s.stageEnv[k] = v
+	}
+	if name != "" {
+		s.stagesEnv[name] = s.stageEnv
+	}
+}
+
+// expandFrom expands a word of a FROM instruction, where only ARGs declared before the first FROM are visible.
+func (s *dockerfileScope) expandFrom(word string) string {
+	return s.process(word, s.metaArgs)
+}
+
+// expand expands a word of any other instruction using the variables of the current stage.
+func (s *dockerfileScope) expand(word string) string {
+	if !s.inStage() {
+		return s.process(word, s.metaArgs)
+	}
+	vars := make(map[string]string, len(s.stageArgs)+len(s.stageEnv))
+	for k, v := range s.stageArgs {
+		vars[k] = v
+	}
+	for k, v := range s.stageEnv {
+		vars[k] = v
+	}
+	return s.process(word, vars)
+}
+
+// process runs the shell lexer over word. Variables that are unset and referenced without a
+// default are left as written, so an unresolved image is reported as such rather than mangled.
+func (s *dockerfileScope) process(word string, vars map[string]string) string {
+	env := scopeEnv{vars: vars, ambient: s.ambient}
+
+	result, err := s.lexer.ProcessWordWithMatches(word, env)
+	if err != nil {
+		log.Debug().Msgf("Could not expand variables in %q: %v", word, err)
+		return word
+	}
+	if !hasPlainReference(word, result.Unmatched, s.escapeToken) {
+		return result.Result
+	}
+
+	s.lexer.SkipUnsetEnv = true
+	defer func() { s.lexer.SkipUnsetEnv = false }()
+	result, err = s.lexer.ProcessWordWithMatches(word, env)
+	if err != nil {
+		return word
+	}
+	return result.Result
+}
+
+// hasPlainReference reports whether word refers to one of the unmatched variables outside of any
+// ${...} expression, which is where the lexer would silently substitute an empty string.
+func hasPlainReference(word string, unmatched map[string]struct{}, escapeToken rune) bool {
+	depth := 0
+	for i := 0; i < len(word); i++ {
+		switch {
+		case rune(word[i]) == escapeToken:
+			i++
+		case word[i] == '}' && depth > 0:
+			depth--
+		case word[i] == '$' && depth == 0:
+			if match := plainVariablePattern.FindStringSubmatch(word[i:]); match != nil {
+				if _, ok := unmatched[match[1]+match[2]]; ok {
+					return true
+				}
+			}
+			if i+1 < len(word) && word[i+1] == '{' {
+				depth++
+				i++
+			}
+		case word[i] == '$' && i+1 < len(word) && word[i+1] == '{':
+			depth++
+			i++
+		}
+	}
+	return false
+}
+
+// scopeEnv adapts the variables of a scope to the lexer, falling back to the .env file values.
+type scopeEnv struct {
+	vars    map[string]string
+	ambient map[string]string
+}
+
+func (e scopeEnv) Get(key string) (string, bool) {
+	if v, ok := e.vars[key]; ok {
+		return v, true
+	}
+	v, ok := e.ambient[key]
+	return v, ok
+}
+
+func (e scopeEnv) Keys() []string {
+	keys := make([]string, 0, len(e.vars)+len(e.ambient))
+	for k := range e.vars {
+		keys = append(keys, k)
+	}
+	for k := range e.ambient {
+		if _, ok := e.vars[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,75 @@
+package extractors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+func TestDockerfileScope(t *testing.T) {
+	content := `ARG BASE=alpine
+ARG TAG=3.18
+FROM ${BASE}:${TAG} AS build
+ARG TAG
+ENV GREETING hello world
+ENV A=1 B="two words" PRICE=\$5
+FROM build AS test
+ARG TAG
+`
+	result, err := parser.Parse(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Error parsing dockerfile: %v", err)
+	}
+
+	scope := newDockerfileScope(result.EscapeToken, map[string]string{"TAG": "3.20"}, nil)
+	expandedFrom := ""
+	for i, node := range result.AST.Children {
+		instruction, err := instructions.ParseInstruction(node)
+		if err != nil {
+			t.Fatalf("Error parsing instruction: %v", err)
+		}
+		switch cmd := instruction.(type) {
+		case *instructions.ArgCommand:
+			scope.declareArgs(cmd)
+		case *instructions.EnvCommand:
+			scope.declareEnv(cmd)
+		case *instructions.Stage:
+			if i == 2 {
+				if got := scope.expand("${TAG}-${GREETING}"); got != "3.20-${GREETING}" {
+					t.Errorf("Expected meta ARG to be hidden outside FROM, but got %q", got)
+				}
+				expandedFrom = scope.expandFrom(cmd.BaseName)
+				scope.startStage(cmd.Name, "")
+				if got := scope.expand("$BASE"); got != "$BASE" {
+					t.Errorf("Expected meta ARG BASE to be invisible in stage, but got %q", got)
+				}
+			} else {
+				scope.startStage(cmd.Name, "build")
+			}
+		}
+	}
+
+	if expandedFrom != "alpine:3.20" {
+		t.Errorf("Expected FROM to expand to alpine:3.20, but got %q", expandedFrom)
+	}
+
+	tests := map[string]string{
+		"${TAG}":                   "3.20",
+		"${GREETING}":              "hello world",
+		"${A}/${B}/${PRICE}":       "1/two words/$5",
+		"${MISSING:-fallback}":     "fallback",
+		"${MISSING-fallback}":      "fallback",
+		"${A:+alt}":                "alt",
+		"${MISSING:+alt}":          "",
+		`\$A`:                      "$A",
+		"${MISSING}":               "${MISSING}",
+		"img:${MISSING:-1.0}-$TAG": "img:1.0-3.20",
+	}
+	for word, expected := range tests {
+		if got := scope.expand(word); got != expected {
+			t.Errorf("Expected %q to expand to %q, but got %q", word, expected, got)
+		}
+	}
+}
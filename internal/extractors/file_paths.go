@@ -0,0 +1,21 @@
+package extractors
+
+import (
+	"path"
+	"path/filepath"
+
+	"github.com/Checkmarx/containers-types/types"
+)
+
+// relatedFile resolves a file referenced by another file, such as a Compose include, a Kustomize
+// resource or a Helmfile values file, relative to the file referencing it. An absolute reference is
+// returned as is.
+func relatedFile(from types.FilePath, ref string) types.FilePath {
+	if filepath.IsAbs(ref) {
+		return types.FilePath{FullPath: ref, RelativePath: filepath.ToSlash(ref)}
+	}
+	return types.FilePath{
+		FullPath:     filepath.Join(filepath.Dir(from.FullPath), ref),
+		RelativePath: path.Clean(path.Join(path.Dir(from.RelativePath), filepath.ToSlash(ref))),
+	}
+}
@@ -0,0 +1,189 @@
+package extractors
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// HelmChartAnnotationOrigin is the Origin of the images declared by the artifacthub.io/images
+// annotation of a Chart.yaml.
+const HelmChartAnnotationOrigin = "HelmChartAnnotation"
+
+// artifactHubImagesAnnotation is the Chart.yaml annotation Artifact Hub lists the images of a chart from.
+const artifactHubImagesAnnotation = "artifacthub.io/images"
+
+// helmChartAnnotation is the artifacthub.io/images annotation of a chart, with the images it declares.
+type helmChartAnnotation struct {
+	relativePath string
+	// line is the 0-based line of the annotation key.
+	line   int
+	images []types.ImageModel
+}
+
+// helmChartFileOf returns the Chart.yaml a chart was loaded with, from its directory or its archive.
+func helmChartFileOf(helmChart *chart.Chart, h types.HelmChartInfo) helmChartFile {
+	chartFile := helmChartFile{relativePath: helmChartFilePath(h)}
+	for _, file := range helmChart.Raw {
+		if file.Name == chartutil.ChartfileName {
+			chartFile.content = file.Data
+			break
+		}
+	}
+	return chartFile
+}
+
+// findHelmChartAnnotation parses the artifacthub.io/images annotation of a Chart.yaml, or returns nil
+// when it has none.
+func findHelmChartAnnotation(chartFile helmChartFile) *helmChartAnnotation {
+	root := parseHelmChartFile(chartFile)
+	key, value := mappingEntryOf(mappingValue(root, "annotations"), artifactHubImagesAnnotation)
+	if value == nil {
+		return nil
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(value.Value), &document); err != nil {
+		log.Debug().Msgf("Could not parse the %s annotation of %s err: %+v", artifactHubImagesAnnotation, chartFile.relativePath, err)
+		return nil
+	}
+
+	annotation := &helmChartAnnotation{relativePath: chartFile.relativePath, line: key.Line - 1}
+	entries := resolveAlias(firstContent(&document))
+	if entries == nil || entries.Kind != yaml.SequenceNode {
+		return annotation
+	}
+	for _, entry := range entries.Content {
+		node := scalarNode(mappingValue(entry, "image"))
+		if node == nil || node.Value == "" {
+			continue
+		}
+		line, start, end := annotationSpan(chartFile.content, key, value, node)
+		annotation.images = append(annotation.images, types.ImageModel{
+			Name:  node.Value,
+			IsSha: strings.Contains(node.Value, "@"),
+			ImageLocations: []types.ImageLocation{{
+				Origin:     HelmChartAnnotationOrigin,
+				Path:       chartFile.relativePath,
+				Line:       line,
+				StartIndex: start,
+				EndIndex:   end,
+			}},
+		})
+	}
+	return annotation
+}
+
+// declaredImages returns the images the annotation declares, none when the chart has no annotation.
+func (a *helmChartAnnotation) declaredImages() []types.ImageModel {
+	if a == nil {
+		return nil
+	}
+	return a.images
+}
+
+// mappingEntryOf returns the key and value of an entry of a mapping, or nils when it has none.
+func mappingEntryOf(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for _, entry := range mappingEntries(mapping) {
+		if entry.key.Value == key {
+			return entry.key, scalarNode(entry.value)
+		}
+	}
+	return nil, nil
+}
+
+// annotationSpan locates a scalar of the YAML embedded in an annotation in the Chart.yaml holding it.
+// Only literal block annotations keep the lines of the embedded YAML. A scalar of an annotation of
+// another style is located at the line of the annotation key, with columns of -1.
+func annotationSpan(content []byte, key, annotation, node *yaml.Node) (line, start, end int) {
+	if annotation.Style != yaml.LiteralStyle {
+		return key.Line - 1, -1, -1
+	}
+	// The embedded YAML starts on the line after the | indicator, indented as its first non-blank line
+	lines := strings.Split(string(content), "\n")
+	indent := -1
+	for _, l := range lines[min(annotation.Line, len(lines)):] {
+		if strings.TrimSpace(l) != "" {
+			indent = len(l) - len(strings.TrimLeft(l, " "))
+			break
+		}
+	}
+	if indent == -1 {
+		return key.Line - 1, -1, -1
+	}
+	line, start, end = scalarSpan(node)
+	return annotation.Line + line, indent + start, indent + end
+}
+
+// helmAnnotationDiagnostics cross-checks the images declared by the annotation of a chart with the
+// images it renders, warning about the declared images that are not rendered and the rendered images
+// that are not declared.
+func helmAnnotationDiagnostics(annotation *helmChartAnnotation, rendered []ImageDetails) []Diagnostic {
+	if annotation == nil {
+		return nil
+	}
+
+	declared := make(map[string]bool, len(annotation.images))
+	for _, image := range annotation.images {
+		declared[normalizeImageName(image.Name)] = true
+	}
+	renders := make(map[string]bool, len(rendered))
+	for _, image := range rendered {
+		renders[normalizeImageName(image.Name)] = true
+	}
+
+	var diagnostics []Diagnostic
+	for _, image := range annotation.images {
+		if renders[normalizeImageName(image.Name)] {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: DiagnosticWarning,
+			Path:     annotation.relativePath,
+			Line:     image.ImageLocations[0].Line,
+			Message:  "image " + image.Name + " of the " + artifactHubImagesAnnotation + " annotation is not rendered by the chart",
+		})
+	}
+	for _, image := range rendered {
+		name := normalizeImageName(image.Name)
+		if declared[name] || strings.Contains(image.Name, helmRequiredPlaceholder) {
+			continue
+		}
+		declared[name] = true
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: DiagnosticWarning,
+			Path:     annotation.relativePath,
+			Line:     annotation.line,
+			Message:  "image " + image.Name + " rendered by the chart is missing from the " + artifactHubImagesAnnotation + " annotation",
+		})
+	}
+	return diagnostics
+}
+
+// normalizeImageName spells an image reference the way the Docker Hub resolves it, so that nginx,
+// nginx:latest and docker.io/library/nginx:latest compare equal.
+func normalizeImageName(name string) string {
+	name, _ = formatDockerfileImage(name)
+	for _, prefix := range []string{"docker.io/", "index.docker.io/", "registry-1.docker.io/"} {
+		if strings.HasPrefix(name, prefix) {
+			name = strings.TrimPrefix(name, prefix)
+			break
+		}
+	}
+	return strings.TrimPrefix(name, "library/")
+}
+
+// helmChartFilePath returns the path of the Chart.yaml of a chart, next to its values file when it
+// has one.
+func helmChartFilePath(h types.HelmChartInfo) string {
+	if h.ValuesFile == "" {
+		return chartutil.ChartfileName
+	}
+	return path.Join(path.Dir(filepath.ToSlash(h.ValuesFile)), chartutil.ChartfileName)
+}
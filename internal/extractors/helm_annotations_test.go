@@ -0,0 +1,156 @@
+package extractors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
+)
+
+const annotatedExporter = "nginx/nginx-prometheus-exporter@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestExtractImagesWithLineNumbersFromHelmFiles_Annotations(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-annotations", ValuesFile: "charts/annotations/values.yaml"},
+		{Directory: "../../test_files/helm-environments", ValuesFile: "values.yaml"},
+	}
+
+	found, err := ExtractImagesWithLineNumbersFromHelmFiles(helmCharts)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	var images []types.ImageModel
+	for _, image := range found {
+		if image.ImageLocations[0].Origin == HelmChartAnnotationOrigin {
+			images = append(images, image)
+		}
+	}
+
+	image := func(name string, isSha bool, line, start, end int) types.ImageModel {
+		return types.ImageModel{Name: name, IsSha: isSha, ImageLocations: []types.ImageLocation{
+			{Origin: HelmChartAnnotationOrigin, Path: "charts/annotations/Chart.yaml", Line: line, StartIndex: start, EndIndex: end},
+		}}
+	}
+	expected := []types.ImageModel{
+		image("docker.io/library/nginx:1.25.3", false, 10, 13, 43),
+		image("busybox:1.35", false, 12, 14, 26),
+		image(annotatedExporter, true, 17, 13, 13+len(annotatedExporter)),
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
+
+func TestExtractImagesFromHelmFiles_AnnotationImages(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-annotations", ValuesFile: "values.yaml"},
+	}
+	annotated := func(images []types.ImageModel) []string {
+		var names []string
+		for _, image := range images {
+			if image.ImageLocations[0].Origin == HelmChartAnnotationOrigin {
+				names = append(names, image.Name)
+			}
+		}
+		return names
+	}
+	expected := []string{"docker.io/library/nginx:1.25.3", "busybox:1.35", annotatedExporter}
+
+	images, err := ExtractImagesFromHelmFilesWithOptions(helmCharts, HelmOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	if names := annotated(images); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected the rendered images to be followed by %v but got %v", expected, names)
+	}
+	for _, image := range images {
+		location := image.ImageLocations[0]
+		if location.Origin == HelmChartAnnotationOrigin && location != (types.ImageLocation{Origin: HelmChartAnnotationOrigin, Path: location.Path}) {
+			t.Errorf("Expected image %s to carry no line info but got %+v", image.Name, location)
+		}
+	}
+
+	details, err := ExtractImageDetailsFromHelmFiles(helmCharts, HelmOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	if names := annotated(ImageModels(details)); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected the traced images to be followed by %v but got %v", expected, names)
+	}
+}
+
+func TestExtractImagesFromHelmFilesWithOptions_AnnotationDiagnostics(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-annotations", ValuesFile: "values.yaml"},
+	}
+
+	tests := []struct {
+		name     string
+		options  HelmOptions
+		expected []Diagnostic
+	}{
+		{"OutOfDate", HelmOptions{}, []Diagnostic{
+			{Severity: DiagnosticWarning, Path: "Chart.yaml", Line: 12,
+				Message: "image busybox:1.35 of the artifacthub.io/images annotation is not rendered by the chart"},
+			{Severity: DiagnosticWarning, Path: "Chart.yaml", Line: 8,
+				Message: "image busybox:1.36 rendered by the chart is missing from the artifacthub.io/images annotation"},
+		}},
+		{"UpToDate", HelmOptions{Set: []string{"cleanup.image=busybox:1.35"}}, nil},
+		{"NotRendered", HelmOptions{Set: []string{"exporter.enabled=false,cleanup.image=busybox:1.35"}}, []Diagnostic{
+			{Severity: DiagnosticWarning, Path: "Chart.yaml", Line: 17,
+				Message: "image " + annotatedExporter + " of the artifacthub.io/images annotation is not rendered by the chart"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var diagnostics []Diagnostic
+			_, err := ExtractImagesFromHelmFilesWithOptions(helmCharts, test.options, func(diagnostic Diagnostic) {
+				diagnostics = append(diagnostics, diagnostic)
+			})
+			if err != nil {
+				t.Fatalf("Error extracting images: %v", err)
+			}
+			if !reflect.DeepEqual(diagnostics, test.expected) {
+				t.Errorf("Expected diagnostics %+v but got %+v", test.expected, diagnostics)
+			}
+		})
+	}
+}
+
+func TestFindHelmChartAnnotation_FlowStyle(t *testing.T) {
+	chartFile := helmChartFile{relativePath: "Chart.yaml", content: []byte(
+		"apiVersion: v2\nname: flow\nversion: 0.1.0\nannotations:\n  artifacthub.io/images: '[{\"name\": \"app\", \"image\": \"ghcr.io/org/app:1.0.0\"}]'\n")}
+	root := parseHelmChartFile(chartFile)
+	key, value := mappingEntryOf(mappingValue(root, "annotations"), artifactHubImagesAnnotation)
+	if value == nil {
+		t.Fatal("Expected the annotation to be found")
+	}
+	if line, start, end := annotationSpan(chartFile.content, key, value, value); line != 4 || start != -1 || end != -1 {
+		t.Errorf("Expected a quoted annotation to be located at its key line only, got %d %d %d", line, start, end)
+	}
+
+	images := findHelmChartAnnotation(chartFile).declaredImages()
+	expected := []types.ImageModel{{Name: "ghcr.io/org/app:1.0.0", ImageLocations: []types.ImageLocation{
+		{Origin: HelmChartAnnotationOrigin, Path: "Chart.yaml", Line: 4, StartIndex: -1, EndIndex: -1},
+	}}}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
+
+func TestNormalizeImageName(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                           "nginx:latest",
+		"docker.io/library/nginx:1.25":    "nginx:1.25",
+		"index.docker.io/bitnami/redis:7": "bitnami/redis:7",
+		"ghcr.io/library/app:1.0":         "ghcr.io/library/app:1.0",
+		"localhost:5000/app":              "localhost:5000/app:latest",
+		"docker.io/org/app@sha256:abc":    "org/app:latest@sha256:abc",
+		"registry-1.docker.io/library/pg": "pg:latest",
+	}
+	for name, expected := range tests {
+		if normalized := normalizeImageName(name); normalized != expected {
+			t.Errorf("Expected %s to be normalized to %s but got %s", name, expected, normalized)
+		}
+	}
+}
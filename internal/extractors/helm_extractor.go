@@ -2,14 +2,18 @@ package extractors
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"path"
 	"regexp"
 
 	"github.com/Checkmarx/containers-types/types"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
-	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
 
 	"os"
 	"path/filepath"
@@ -17,28 +21,91 @@ import (
 )
 
 func ExtractImagesFromHelmFiles(helmCharts []types.HelmChartInfo) ([]types.ImageModel, error) {
+	return ExtractImagesFromHelmFilesWithOptions(helmCharts, HelmOptions{}, nil)
+}
 
-	var imagesFromHelmDirectories []types.ImageModel
+// ExtractImagesFromHelmFilesWithOptions is ExtractImagesFromHelmFiles rendering every chart with the
+// values files and overrides of options, once per environment and render profile. The images declared
+// by the artifacthub.io/images annotation of a chart follow its rendered images and, like them, carry
+// no line info. The templates left out by tolerant rendering, and the images a chart renders
+// differently than its annotation declares, are passed to report.
+func ExtractImagesFromHelmFilesWithOptions(helmCharts []types.HelmChartInfo, options HelmOptions, report DiagnosticReporter) ([]types.ImageModel, error) {
+	images, err := ExtractRenderedImageDetailsFromHelmFiles(helmCharts, options, report)
+	return ImageModels(images), err
+}
+
+// ExtractRenderedImageDetailsFromHelmFiles is ExtractImagesFromHelmFilesWithOptions returning every
+// image with the workload, container and environment it was rendered for, located at the template
+// rendering it.
+func ExtractRenderedImageDetailsFromHelmFiles(helmCharts []types.HelmChartInfo, options HelmOptions, report DiagnosticReporter) ([]ImageDetails, error) {
+
+	var imagesFromHelmDirectories []ImageDetails
 	for _, h := range helmCharts {
 		log.Info().Msgf("going to extract images from helm directory %s", h.Directory)
 
-		renderedTemplates, err := generateRenderedTemplates(h)
+		helmChart, err := loadHelmChart(h)
 		if err != nil {
 			log.Err(err).Msgf("Could not render templates from helm directory %s", h.Directory)
 			continue
 		}
 
-		images, err := extractImageInfo(renderedTemplates)
+		annotation := findHelmChartAnnotation(helmChartFileOf(helmChart, h))
+		chartImages := findHelmChartImages(h, annotation, helmEnvironments(h, options), options.RenderAllBranches, report,
+			func(environment helmEnvironment) ([]ImageDetails, []Diagnostic, error) {
+				return renderHelmEnvironmentImages(helmChart, environment)
+			})
+
+		images := append(chartImages, ImageDetailsOf(withoutLineInfo(annotation.declaredImages(), HelmChartAnnotationOrigin))...)
+		printFoundImages(h, ImageModels(images))
+		imagesFromHelmDirectories = append(imagesFromHelmDirectories, images...)
+	}
+
+	return imagesFromHelmDirectories, nil
+}
+
+// findHelmChartImages finds the images of a loaded chart in every environment with find, uniting them
+// when union is set. The problems find returns, and the images the chart renders differently than
+// its artifacthub.io/images annotation declares, are passed to report.
+func findHelmChartImages(h types.HelmChartInfo, annotation *helmChartAnnotation, environments []helmEnvironment, union bool,
+	report DiagnosticReporter, find func(helmEnvironment) ([]ImageDetails, []Diagnostic, error)) []ImageDetails {
+	var chartImages []ImageDetails
+	var diagnostics []Diagnostic
+	rendered := false
+	for _, environment := range environments {
+		images, failures, err := find(environment)
+		diagnostics = append(diagnostics, failures...)
 		if err != nil {
 			log.Err(err).Msgf("Could not extract images from helm directory %s", h.Directory)
 			continue
 		}
-
-		printFoundImages(h, images)
-		imagesFromHelmDirectories = append(imagesFromHelmDirectories, images...)
+		chartImages = append(chartImages, images...)
+		rendered = true
+	}
+	if union {
+		chartImages = unionImageDetails(chartImages)
 	}
+	if rendered {
+		diagnostics = append(diagnostics, helmAnnotationDiagnostics(annotation, chartImages)...)
+	}
+	reportHelmDiagnostics(report, diagnostics)
+	return chartImages
+}
 
-	return imagesFromHelmDirectories, nil
+// renderHelmEnvironmentImages renders a loaded chart in an environment and returns the images of its
+// manifests, with the templates left out by tolerant rendering and the images built from placeholders.
+func renderHelmEnvironmentImages(helmChart *chart.Chart, environment helmEnvironment) ([]ImageDetails, []Diagnostic, error) {
+	renderedTemplates, diagnostics, err := renderHelmEnvironment(helmChart, environment)
+	if err != nil {
+		return nil, diagnostics, err
+	}
+	images, err := extractRenderedImages(renderedTemplates)
+	if err != nil {
+		return nil, diagnostics, err
+	}
+	for i := range images {
+		images[i].Environment = environment.name
+	}
+	return images, append(diagnostics, helmPlaceholderDiagnostics(images)...), nil
 }
 
 func printFoundImages(h types.HelmChartInfo, images []types.ImageModel) {
@@ -51,36 +118,35 @@ func printFoundImages(h types.HelmChartInfo, images []types.ImageModel) {
 	}(), ", "))
 }
 
-func generateRenderedTemplates(c types.HelmChartInfo) (string, error) {
-	actionConfig := new(action.Configuration)
-
-	client := action.NewInstall(actionConfig)
-	client.DryRun = true
-	client.ReleaseName = "temp-release"
-	client.ClientOnly = true
-
+func loadHelmChart(c types.HelmChartInfo) (*chart.Chart, error) {
 	chartPath, err := filepath.Abs(c.Directory)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	chart, err := loader.Load(chartPath)
+	return loader.Load(chartPath)
+}
+
+// renderHelmEnvironment renders the manifests of a loaded chart with the values of an environment,
+// along with the templates left out by tolerant rendering.
+func renderHelmEnvironment(helmChart *chart.Chart, environment helmEnvironment) (string, []Diagnostic, error) {
+	layers, err := environment.layers()
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-
-	release, err := client.Run(chart, nil)
+	vals, err := environment.values(layers)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-
-	return release.Manifest, nil
+	return renderHelmChart(helmChart, vals, environment)
 }
 
-func extractImageInfo(yamlString string) ([]types.ImageModel, error) {
+// extractRenderedImages returns the images of rendered manifests: the image of Microservice resources
+// and the images of the containers of Kubernetes workloads, with the resource they come from.
+func extractRenderedImages(yamlString string) ([]ImageDetails, error) {
 	sections := strings.Split(yamlString, "---")
 
-	var imageInfoList []types.ImageModel
+	var imageInfoList []ImageDetails
 
 	for _, section := range sections {
 		if strings.TrimSpace(section) == "" {
@@ -92,28 +158,52 @@ func extractImageInfo(yamlString string) ([]types.ImageModel, error) {
 		if err != nil {
 			return nil, err
 		}
+		var document yaml.Node
+		if err := yaml.Unmarshal([]byte(section), &document); err != nil {
+			return nil, err
+		}
+		resource := resolveAlias(firstContent(&document))
 
 		s, _ := extractSource(section)
-		n := extractImageName(microservice)
-
-		if n != "" {
-			v := types.ImageModel{
-				Name: n,
-				ImageLocations: []types.ImageLocation{
-					{
-						Origin: types.HelmFileOrigin,
-						Path:   s,
+		newImage := func(name, container string) ImageDetails {
+			return ImageDetails{
+				ImageModel: types.ImageModel{
+					Name: name,
+					ImageLocations: []types.ImageLocation{
+						{
+							Origin: types.HelmFileOrigin,
+							Path:   s,
+						},
 					},
 				},
+				KubernetesDetails: KubernetesDetails{
+					Kind:      scalarValue(mappingValue(resource, "kind")),
+					Workload:  scalarValue(mappingValue(mappingValue(resource, "metadata"), "name")),
+					Container: container,
+				},
+				HelmDetails: HelmDetails{Subchart: helmSubchart(s)},
 			}
+		}
 
-			imageInfoList = append(imageInfoList, v)
+		if n := extractImageName(microservice); n != "" {
+			imageInfoList = append(imageInfoList, newImage(n, ""))
+		}
+		for _, image := range workloadImages(resource) {
+			imageInfoList = append(imageInfoList, newImage(image.node.Value, image.container))
 		}
 	}
 
 	return imageInfoList, nil
 }
 
+// firstContent returns the first node of a document, or nil for an empty document.
+func firstContent(document *yaml.Node) *yaml.Node {
+	if len(document.Content) == 0 {
+		return nil
+	}
+	return document.Content[0]
+}
+
 func extractImageName(microservice types.Microservice) string {
 	var imageName string
 	if microservice.Spec.Image.Registry != "" {
@@ -129,6 +219,19 @@ func extractImageName(microservice types.Microservice) string {
 	return imageName
 }
 
+// helmSubchart returns the subchart a rendered template comes from, given the source path of the
+// template: redis for chart/charts/redis/templates/x.yaml, or backend/redis for a subchart of the
+// backend subchart. It is empty for the templates of the chart itself.
+func helmSubchart(source string) string {
+	parts := strings.Split(source, "/charts/")
+	var subcharts []string
+	for _, part := range parts[1:] {
+		subchart, _, _ := strings.Cut(part, "/")
+		subcharts = append(subcharts, subchart)
+	}
+	return strings.Join(subcharts, "/")
+}
+
 func extractSource(yamlBlock string) (string, error) {
 	sourceRegex := regexp.MustCompile(`#\s*Source:\s*([^\n]+)`)
 	match := sourceRegex.FindStringSubmatch(yamlBlock)
@@ -147,13 +250,25 @@ var imagePatternRelaxed = regexp.MustCompile(`^\s*image:\s*([^\s#]+:[^\s#]+)`) /
 // Strict regex: matches only if the image reference is alone or followed by whitespace and/or a comment (e.g., 'image: myrepo/myimage:mytag' or 'image: myrepo/myimage:mytag # comment')
 var imagePatternStrict = regexp.MustCompile(`^\s*image:\s*([^\s#]+:[^\s#]+)\s*(#.*)?$`) // Use this if you want to enforce stricter matching
 
-// ExtractImagesWithLineNumbersFromHelmFiles extracts image references with line numbers and character indices from Helm template and values files.
+// ExtractImagesWithLineNumbersFromHelmFiles extracts image references with line numbers and character indices from Helm template and values files,
+// and the images declared by the artifacthub.io/images annotation of the Chart.yaml, located at their image key.
 func ExtractImagesWithLineNumbersFromHelmFiles(helmCharts []types.HelmChartInfo) ([]types.ImageModel, error) {
 	var imagesFromHelmDirectories []types.ImageModel
 	// Currently using the relaxed regex. Switch to imagePatternStrict for stricter behavior.
 	imagePattern := imagePatternRelaxed
 
 	for _, chart := range helmCharts {
+		// Packaged charts are scanned inside their archive
+		if isHelmChartArchive(chart.Directory) {
+			fileImages, err := extractImagesWithLineInfoFromArchive(chart.Directory, imagePattern)
+			if err != nil {
+				log.Err(err).Msgf("Could not extract images with line info from helm chart archive: %s", chart.Directory)
+				continue
+			}
+			imagesFromHelmDirectories = append(imagesFromHelmDirectories, fileImages...)
+			continue
+		}
+
 		// Process template files recursively
 		for _, templateFile := range chart.TemplateFiles {
 			fileImages, err := extractImagesWithLineInfoFromFile(templateFile.RelativePath, templateFile.FullPath, imagePattern)
@@ -170,6 +285,7 @@ func ExtractImagesWithLineNumbersFromHelmFiles(helmCharts []types.HelmChartInfo)
 			log.Err(err).Msgf("Could not find values files in chart directory: %s", chart.Directory)
 			continue
 		}
+		var valuesContents []helmChartFile
 		for _, valuesFile := range valuesFiles {
 			fileImages, err := extractImagesWithLineInfoFromFile(valuesFile.RelativePath, valuesFile.FullPath, imagePattern)
 			if err != nil {
@@ -177,21 +293,96 @@ func ExtractImagesWithLineNumbersFromHelmFiles(helmCharts []types.HelmChartInfo)
 				continue
 			}
 			imagesFromHelmDirectories = append(imagesFromHelmDirectories, fileImages...)
+
+			content, err := os.ReadFile(valuesFile.FullPath)
+			if err != nil {
+				continue
+			}
+			valuesContents = append(valuesContents, helmChartFile{relativePath: valuesFile.RelativePath, content: content})
 		}
+
+		// Process the image maps of the values files, such as image: {repository: ..., tag: ...}
+		chartContent, _ := os.ReadFile(filepath.Join(chart.Directory, "Chart.yaml"))
+		chartFile := helmChartFile{relativePath: "Chart.yaml", content: chartContent}
+		imagesFromHelmDirectories = append(imagesFromHelmDirectories, extractImagesFromValuesMaps(chartFile, valuesContents)...)
+
+		// Report the images declared by the artifacthub.io/images annotation of the Chart.yaml
+		chartFile.relativePath = helmChartFilePath(chart)
+		imagesFromHelmDirectories = append(imagesFromHelmDirectories, findHelmChartAnnotation(chartFile).declaredImages()...)
 	}
 	return imagesFromHelmDirectories, nil
 }
 
 // extractImagesWithLineInfoFromFile scans a file for image references and returns ImageModels with line and index info.
 func extractImagesWithLineInfoFromFile(relativePath, fullPath string, imagePattern *regexp.Regexp) ([]types.ImageModel, error) {
-	var images []types.ImageModel
 	file, err := os.Open(fullPath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	return extractImagesWithLineInfo(relativePath, file, imagePattern)
+}
+
+// extractImagesWithLineInfoFromArchive scans the templates and values files of a packaged chart for
+// image references, located at their path inside the archive.
+func extractImagesWithLineInfoFromArchive(archivePath string, imagePattern *regexp.Regexp) ([]types.ImageModel, error) {
+	helmChart, err := loader.Load(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []types.ImageModel
+	var chartFile helmChartFile
+	var valuesFiles []helmChartFile
+	for _, file := range helmChart.Raw {
+		relativePath := helmChart.Name() + "/" + file.Name
+		if file.Name == chartutil.ChartfileName {
+			chartFile = helmChartFile{relativePath: relativePath, content: file.Data}
+		}
+		if !isTemplateOrValuesFile(file.Name) {
+			continue
+		}
+		fileImages, err := extractImagesWithLineInfo(relativePath, bytes.NewReader(file.Data), imagePattern)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, fileImages...)
+		if valuesFilePattern.MatchString(file.Name) {
+			valuesFiles = append(valuesFiles, helmChartFile{relativePath: relativePath, content: file.Data})
+		}
+	}
+	images = append(images, extractImagesFromValuesMaps(chartFile, valuesFiles)...)
+	return append(images, findHelmChartAnnotation(chartFile).declaredImages()...), nil
+}
+
+// valuesFilePattern matches the values files of findValuesFiles: values.yaml, values-*.yaml, values.yml and values-*.yml.
+var valuesFilePattern = regexp.MustCompile(`^values(-[^/]*)?\.ya?ml$`)
+
+// isTemplateOrValuesFile reports whether a file of a chart, given by its path in the chart, is a YAML
+// template or one of the values files found by findValuesFiles.
+func isTemplateOrValuesFile(name string) bool {
+	if strings.HasPrefix(name, "templates/") {
+		return isYAMLPath(name)
+	}
+	return valuesFilePattern.MatchString(name)
+}
+
+func isYAMLPath(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// isHelmChartArchive reports whether a chart directory is a packaged chart archive.
+func isHelmChartArchive(chartPath string) bool {
+	info, err := os.Stat(chartPath)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// extractImagesWithLineInfo scans content for image references and returns ImageModels with line and index info.
+func extractImagesWithLineInfo(relativePath string, content io.Reader, imagePattern *regexp.Regexp) ([]types.ImageModel, error) {
+	var images []types.ImageModel
+	scanner := bufio.NewScanner(content)
 	lineNum := 0
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -1,6 +1,8 @@
 package extractors
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/Checkmarx/containers-types/types"
@@ -58,7 +60,93 @@ func TestExtractImagesFromHelmFiles(t *testing.T) {
 	})
 }
 
-func TestExtractImageInfo(t *testing.T) {
+func TestExtractImagesFromHelmFilesWithOptions(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-environments", ValuesFile: "values.yaml"},
+	}
+
+	tests := []struct {
+		name     string
+		options  HelmOptions
+		expected []string
+	}{
+		{"DefaultValues", HelmOptions{}, []string{"ghcr.io/org/shop:1.0.0"}},
+		{"ValuesFiles", HelmOptions{ValuesFiles: []string{"overrides.yaml"}}, []string{"ghcr.io/org/shop:2.0.0-rc1"}},
+		{"SetAfterValuesFiles", HelmOptions{ValuesFiles: []string{"overrides.yaml"}, Set: []string{"image.tag=3.0.0,metrics.enabled=true"}},
+			[]string{"ghcr.io/org/shop:3.0.0", "prom/statsd-exporter:v0.26.0"}},
+		{"MissingValuesFile", HelmOptions{ValuesFiles: []string{"values-missing.yaml"}}, []string{"ghcr.io/org/shop:1.0.0"}},
+		{"Environments", HelmOptions{Environments: true}, []string{
+			"ghcr.io/org/shop:1.0.0",
+			"ghcr.io/org/shop-dev:1.0.0",
+			"ghcr.io/org/shop:1.0.0-prod", "prom/statsd-exporter:v0.26.0",
+		}},
+		{"EnvironmentsWithValuesFiles", HelmOptions{Environments: true, ValuesFiles: []string{"overrides.yaml"}}, []string{
+			"ghcr.io/org/shop:2.0.0-rc1",
+			"ghcr.io/org/shop-dev:2.0.0-rc1",
+			"ghcr.io/org/shop:2.0.0-rc1", "prom/statsd-exporter:v0.26.0",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			images, err := ExtractImagesFromHelmFilesWithOptions(helmCharts, test.options, nil)
+			if err != nil {
+				t.Fatalf("Error extracting images: %v", err)
+			}
+			var names []string
+			for _, image := range images {
+				names = append(names, image.Name)
+			}
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("Expected images %v but got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestExtractImagesFromHelmFilesWithOptions_SubchartEnvironments(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-subcharts/umbrella", ValuesFile: "umbrella/values.yaml"},
+	}
+
+	images, err := ExtractImagesFromHelmFilesWithOptions(helmCharts, HelmOptions{Environments: true}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	expected := []string{
+		"ghcr.io/org/web:2.4.0", "redis:7.2",
+		"quay.io/prometheus/node-exporter:v1.8.0", "ghcr.io/org/web:2.4.0", "redis:7.2",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected images %v but got %v", expected, names)
+	}
+}
+
+func TestRenderChart_SubchartOffThenOn(t *testing.T) {
+	helmChart, err := loadHelmChart(types.HelmChartInfo{Directory: "../../test_files/helm-subcharts/umbrella"})
+	if err != nil {
+		t.Fatalf("Error loading chart: %v", err)
+	}
+
+	for _, enabled := range []bool{false, true, false} {
+		vals := map[string]interface{}{"metrics": map[string]interface{}{"enabled": enabled}}
+		manifest, err := renderChart(helmChart, vals, HelmRenderOptions{})
+		if err != nil {
+			t.Fatalf("Error rendering chart: %v", err)
+		}
+		if rendered := strings.Contains(manifest, "node-exporter"); rendered != enabled {
+			t.Errorf("Expected the metrics subchart to be rendered %v but got %v", enabled, rendered)
+		}
+	}
+	if len(helmChart.Dependencies()) != 3 {
+		t.Errorf("Expected the loaded chart to keep its 3 subcharts, got %d", len(helmChart.Dependencies()))
+	}
+}
+
+func TestExtractRenderedImages(t *testing.T) {
 	t.Run("ValidYAMLString", func(t *testing.T) {
 		yamlString := `---
 # Source: containers/templates/image-insights.yaml
@@ -246,7 +334,7 @@ spec:
     httpGet:
       path: "/health"
       port: 80`
-		images, err := extractImageInfo(yamlString)
+		images, err := extractRenderedImages(yamlString)
 		if err != nil {
 			t.Errorf("Error extracting images: %v", err)
 		}
@@ -255,13 +343,13 @@ spec:
 			"nginx:latest": {Origin: types.HelmFileOrigin, Path: "containers/templates/containers-image-risks.yaml"},
 		}
 
-		checkHelmResult(t, images, expectedImages)
+		checkHelmResult(t, ImageModels(images), expectedImages)
 	})
 
 	t.Run("InvalidYAMLString", func(t *testing.T) {
 		yamlString := `invalid yaml string`
 
-		_, err := extractImageInfo(yamlString)
+		_, err := extractRenderedImages(yamlString)
 		if err == nil {
 			t.Errorf("Expected error extracting images from invalid YAML string, but got none")
 		}
@@ -410,3 +498,238 @@ func checkHelmResult(t *testing.T, images []types.ImageModel, expectedImages map
 		t.Errorf("Expected image %s not found (Origin: %s, Path: %s)", imageName, expectedLocation.Origin, expectedLocation.Path)
 	}
 }
+
+func TestExtractRenderedImages_Workloads(t *testing.T) {
+	manifest := `---
+# Source: app/templates/pod.yaml
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+    - name: shell
+      image: busybox:1.36
+  ephemeralContainers:
+    - name: debugger
+      image: ghcr.io/org/debugger:0.3
+---
+# Source: app/templates/workloads.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrate
+          image: ghcr.io/org/migrations:1.1
+      containers:
+        - name: web
+          image: nginx:1.27
+        - name: no-image
+---
+# Source: app/templates/workloads.yaml
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  template:
+    spec:
+      containers:
+        - name: postgres
+          image: postgres:16
+---
+# Source: app/templates/workloads.yaml
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  template:
+    spec:
+      containers:
+        - name: agent
+          image: datadog/agent:7
+---
+# Source: app/templates/workloads.yaml
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: cache
+spec:
+  template:
+    spec:
+      containers:
+        - name: redis
+          image: redis:7.4
+---
+# Source: app/templates/workloads.yaml
+apiVersion: v1
+kind: ReplicationController
+metadata:
+  name: legacy
+spec:
+  template:
+    spec:
+      containers:
+        - name: legacy
+          image: httpd:2.4
+---
+# Source: app/templates/jobs.yaml
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: seed
+spec:
+  template:
+    spec:
+      containers:
+        - name: seed
+          image: ghcr.io/org/seed:2.0
+---
+# Source: app/templates/jobs.yaml
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: backup
+              image: ghcr.io/org/backup:1.0
+---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  containers:
+    - name: ignored
+      image: ignored:1.0
+`
+
+	images, err := extractRenderedImages(manifest)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	type origin struct{ name, path, kind, workload, container string }
+	var found []origin
+	for _, image := range images {
+		found = append(found, origin{image.Name, image.ImageLocations[0].Path, image.Kind, image.Workload, image.Container})
+	}
+	expected := []origin{
+		{"busybox:1.36", "app/templates/pod.yaml", "Pod", "debug", "shell"},
+		{"ghcr.io/org/debugger:0.3", "app/templates/pod.yaml", "Pod", "debug", "debugger"},
+		{"ghcr.io/org/migrations:1.1", "app/templates/workloads.yaml", "Deployment", "web", "migrate"},
+		{"nginx:1.27", "app/templates/workloads.yaml", "Deployment", "web", "web"},
+		{"postgres:16", "app/templates/workloads.yaml", "StatefulSet", "db", "postgres"},
+		{"datadog/agent:7", "app/templates/workloads.yaml", "DaemonSet", "agent", "agent"},
+		{"redis:7.4", "app/templates/workloads.yaml", "ReplicaSet", "cache", "redis"},
+		{"httpd:2.4", "app/templates/workloads.yaml", "ReplicationController", "legacy", "legacy"},
+		{"ghcr.io/org/seed:2.0", "app/templates/jobs.yaml", "Job", "seed", "seed"},
+		{"ghcr.io/org/backup:1.0", "app/templates/jobs.yaml", "CronJob", "backup", "backup"},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, found)
+	}
+}
+
+func TestHelmSubchart(t *testing.T) {
+	tests := map[string]string{
+		"app/templates/deployment.yaml":                         "",
+		"app/charts/redis/templates/statefulset.yaml":           "redis",
+		"app/charts/backend/charts/redis/templates/master.yaml": "backend/redis",
+		"": "",
+	}
+	for source, expected := range tests {
+		if subchart := helmSubchart(source); subchart != expected {
+			t.Errorf("Expected subchart %q for %s but got %q", expected, source, subchart)
+		}
+	}
+}
+
+func TestExtractImagesFromHelmFilesWithOptions_ArchiveValuesFiles(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-archives/releases/shop-1.2.3.tgz", ValuesFile: "shop/values.yaml"},
+	}
+
+	images, err := ExtractImagesFromHelmFilesWithOptions(helmCharts, HelmOptions{ValuesFiles: []string{"shop-overrides.yaml"}}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	expected := []string{"ghcr.io/org/shop:2.0.0", "envoyproxy/envoy:v1.31.0"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected images %v but got %v", expected, names)
+	}
+}
+
+func TestExtractImagesFromHelmFilesWithOptions_ArchiveEnvironments(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-archives/releases/shop-1.2.3.tgz", ValuesFile: "shop/values.yaml"},
+	}
+
+	images, err := ExtractImagesFromHelmFilesWithOptions(helmCharts, HelmOptions{Environments: true}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	expected := []string{
+		"ghcr.io/org/shop:1.2.3", "envoyproxy/envoy:v1.31.0",
+		"ghcr.io/org/shop:1.2.3-staging", "envoyproxy/envoy:v1.31.0",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected images %v but got %v", expected, names)
+	}
+}
+
+func TestExtractImagesWithLineNumbersFromHelmFiles_Archive(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-archives/releases/shop-1.2.3.tgz", ValuesFile: "shop/values.yaml"},
+	}
+
+	images, err := ExtractImagesWithLineNumbersFromHelmFiles(helmCharts)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	expected := []types.ImageModel{
+		{
+			Name: "envoyproxy/envoy:v1.31.0",
+			ImageLocations: []types.ImageLocation{
+				{Origin: types.HelmFileOrigin, Path: "shop/templates/deployment.yaml", Line: 11, StartIndex: 17, EndIndex: 41},
+			},
+		},
+		{
+			Name: "ghcr.io/org/shop:1.2.3-prod",
+			ImageLocations: []types.ImageLocation{
+				{Origin: types.HelmFileOrigin, Path: "shop/values.yaml", Line: 1, StartIndex: 14, EndIndex: 30},
+				{Origin: types.HelmFileOrigin, Path: "shop/values-prod.yaml", Line: 1, StartIndex: 8, EndIndex: 18},
+			},
+		},
+		{
+			Name: "ghcr.io/org/shop:1.2.3",
+			ImageLocations: []types.ImageLocation{
+				{Origin: types.HelmFileOrigin, Path: "shop/values.yaml", Line: 1, StartIndex: 14, EndIndex: 30},
+				{Origin: types.HelmFileOrigin, Path: "shop/values.yaml", Line: 2, StartIndex: 8, EndIndex: 13},
+			},
+		},
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
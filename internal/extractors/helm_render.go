@@ -0,0 +1,206 @@
+package extractors
+
+import (
+	"fmt"
+
+	"github.com/Checkmarx/containers-types/types"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// defaultHelmReleaseName is the release name charts are rendered with when HelmRenderOptions sets none.
+const defaultHelmReleaseName = "temp-release"
+
+// HelmRenderOptions holds the helm template flags that change the cluster and release a chart is
+// rendered for, and so the templates gated on .Capabilities and .Release.
+type HelmRenderOptions struct {
+	// KubeVersion is the .Capabilities.KubeVersion charts are rendered for, such as v1.29.0, like
+	// helm template --kube-version. Empty renders for the version helm defaults to.
+	KubeVersion string
+	// APIVersions are added to .Capabilities.APIVersions, like helm template --api-versions, either
+	// as a group/version such as monitoring.coreos.com/v1, or with its kind, such as
+	// monitoring.coreos.com/v1/ServiceMonitor.
+	APIVersions []string
+	// ReleaseName and Namespace are the .Release.Name and .Release.Namespace of the render.
+	// ReleaseName defaults to temp-release.
+	ReleaseName string
+	Namespace   string
+	// IsUpgrade renders the chart as an upgrade of an installed release, like helm template --is-upgrade.
+	IsUpgrade bool
+}
+
+// DefaultHelmRenderProfiles returns the capability profiles HelmOptions.RenderAllBranches renders
+// charts for when HelmOptions.RenderProfiles is empty: a first install on the default cluster of
+// helm, an upgrade on a recent cluster serving the custom resources charts commonly test for, and a
+// cluster old enough to serve the beta APIs removed since.
+func DefaultHelmRenderProfiles() []HelmRenderOptions {
+	return []HelmRenderOptions{
+		{},
+		{
+			KubeVersion: "v1.31.0",
+			IsUpgrade:   true,
+			APIVersions: []string{
+				"monitoring.coreos.com/v1",
+				"monitoring.coreos.com/v1/ServiceMonitor",
+				"monitoring.coreos.com/v1/PodMonitor",
+				"monitoring.coreos.com/v1/PrometheusRule",
+				"cert-manager.io/v1",
+				"cert-manager.io/v1/Certificate",
+				"networking.istio.io/v1beta1",
+				"security.istio.io/v1beta1",
+				"autoscaling.k8s.io/v1/VerticalPodAutoscaler",
+				"keda.sh/v1alpha1/ScaledObject",
+				"gateway.networking.k8s.io/v1/HTTPRoute",
+				"route.openshift.io/v1/Route",
+				"security.openshift.io/v1/SecurityContextConstraints",
+			},
+		},
+		{
+			KubeVersion: "v1.16.0",
+			APIVersions: []string{
+				"extensions/v1beta1",
+				"networking.k8s.io/v1beta1/Ingress",
+				"policy/v1beta1/PodSecurityPolicy",
+				"policy/v1beta1/PodDisruptionBudget",
+				"batch/v1beta1/CronJob",
+			},
+		},
+	}
+}
+
+// renderProfiles returns the render options of every render of a chart: options.Render alone, or
+// options.Render overridden by each profile when options.RenderAllBranches is set.
+func (options HelmOptions) renderProfiles() []HelmRenderOptions {
+	if !options.RenderAllBranches {
+		return []HelmRenderOptions{options.Render}
+	}
+	profiles := options.RenderProfiles
+	if len(profiles) == 0 {
+		profiles = DefaultHelmRenderProfiles()
+	}
+	renders := make([]HelmRenderOptions, len(profiles))
+	for i, profile := range profiles {
+		renders[i] = options.Render.override(profile)
+	}
+	return renders
+}
+
+// override returns r with the fields set by profile replacing its own. The API versions of both are kept.
+func (r HelmRenderOptions) override(profile HelmRenderOptions) HelmRenderOptions {
+	if profile.KubeVersion != "" {
+		r.KubeVersion = profile.KubeVersion
+	}
+	r.APIVersions = append(append([]string{}, r.APIVersions...), profile.APIVersions...)
+	if profile.ReleaseName != "" {
+		r.ReleaseName = profile.ReleaseName
+	}
+	if profile.Namespace != "" {
+		r.Namespace = profile.Namespace
+	}
+	r.IsUpgrade = r.IsUpgrade || profile.IsUpgrade
+	return r
+}
+
+// renderChart renders the manifests of a loaded chart, with vals overriding the values of the chart,
+// for the cluster and release of render. The chart is rendered from a copy, as helm removes the
+// disabled subcharts of the chart it renders, so that a chart loaded once renders in every environment.
+func renderChart(helmChart *chart.Chart, vals map[string]interface{}, render HelmRenderOptions) (string, error) {
+	actionConfig := new(action.Configuration)
+
+	client := action.NewInstall(actionConfig)
+	client.DryRun = true
+	client.ReleaseName = defaultHelmReleaseName
+	if render.ReleaseName != "" {
+		client.ReleaseName = render.ReleaseName
+	}
+	client.Namespace = render.Namespace
+	client.IsUpgrade = render.IsUpgrade
+	client.ClientOnly = true
+	client.APIVersions = chartutil.VersionSet(render.APIVersions)
+	if render.KubeVersion != "" {
+		kubeVersion, err := chartutil.ParseKubeVersion(render.KubeVersion)
+		if err != nil {
+			return "", fmt.Errorf("invalid kube version %q: %w", render.KubeVersion, err)
+		}
+		client.KubeVersion = kubeVersion
+	}
+
+	release, err := client.Run(cloneHelmChart(helmChart), vals)
+	if err != nil {
+		return "", err
+	}
+
+	return release.Manifest, nil
+}
+
+// cloneHelmChart copies a chart and its subcharts deeply enough for helm to process the dependencies of
+// the copy: their metadata, dependencies and values. Templates and files are shared.
+func cloneHelmChart(helmChart *chart.Chart) *chart.Chart {
+	helmCopy := *helmChart
+	if helmChart.Metadata != nil {
+		metadata := *helmChart.Metadata
+		metadata.Dependencies = nil
+		for _, dependency := range helmChart.Metadata.Dependencies {
+			if dependency == nil {
+				continue
+			}
+			dependencyCopy := *dependency
+			metadata.Dependencies = append(metadata.Dependencies, &dependencyCopy)
+		}
+		helmCopy.Metadata = &metadata
+	}
+	if values, ok := copyHelmValue(helmChart.Values).(map[string]interface{}); ok {
+		helmCopy.Values = values
+	}
+
+	var dependencies []*chart.Chart
+	for _, dependency := range helmChart.Dependencies() {
+		dependencies = append(dependencies, cloneHelmChart(dependency))
+	}
+	helmCopy.SetDependencies(dependencies...)
+	return &helmCopy
+}
+
+// copyHelmValue deeply copies the maps and lists of a values tree.
+func copyHelmValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if value == nil {
+			return value
+		}
+		values := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			values[key] = copyHelmValue(child)
+		}
+		return values
+	case []interface{}:
+		values := make([]interface{}, len(value))
+		for i, child := range value {
+			values[i] = copyHelmValue(child)
+		}
+		return values
+	default:
+		return value
+	}
+}
+
+// unionImageDetails drops the images already found by an earlier render of the same environment,
+// keeping the first occurrence of each. Images are identified by their name, environment and location.
+func unionImageDetails(images []ImageDetails) []ImageDetails {
+	type imageKey struct {
+		name, environment string
+		location          types.ImageLocation
+	}
+	seen := make(map[imageKey]bool, len(images))
+	var union []ImageDetails
+	for _, image := range images {
+		key := imageKey{name: image.Name, environment: image.Environment, location: image.ImageLocations[0]}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		union = append(union, image)
+	}
+	return union
+}
@@ -0,0 +1,148 @@
+package extractors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
+)
+
+func TestExtractImagesFromHelmFilesWithOptions_Render(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-capabilities", ValuesFile: "values.yaml"},
+	}
+
+	tests := []struct {
+		name     string
+		options  HelmOptions
+		expected []string
+	}{
+		{"DefaultCapabilities", HelmOptions{}, []string{"nginx:1.25.3", "busybox:1.28"}},
+		{"KubeVersion", HelmOptions{Render: HelmRenderOptions{KubeVersion: "v1.30.0"}}, []string{"nginx:1.25.3", "busybox:1.36"}},
+		{"APIVersions", HelmOptions{Render: HelmRenderOptions{APIVersions: []string{"monitoring.coreos.com/v1/ServiceMonitor"}}},
+			[]string{"nginx:1.25.3", "nginx/nginx-prometheus-exporter:1.1.0", "busybox:1.28"}},
+		{"IsUpgrade", HelmOptions{Render: HelmRenderOptions{IsUpgrade: true}},
+			[]string{"nginx:1.25.3", "ghcr.io/org/migrate:1.0.0", "busybox:1.28"}},
+		{"InvalidKubeVersion", HelmOptions{Render: HelmRenderOptions{KubeVersion: "latest"}}, nil},
+		{"RenderAllBranches", HelmOptions{RenderAllBranches: true}, []string{
+			"nginx:1.25.3", "busybox:1.28",
+			"nginx/nginx-prometheus-exporter:1.1.0", "ghcr.io/org/migrate:1.0.0", "busybox:1.36",
+		}},
+		{"RenderProfiles", HelmOptions{
+			Render:            HelmRenderOptions{APIVersions: []string{"monitoring.coreos.com/v1/ServiceMonitor"}},
+			RenderAllBranches: true,
+			RenderProfiles:    []HelmRenderOptions{{KubeVersion: "v1.20.0"}, {KubeVersion: "v1.30.0"}},
+		}, []string{
+			"nginx:1.25.3", "nginx/nginx-prometheus-exporter:1.1.0", "busybox:1.28",
+			"busybox:1.36",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			images, err := ExtractImagesFromHelmFilesWithOptions(helmCharts, test.options, nil)
+			if err != nil {
+				t.Fatalf("Error extracting images: %v", err)
+			}
+			var names []string
+			for _, image := range images {
+				names = append(names, image.Name)
+			}
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("Expected images %v but got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestExtractImagesFromHelmFilesWithOptions_RenderAllBranchesSubcharts(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-subcharts/umbrella", ValuesFile: "umbrella/values.yaml"},
+	}
+
+	images, err := ExtractImagesFromHelmFilesWithOptions(helmCharts, HelmOptions{Environments: true, RenderAllBranches: true}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	expected := []string{
+		"ghcr.io/org/web:2.4.0", "redis:7.2",
+		"quay.io/prometheus/node-exporter:v1.8.0", "ghcr.io/org/web:2.4.0", "redis:7.2",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected images %v but got %v", expected, names)
+	}
+}
+
+func TestUnionImageDetails(t *testing.T) {
+	image := func(name, environment, path string, line int, workload string) ImageDetails {
+		return ImageDetails{
+			ImageModel:        types.ImageModel{Name: name, ImageLocations: []types.ImageLocation{{Origin: types.HelmFileOrigin, Path: path, Line: line}}},
+			HelmDetails:       HelmDetails{Environment: environment},
+			KubernetesDetails: KubernetesDetails{Workload: workload},
+		}
+	}
+	images := []ImageDetails{
+		image("nginx:1.25", "", "web/templates/deployment.yaml", 3, "temp-release-web"),
+		image("nginx:1.25", "", "web/templates/deployment.yaml", 3, "web"),
+		image("nginx:1.25", "", "web/templates/deployment.yaml", 8, "temp-release-web"),
+		image("nginx:1.25", "prod", "web/templates/deployment.yaml", 3, "temp-release-web"),
+	}
+	expected := []ImageDetails{images[0], images[2], images[3]}
+	if union := unionImageDetails(images); !reflect.DeepEqual(union, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, union)
+	}
+}
+
+func TestExtractImageDetailsFromHelmFiles_Render(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-capabilities", ValuesFile: "values.yaml"},
+	}
+	options := HelmOptions{
+		Render:            HelmRenderOptions{ReleaseName: "shop", Namespace: "production"},
+		RenderAllBranches: true,
+	}
+
+	images, err := ExtractImageDetailsFromHelmFiles(helmCharts, options, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	var workloads []string
+	for _, image := range images {
+		workloads = append(workloads, image.Workload+" "+image.Name+" "+image.ValuesKey)
+	}
+	expected := []string{
+		"shop-web nginx:1.25.3 image",
+		"shop-cleanup busybox:1.28 cleanup.legacyImage",
+		"shop-web nginx/nginx-prometheus-exporter:1.1.0 exporter.image",
+		"shop-migrations ghcr.io/org/migrate:1.0.0 migrations.image",
+		"shop-cleanup busybox:1.36 cleanup.image",
+	}
+	if !reflect.DeepEqual(workloads, expected) {
+		t.Errorf("Expected images %v but got %v", expected, workloads)
+	}
+}
+
+func TestHelmRenderProfiles(t *testing.T) {
+	options := HelmOptions{
+		Render:            HelmRenderOptions{APIVersions: []string{"example.com/v1"}, Namespace: "apps"},
+		RenderAllBranches: true,
+		RenderProfiles:    []HelmRenderOptions{{}, {KubeVersion: "v1.20.0", APIVersions: []string{"batch/v1beta1"}, IsUpgrade: true}},
+	}
+
+	expected := []HelmRenderOptions{
+		{APIVersions: []string{"example.com/v1"}, Namespace: "apps"},
+		{KubeVersion: "v1.20.0", APIVersions: []string{"example.com/v1", "batch/v1beta1"}, Namespace: "apps", IsUpgrade: true},
+	}
+	if profiles := options.renderProfiles(); !reflect.DeepEqual(profiles, expected) {
+		t.Errorf("Expected render profiles %+v but got %+v", expected, profiles)
+	}
+
+	options.RenderAllBranches = false
+	if profiles := options.renderProfiles(); !reflect.DeepEqual(profiles, []HelmRenderOptions{options.Render}) {
+		t.Errorf("Expected the render options alone but got %+v", profiles)
+	}
+}
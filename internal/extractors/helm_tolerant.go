@@ -0,0 +1,171 @@
+package extractors
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// helmRequiredPlaceholder is the value tolerant rendering gives the required values that are not set.
+const helmRequiredPlaceholder = "required-value-placeholder"
+
+// helmActionPattern matches the actions of a template, such as {{ .Values.image.tag }}.
+var helmActionPattern = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+
+// helmRequiredPattern matches a call to required with its message, a string literal or an expression
+// in parentheses, such as required "image.tag is required".
+var helmRequiredPattern = regexp.MustCompile("(^|[^\\w.$])required\\s+(\"(?:[^\"\\\\]|\\\\.)*\"|`[^`]*`|\\([^()]*\\))")
+
+// helmTemplateLinePattern matches the line that follows the name of a template in a render error, such
+// as :12 in template: shop/templates/deployment.yaml:12:20: executing.
+var helmTemplateLinePattern = regexp.MustCompile(`^:(\d+)`)
+
+// renderHelmChart renders a chart with the values and render options of an environment. In tolerant
+// mode, required values that are not set get a placeholder, and the templates that still fail are
+// left out of the manifests and returned as diagnostics instead of failing the whole chart.
+func renderHelmChart(helmChart *chart.Chart, vals map[string]interface{}, environment helmEnvironment) (string, []Diagnostic, error) {
+	if !environment.tolerant {
+		manifest, err := renderChart(helmChart, vals, environment.render)
+		return manifest, nil, err
+	}
+
+	keepAll := func(string) bool { return true }
+	manifest, err := renderChart(copyHelmChart(helmChart, keepAll), vals, environment.render)
+	if err == nil {
+		return manifest, nil, nil
+	}
+
+	// Render the templates one at a time, along with the helpers they may include, to find the ones failing
+	var failures []Diagnostic
+	failed := make(map[string]bool)
+	for _, source := range helmChartTemplates(helmChart) {
+		only := func(template string) bool { return template == source }
+		if _, err := renderChart(copyHelmChart(helmChart, only), vals, environment.render); err != nil {
+			failed[source] = true
+			failures = append(failures, helmTemplateDiagnostic(source, err))
+		}
+	}
+
+	working := func(template string) bool { return !failed[template] }
+	manifest, err = renderChart(copyHelmChart(helmChart, working), vals, environment.render)
+	if err != nil {
+		return "", failures, err
+	}
+	return manifest, failures, nil
+}
+
+// copyHelmChart copies a chart and its subcharts with the templates for which keep returns true, given
+// their path from the root chart, such as web/charts/redis/templates/deployment.yaml. Helpers, whose
+// name starts with an underscore, are always kept. The required calls of the templates are replaced
+// with defaults to helmRequiredPlaceholder.
+func copyHelmChart(helmChart *chart.Chart, keep func(template string) bool) *chart.Chart {
+	return copyHelmSubchart(helmChart, nil, keep)
+}
+
+func copyHelmSubchart(helmChart, parent *chart.Chart, keep func(template string) bool) *chart.Chart {
+	helmCopy := *helmChart
+	if parent != nil {
+		parent.AddDependency(&helmCopy)
+	}
+
+	helmCopy.Templates = nil
+	for _, template := range helmChart.Templates {
+		if !isHelmHelper(template.Name) && !keep(path.Join(helmChart.ChartFullPath(), template.Name)) {
+			continue
+		}
+		helmCopy.Templates = append(helmCopy.Templates, &chart.File{
+			Name: template.Name,
+			Data: withRequiredPlaceholders(template.Data),
+		})
+	}
+
+	helmCopy.SetDependencies()
+	for _, dependency := range helmChart.Dependencies() {
+		copyHelmSubchart(dependency, &helmCopy, keep)
+	}
+	return &helmCopy
+}
+
+// helmChartTemplates returns the paths from the root chart of the templates of a chart and its
+// subcharts, leaving out helpers.
+func helmChartTemplates(helmChart *chart.Chart) []string {
+	var templates []string
+	for _, template := range helmChart.Templates {
+		if !isHelmHelper(template.Name) {
+			templates = append(templates, path.Join(helmChart.ChartFullPath(), template.Name))
+		}
+	}
+	for _, dependency := range helmChart.Dependencies() {
+		templates = append(templates, helmChartTemplates(dependency)...)
+	}
+	return templates
+}
+
+// isHelmHelper reports whether a template only defines named templates, like _helpers.tpl.
+func isHelmHelper(name string) bool {
+	return strings.HasPrefix(path.Base(name), "_")
+}
+
+// withRequiredPlaceholders replaces the calls to required in the actions of a template with defaults to
+// helmRequiredPlaceholder, so that required "image.tag is required" .Values.image.tag renders the
+// placeholder when image.tag is not set.
+func withRequiredPlaceholders(template []byte) []byte {
+	return helmActionPattern.ReplaceAllFunc(template, func(action []byte) []byte {
+		return helmRequiredPattern.ReplaceAll(action, []byte(`${1}default "`+helmRequiredPlaceholder+`"`))
+	})
+}
+
+// helmTemplateDiagnostic describes why a template failed to render, at the line of the template the
+// error points at when it names one.
+func helmTemplateDiagnostic(source string, err error) Diagnostic {
+	line := -1
+	message := err.Error()
+	for rest := message; ; {
+		index := strings.Index(rest, source)
+		if index == -1 {
+			break
+		}
+		rest = rest[index+len(source):]
+		if match := helmTemplateLinePattern.FindStringSubmatch(rest); match != nil {
+			if n, convErr := strconv.Atoi(match[1]); convErr == nil {
+				line = n - 1
+			}
+			break
+		}
+	}
+	return Diagnostic{Severity: DiagnosticError, Path: source, Line: line, Message: message}
+}
+
+// helmPlaceholderDiagnostics warns about the rendered images built from a required value that was not
+// set, and so hold helmRequiredPlaceholder.
+func helmPlaceholderDiagnostics(images []ImageDetails) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, image := range images {
+		if !strings.Contains(image.Name, helmRequiredPlaceholder) {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: DiagnosticWarning,
+			Path:     image.ImageLocations[0].Path,
+			Line:     -1,
+			Message:  "image " + image.Name + " is built from a required value that is not set",
+		})
+	}
+	return diagnostics
+}
+
+// reportHelmDiagnostics reports the diagnostics of a chart, once each, as several renders of a chart
+// usually fail the same way.
+func reportHelmDiagnostics(report DiagnosticReporter, diagnostics []Diagnostic) {
+	seen := make(map[Diagnostic]bool, len(diagnostics))
+	for _, diagnostic := range diagnostics {
+		if seen[diagnostic] {
+			continue
+		}
+		seen[diagnostic] = true
+		report.report(diagnostic)
+	}
+}
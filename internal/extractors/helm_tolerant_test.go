@@ -0,0 +1,122 @@
+package extractors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
+)
+
+func TestExtractImagesFromHelmFilesWithOptions_Tolerant(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-tolerant", ValuesFile: "values.yaml"},
+	}
+
+	tests := []struct {
+		name        string
+		options     HelmOptions
+		expected    []string
+		diagnostics []Diagnostic
+	}{
+		{"Strict", HelmOptions{}, nil, nil},
+		{"Tolerant", HelmOptions{Tolerant: true},
+			[]string{"ghcr.io/org/api:" + helmRequiredPlaceholder, "nginx:1.25.3", "busybox:1.36"},
+			[]Diagnostic{
+				{Severity: DiagnosticError, Path: "tolerant/templates/migrations.yaml", Line: 1,
+					Message: "execution error at (tolerant/templates/migrations.yaml:2:4): database.password must be set to run the migrations"},
+				{Severity: DiagnosticError, Path: "tolerant/templates/worker.yaml", Line: 6,
+					Message: `template: tolerant/templates/worker.yaml:7:30: executing "tolerant/templates/worker.yaml" at <$secret.data.token>: nil pointer evaluating interface {}.token`},
+				{Severity: DiagnosticWarning, Path: "tolerant/templates/deployment.yaml", Line: -1,
+					Message: "image ghcr.io/org/api:" + helmRequiredPlaceholder + " is built from a required value that is not set"},
+			}},
+		{"TolerantWithValues", HelmOptions{Tolerant: true, Set: []string{"image.tag=1.4.0,database.password=secret"}},
+			[]string{"ghcr.io/org/api:1.4.0", "nginx:1.25.3", "postgres:16", "busybox:1.36"},
+			[]Diagnostic{
+				{Severity: DiagnosticError, Path: "tolerant/templates/worker.yaml", Line: 6,
+					Message: `template: tolerant/templates/worker.yaml:7:30: executing "tolerant/templates/worker.yaml" at <$secret.data.token>: nil pointer evaluating interface {}.token`},
+			}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var diagnostics []Diagnostic
+			images, err := ExtractImagesFromHelmFilesWithOptions(helmCharts, test.options, func(diagnostic Diagnostic) {
+				diagnostics = append(diagnostics, diagnostic)
+			})
+			if err != nil {
+				t.Fatalf("Error extracting images: %v", err)
+			}
+			var names []string
+			for _, image := range images {
+				names = append(names, image.Name)
+			}
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("Expected images %v but got %v", test.expected, names)
+			}
+			if !reflect.DeepEqual(diagnostics, test.diagnostics) {
+				t.Errorf("Expected diagnostics %+v but got %+v", test.diagnostics, diagnostics)
+			}
+		})
+	}
+}
+
+func TestExtractImageDetailsFromHelmFiles_Tolerant(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-tolerant", ValuesFile: "values.yaml"},
+	}
+
+	var diagnostics []Diagnostic
+	images, err := ExtractImageDetailsFromHelmFiles(helmCharts, HelmOptions{Tolerant: true, Environments: true}, func(diagnostic Diagnostic) {
+		diagnostics = append(diagnostics, diagnostic)
+	})
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	var keys []string
+	for _, image := range images {
+		keys = append(keys, image.Name+" "+image.ValuesKey)
+	}
+	expected := []string{
+		"ghcr.io/org/api:" + helmRequiredPlaceholder + " image.repository",
+		"nginx:1.25.3 proxy.image",
+		"busybox:1.36 cleanup.image",
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected images %v but got %v", expected, keys)
+	}
+
+	var paths []string
+	for _, diagnostic := range diagnostics {
+		paths = append(paths, diagnostic.Severity+" "+diagnostic.Path)
+	}
+	expectedPaths := []string{
+		"error tolerant/templates/migrations.yaml",
+		"error tolerant/templates/worker.yaml",
+		"warning tolerant/templates/deployment.yaml",
+	}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("Expected diagnostics for %v but got %v", expectedPaths, paths)
+	}
+}
+
+func TestWithRequiredPlaceholders(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`image: {{ required "image.tag is required" .Values.image.tag }}`,
+			`image: {{ default "` + helmRequiredPlaceholder + `" .Values.image.tag }}`},
+		{`image: {{ .Values.image | required "image is required" | quote }}`,
+			`image: {{ .Values.image | default "` + helmRequiredPlaceholder + `" | quote }}`},
+		{"{{- required `a \"quoted\" message` .Values.a -}}",
+			`{{- default "` + helmRequiredPlaceholder + `" .Values.a -}}`},
+		{`{{ required (printf "%s is required" "name") .Values.name }}`,
+			`{{ default "` + helmRequiredPlaceholder + `" .Values.name }}`},
+		{`{{ .Values.required "x" }} required "not an action"`, `{{ .Values.required "x" }} required "not an action"`},
+	}
+	for _, test := range tests {
+		if result := string(withRequiredPlaceholders([]byte(test.template))); result != test.expected {
+			t.Errorf("Expected %s to be rewritten to %s but got %s", test.template, test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package extractors
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// HelmOptions holds the helm install flags that change the values charts are rendered with, and the
+// cluster and release they are rendered for.
+type HelmOptions struct {
+	// ValuesFiles are applied in order over the values.yaml of every chart, like helm install -f.
+	// Relative paths are resolved against the chart directory, or the directory of a packaged chart's
+	// archive, and charts missing one are rendered without it.
+	ValuesFiles []string
+	// Set are overrides applied after ValuesFiles, in the syntax of helm install --set, such as
+	// image.tag=1.2.3 or sidecars[0].image=envoy.
+	Set []string
+	// Environments renders every chart once more for each of its values-*.yaml files, applied before
+	// ValuesFiles, and reports the images of each render with the name of its environment. The
+	// values-*.yaml files of a packaged chart are the ones in the directory of its archive.
+	Environments bool
+	// Render sets the cluster capabilities and release every chart is rendered for.
+	Render HelmRenderOptions
+	// RenderAllBranches renders every chart once more for each of RenderProfiles, applied over Render,
+	// and reports the union of the images found, so that images behind .Capabilities and .Release
+	// conditions are not missed. DefaultHelmRenderProfiles is used when RenderProfiles is empty.
+	RenderAllBranches bool
+	RenderProfiles    []HelmRenderOptions
+	// Tolerant renders charts that fail to render as far as they can: required values that are not
+	// set get a placeholder, and the templates that still fail, such as those calling fail or looking
+	// up resources of the cluster, are left out and reported as diagnostics.
+	Tolerant bool
+}
+
+// helmEnvironment is a set of values a chart is rendered with, on top of its values.yaml.
+type helmEnvironment struct {
+	// name is the environment of a values-<name>.yaml file, empty for the values given by HelmOptions alone.
+	name        string
+	valuesFiles []types.FilePath
+	// parsed are values parsed beforehand, such as the values of a Helmfile release, applied after valuesFiles.
+	parsed []helmValuesLayer
+	set    []string
+	// render is the cluster and release the chart is rendered for.
+	render HelmRenderOptions
+	// tolerant leaves out the templates failing to render instead of failing the chart.
+	tolerant bool
+}
+
+// helmValuesLayer is a values file parsed as a YAML tree, so that its scalars can be located.
+type helmValuesLayer struct {
+	file types.FilePath
+	root *yaml.Node
+}
+
+// helmEnvironments returns the environments a chart is rendered in: its default one, then one per
+// values-*.yaml file when options.Environments is set, each rendered once per render profile.
+func helmEnvironments(h types.HelmChartInfo, options HelmOptions) []helmEnvironment {
+	var environments []helmEnvironment
+	for _, environment := range helmValuesEnvironments(h, options) {
+		for _, render := range options.renderProfiles() {
+			environment.render = render
+			environment.tolerant = options.Tolerant
+			environments = append(environments, environment)
+		}
+	}
+	return environments
+}
+
+// helmValuesEnvironments returns the values of the environments a chart is rendered in.
+func helmValuesEnvironments(h types.HelmChartInfo, options HelmOptions) []helmEnvironment {
+	valuesFile := helmValuesFile(h)
+
+	// Values files of a packaged chart, including the values-*.yaml files of its environments, are
+	// next to its archive rather than inside it
+	callerFile := valuesFile
+	if isHelmChartArchive(h.Directory) {
+		callerFile = types.FilePath{FullPath: h.Directory, RelativePath: filepath.Base(h.Directory)}
+	}
+	var valuesFiles []types.FilePath
+	for _, file := range options.ValuesFiles {
+		filePath := relatedFile(callerFile, file)
+		if _, err := os.Stat(filePath.FullPath); err != nil {
+			log.Warn().Msgf("values file %s not found in helm directory %s", file, h.Directory)
+			continue
+		}
+		valuesFiles = append(valuesFiles, filePath)
+	}
+
+	environments := []helmEnvironment{{valuesFiles: valuesFiles, set: options.Set}}
+	if !options.Environments {
+		return environments
+	}
+
+	files, err := findValuesFiles(filepath.Dir(callerFile.FullPath))
+	if err != nil {
+		log.Err(err).Msgf("Could not find values files in chart directory: %s", h.Directory)
+		return environments
+	}
+	for _, file := range files {
+		name := strings.TrimPrefix(strings.TrimSuffix(file.RelativePath, filepath.Ext(file.RelativePath)), "values")
+		if !strings.HasPrefix(name, "-") {
+			continue
+		}
+		environments = append(environments, helmEnvironment{
+			name:        strings.TrimPrefix(name, "-"),
+			valuesFiles: append([]types.FilePath{relatedFile(callerFile, file.RelativePath)}, valuesFiles...),
+			set:         options.Set,
+		})
+	}
+	return environments
+}
+
+// layers parses the values files of the environment.
+func (e helmEnvironment) layers() ([]helmValuesLayer, error) {
+	var layers []helmValuesLayer
+	for _, file := range e.valuesFiles {
+		root, err := readHelmValues(file.FullPath)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, helmValuesLayer{file: file, root: root})
+	}
+	return append(layers, e.parsed...), nil
+}
+
+// values merges layers in order, then applies the --set overrides of the environment. It returns nil
+// when there is nothing to override the values.yaml of the chart with.
+func (e helmEnvironment) values(layers []helmValuesLayer) (map[string]interface{}, error) {
+	if len(layers) == 0 && len(e.set) == 0 {
+		return nil, nil
+	}
+
+	vals := make(map[string]interface{})
+	for _, layer := range layers {
+		if layer.root.Kind == 0 {
+			continue
+		}
+		var layerVals map[string]interface{}
+		if err := layer.root.Decode(&layerVals); err != nil {
+			return nil, err
+		}
+		vals = mergeHelmValues(vals, layerVals)
+	}
+	for _, set := range e.set {
+		if err := strvals.ParseInto(set, vals); err != nil {
+			return nil, err
+		}
+	}
+	return vals, nil
+}
+
+// mergeHelmValues merges src into dst the way helm merges values files: nested maps are merged, and
+// any other value of src replaces the one of dst.
+func mergeHelmValues(dst, src map[string]interface{}) map[string]interface{} {
+	for key, value := range src {
+		if srcMap, ok := value.(map[string]interface{}); ok {
+			if dstMap, ok := dst[key].(map[string]interface{}); ok {
+				dst[key] = mergeHelmValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+	return dst
+}
@@ -0,0 +1,222 @@
+package extractors
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// helmChartFile is a file of a chart, with the path its image locations are reported at.
+type helmChartFile struct {
+	relativePath string
+	content      []byte
+}
+
+// helmValuesNode is a scalar of a chart file that an image reference is built from.
+type helmValuesNode struct {
+	relativePath string
+	node         *yaml.Node
+}
+
+// extractImagesFromValuesMaps returns the images described by the image maps of the values files of a
+// chart, such as image: {registry: docker.io, repository: bitnami/nginx, tag: 1.25.3}, located at
+// every key the reference is built from.
+//
+// As in Bitnami charts, global.imageRegistry takes precedence over the registry of a map, and a map
+// pinned by digest ignores its tag. A map without a tag or digest falls back to the appVersion of the
+// chart, like the usual {{ .Values.image.tag | default .Chart.AppVersion }}. The image maps of other
+// values files are merged over the map values.yaml sets at the same key, as helm merges them, so that
+// image: {tag: 1.27.0} overrides the tag only, and values files without a global.imageRegistry fall
+// back to the one of values.yaml.
+func extractImagesFromValuesMaps(chartFile helmChartFile, valuesFiles []helmChartFile) []types.ImageModel {
+	var appVersion *helmValuesNode
+	if chartRoot := parseHelmChartFile(chartFile); chartRoot != nil {
+		if node := scalarNode(mappingValue(chartRoot, "appVersion")); node != nil && node.Value != "" {
+			appVersion = &helmValuesNode{relativePath: chartFile.relativePath, node: node}
+		}
+	}
+
+	roots := make([]*yaml.Node, len(valuesFiles))
+	var defaultRegistry *helmValuesNode
+	defaultMaps := make(map[string]helmValuesNode)
+	for i, valuesFile := range valuesFiles {
+		roots[i] = parseHelmChartFile(valuesFile)
+		if path.Base(valuesFile.relativePath) == "values.yaml" {
+			defaultRegistry = globalImageRegistry(valuesFile.relativePath, roots[i])
+			for _, imageMap := range findImageMaps(roots[i]) {
+				defaultMaps[imageMap.key] = helmValuesNode{relativePath: valuesFile.relativePath, node: imageMap.node}
+			}
+		}
+	}
+
+	var images []types.ImageModel
+	for i, valuesFile := range valuesFiles {
+		if roots[i] == nil {
+			continue
+		}
+		registry := globalImageRegistry(valuesFile.relativePath, roots[i])
+		if registry == nil {
+			registry = defaultRegistry
+		}
+		for _, imageMap := range findImageMaps(roots[i]) {
+			imageMaps := []helmValuesNode{{relativePath: valuesFile.relativePath, node: imageMap.node}}
+			if defaultMap, ok := defaultMaps[imageMap.key]; ok && defaultMap.relativePath != valuesFile.relativePath {
+				imageMaps = append([]helmValuesNode{defaultMap}, imageMaps...)
+			}
+			name, isSha, nodes, ok := assembleValuesImage(imageMaps, registry, appVersion)
+			if !ok {
+				continue
+			}
+			image := types.ImageModel{Name: name, IsSha: isSha}
+			for _, node := range nodes {
+				line, start, end := scalarSpan(node.node)
+				image.ImageLocations = append(image.ImageLocations, types.ImageLocation{
+					Origin:     types.HelmFileOrigin,
+					Path:       node.relativePath,
+					Line:       line,
+					StartIndex: start,
+					EndIndex:   end,
+				})
+			}
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// assembleValuesImage builds the reference of an image map, given as the maps set at its key by the
+// values files, in the order they are merged, returning the scalars it is built from. It returns
+// false when no map sets a repository.
+func assembleValuesImage(imageMaps []helmValuesNode, globalRegistry, appVersion *helmValuesNode) (string, bool, []helmValuesNode, bool) {
+	var nodes []helmValuesNode
+	field := func(key string) string {
+		for i := len(imageMaps) - 1; i >= 0; i-- {
+			node := scalarNode(mappingValue(imageMaps[i].node, key))
+			if node == nil {
+				continue
+			}
+			if node.Value == "" {
+				return ""
+			}
+			nodes = append(nodes, helmValuesNode{relativePath: imageMaps[i].relativePath, node: node})
+			return node.Value
+		}
+		return ""
+	}
+
+	var name string
+	if globalRegistry != nil {
+		nodes = append(nodes, *globalRegistry)
+		name = globalRegistry.node.Value + "/"
+	} else if registry := field("registry"); registry != "" {
+		name = registry + "/"
+	}
+	repository := field("repository")
+	if repository == "" {
+		return "", false, nil, false
+	}
+	name += repository
+
+	if digest := field("digest"); digest != "" {
+		return name + "@" + digest, true, nodes, true
+	}
+	if tag := field("tag"); tag != "" {
+		return name + ":" + tag, false, nodes, true
+	}
+	if appVersion != nil {
+		nodes = append(nodes, *appVersion)
+		return name + ":" + appVersion.node.Value, false, nodes, true
+	}
+	return name + ":latest", false, nodes, true
+}
+
+// helmImageMap is a map of a values tree set under an image key, with the path of that key, such as
+// metrics.image or sidecars[0].image.
+type helmImageMap struct {
+	key  string
+	node *yaml.Node
+}
+
+// helmImageMapFields are the keys of an image map an image reference is built from.
+var helmImageMapFields = []string{"registry", "repository", "tag", "digest"}
+
+// findImageMaps returns the maps of a values tree set under an image key, such as image or
+// initImage, that set a field of an image reference. Maps of values files overriding values.yaml,
+// such as image: {tag: 1.27.0}, may set some fields only.
+func findImageMaps(node *yaml.Node) []helmImageMap {
+	var imageMaps []helmImageMap
+	var walk func(node *yaml.Node, key string)
+	walk = func(node *yaml.Node, key string) {
+		node = resolveAlias(node)
+		if node == nil {
+			return
+		}
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, key)
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				walk(child, fmt.Sprintf("%s[%d]", key, i))
+			}
+		case yaml.MappingNode:
+			for _, entry := range mappingEntries(node) {
+				childKey := entry.key.Value
+				if key != "" {
+					childKey = key + "." + childKey
+				}
+				value := resolveAlias(entry.value)
+				if strings.HasSuffix(strings.ToLower(entry.key.Value), "image") && isImageMap(value) {
+					imageMaps = append(imageMaps, helmImageMap{key: childKey, node: value})
+					continue
+				}
+				walk(value, childKey)
+			}
+		}
+	}
+	walk(node, "")
+	return imageMaps
+}
+
+func isImageMap(node *yaml.Node) bool {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return false
+	}
+	for _, field := range helmImageMapFields {
+		if mappingValue(node, field) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// globalImageRegistry returns the global.imageRegistry of a values file, or nil when it has none.
+func globalImageRegistry(relativePath string, root *yaml.Node) *helmValuesNode {
+	node := scalarNode(mappingValue(mappingValue(root, "global"), "imageRegistry"))
+	if node == nil || node.Value == "" {
+		return nil
+	}
+	return &helmValuesNode{relativePath: relativePath, node: node}
+}
+
+// parseHelmChartFile parses a chart file, returning the mapping at its root or nil when it has none.
+func parseHelmChartFile(file helmChartFile) *yaml.Node {
+	var document yaml.Node
+	if err := yaml.Unmarshal(file.content, &document); err != nil {
+		log.Debug().Msgf("Could not parse helm file %s err: %+v", file.relativePath, err)
+		return nil
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+	root := resolveAlias(document.Content[0])
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	return root
+}
@@ -0,0 +1,95 @@
+package extractors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
+)
+
+func TestExtractImagesWithLineNumbersFromHelmFiles_ImageMaps(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{{Directory: "../../test_files/helm-image-maps"}}
+
+	images, err := ExtractImagesWithLineNumbersFromHelmFiles(helmCharts)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	location := func(path string, line, start, end int) types.ImageLocation {
+		return types.ImageLocation{Origin: types.HelmFileOrigin, Path: path, Line: line, StartIndex: start, EndIndex: end}
+	}
+	expected := []types.ImageModel{
+		{Name: "docker.io/bitnami/nginx:1.25.3-debian-12-r0", ImageLocations: []types.ImageLocation{
+			location("values.yaml", 3, 12, 21), location("values.yaml", 4, 14, 27), location("values.yaml", 5, 7, 26),
+		}},
+		{Name: "docker.io/bitnami/nginx-exporter@sha256:4a3a1e1b6f0c0a5c3f1f7f0e6b4c2d8e9a7b5c3d1e0f2a4b6c8d0e2f4a6b8c0d", IsSha: true, ImageLocations: []types.ImageLocation{
+			location("values.yaml", 11, 14, 23), location("values.yaml", 12, 16, 38), location("values.yaml", 14, 12, 83),
+		}},
+		{Name: "busybox:1.36", ImageLocations: []types.ImageLocation{
+			location("values.yaml", 17, 16, 23), location("values.yaml", 18, 10, 14),
+		}},
+		{Name: "ghcr.io/org/app:3.1.0", ImageLocations: []types.ImageLocation{
+			location("values.yaml", 21, 16, 31), location("Chart.yaml", 5, 13, 18),
+		}},
+		{Name: "envoyproxy/envoy:v1.31.0", ImageLocations: []types.ImageLocation{
+			location("values.yaml", 25, 18, 34), location("values.yaml", 26, 11, 18),
+		}},
+		{Name: "registry.internal.example/bitnami/nginx:1.25.3-debian-12-r0", ImageLocations: []types.ImageLocation{
+			location("values-airgap.yaml", 1, 17, 42), location("values-airgap.yaml", 4, 14, 27), location("values-airgap.yaml", 5, 7, 26),
+		}},
+		{Name: "docker.io/bitnami/nginx:1.27.0", ImageLocations: []types.ImageLocation{
+			location("values.yaml", 3, 12, 21), location("values.yaml", 4, 14, 27), location("values-prod.yaml", 1, 7, 13),
+		}},
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
+
+func TestExtractImagesFromValuesMaps_DefaultGlobalRegistry(t *testing.T) {
+	valuesFiles := []helmChartFile{
+		{relativePath: "values.yaml", content: []byte("global:\n  imageRegistry: mirror.io\n")},
+		{relativePath: "values-dev.yaml", content: []byte("image:\n  repository: org/app\n")},
+	}
+
+	images := extractImagesFromValuesMaps(helmChartFile{relativePath: "Chart.yaml"}, valuesFiles)
+
+	expected := []types.ImageModel{{Name: "mirror.io/org/app:latest", ImageLocations: []types.ImageLocation{
+		{Origin: types.HelmFileOrigin, Path: "values.yaml", Line: 1, StartIndex: 17, EndIndex: 26},
+		{Origin: types.HelmFileOrigin, Path: "values-dev.yaml", Line: 1, StartIndex: 14, EndIndex: 21},
+	}}}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
+
+func TestExtractImagesFromValuesMaps_PartialOverrides(t *testing.T) {
+	valuesFiles := []helmChartFile{
+		{relativePath: "values.yaml", content: []byte("image:\n  registry: docker.io\n  repository: bitnami/nginx\n  tag: 1.25.3\n  digest: sha256:0123\nsidecars:\n  - image:\n      repository: envoyproxy/envoy\n      tag: v1.30.0\n")},
+		{relativePath: "values-prod.yaml", content: []byte("image:\n  tag: 1.27.0\n  digest: \"\"\nsidecars:\n  - image:\n      tag: v1.31.0\nworker:\n  image:\n    tag: 2.0.0\n")},
+	}
+
+	images := extractImagesFromValuesMaps(helmChartFile{relativePath: "Chart.yaml"}, valuesFiles)
+
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	expected := []string{
+		"docker.io/bitnami/nginx@sha256:0123",
+		"envoyproxy/envoy:v1.30.0",
+		"docker.io/bitnami/nginx:1.27.0",
+		"envoyproxy/envoy:v1.31.0",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected images %v but got %v", expected, names)
+	}
+	expectedLocations := []types.ImageLocation{
+		{Origin: types.HelmFileOrigin, Path: "values.yaml", Line: 1, StartIndex: 12, EndIndex: 21},
+		{Origin: types.HelmFileOrigin, Path: "values.yaml", Line: 2, StartIndex: 14, EndIndex: 27},
+		{Origin: types.HelmFileOrigin, Path: "values-prod.yaml", Line: 1, StartIndex: 7, EndIndex: 13},
+	}
+	if !reflect.DeepEqual(images[2].ImageLocations, expectedLocations) {
+		t.Errorf("Expected locations %+v but got %+v", expectedLocations, images[2].ImageLocations)
+	}
+}
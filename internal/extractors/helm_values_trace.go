@@ -0,0 +1,378 @@
+package extractors
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// helmTraceMarker delimits the number appended to a values leaf to find the rendered images that leaf
+// ends up in.
+const helmTraceMarker = "cxvaluestrace"
+
+// helmValueLeaf is a scalar of a values file, with the key it is set under, such as image.tag or sidecars[0].image.
+type helmValueLeaf struct {
+	key  string
+	node *yaml.Node
+}
+
+// tracedHelmValueLeaf is a values leaf an image was traced to, with the values file holding it.
+type tracedHelmValueLeaf struct {
+	helmValueLeaf
+	file types.FilePath
+}
+
+// ExtractImagesWithValuesLocationsFromHelmFiles renders charts and locates every rendered image at
+// the values file keys it is built from, such as its repository, registry, tag or digest. Images
+// that no values key contributes to are located at the template rendering them. Charts are rendered
+// with the values files and overrides of options, once per environment and render profile. The
+// images declared by the artifacthub.io/images annotation of a chart follow its rendered images. The
+// templates left out by tolerant rendering, and the images a chart renders differently than its
+// annotation declares, are passed to report.
+func ExtractImagesWithValuesLocationsFromHelmFiles(helmCharts []types.HelmChartInfo, options HelmOptions, report DiagnosticReporter) ([]types.ImageModel, error) {
+	images, err := ExtractImageDetailsFromHelmFiles(helmCharts, options, report)
+	return ImageModels(images), err
+}
+
+// ExtractImageDetailsFromHelmFiles is ExtractImagesWithValuesLocationsFromHelmFiles returning every
+// location of an image with the values key it points at and the environment it was rendered in.
+func ExtractImageDetailsFromHelmFiles(helmCharts []types.HelmChartInfo, options HelmOptions, report DiagnosticReporter) ([]ImageDetails, error) {
+	var imagesFromHelmDirectories []ImageDetails
+	for _, h := range helmCharts {
+		log.Info().Msgf("going to trace images to the values of helm directory %s", h.Directory)
+
+		helmChart, err := loadHelmChart(h)
+		if err != nil {
+			log.Err(err).Msgf("Could not trace images from helm directory %s", h.Directory)
+			continue
+		}
+
+		annotation := findHelmChartAnnotation(helmChartFileOf(helmChart, h))
+		chartImages := findHelmChartImages(h, annotation, helmEnvironments(h, options), options.RenderAllBranches, report,
+			func(environment helmEnvironment) ([]ImageDetails, []Diagnostic, error) {
+				return traceHelmChartImages(helmChart, h, environment)
+			})
+		chartImages = append(chartImages, ImageDetailsOf(annotation.declaredImages())...)
+
+		printFoundImages(h, ImageModels(chartImages))
+		imagesFromHelmDirectories = append(imagesFromHelmDirectories, chartImages...)
+	}
+
+	return imagesFromHelmDirectories, nil
+}
+
+// traceHelmChartImages renders a chart in an environment, then renders it again with a marker appended
+// to every values leaf that is a whole part of a rendered image, such as its repository or tag. The
+// leaves whose marker shows up in an image are the keys that image comes from. The leaves of
+// values.yaml and of every values file of the environment are traced. The templates left out by tolerant rendering are
+// returned as diagnostics.
+func traceHelmChartImages(helmChart *chart.Chart, h types.HelmChartInfo, environment helmEnvironment) ([]ImageDetails, []Diagnostic, error) {
+	valuesFile := helmValuesFile(h)
+	root, err := chartValues(helmChart)
+	if err != nil {
+		return nil, nil, err
+	}
+	layers, err := environment.layers()
+	if err != nil {
+		return nil, nil, err
+	}
+	layers = append([]helmValuesLayer{{file: valuesFile, root: root}}, layers...)
+
+	vals, err := environment.values(layers)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest, diagnostics, err := renderHelmChart(helmChart, vals, environment)
+	if err != nil {
+		return nil, diagnostics, err
+	}
+	images, err := extractRenderedImages(manifest)
+	if err != nil {
+		return nil, diagnostics, err
+	}
+	diagnostics = append(diagnostics, helmPlaceholderDiagnostics(images)...)
+
+	var candidates []tracedHelmValueLeaf
+	for _, layer := range layers {
+		for _, leaf := range helmValueLeaves(layer.root) {
+			if imagePartOfImages(leaf.node.Value, images) {
+				candidates = append(candidates, tracedHelmValueLeaf{helmValueLeaf: leaf, file: layer.file})
+			}
+		}
+	}
+	traced := traceHelmValueLeaves(helmChart, environment, layers, candidates, images)
+
+	var details []ImageDetails
+	for i, key := range renderedImageKeys(images) {
+		image := images[i]
+		image.Environment = environment.name
+		leaves := traced[key]
+		if len(leaves) == 0 {
+			location := &image.ImageLocations[0]
+			location.Line, location.StartIndex, location.EndIndex = -1, -1, -1
+			details = append(details, image)
+			continue
+		}
+		for _, leaf := range leaves {
+			line, start, end := scalarSpan(leaf.node)
+			detail := image
+			detail.ImageLocations = []types.ImageLocation{{
+				Origin:     types.HelmFileOrigin,
+				Path:       leaf.file.RelativePath,
+				Line:       line,
+				StartIndex: start,
+				EndIndex:   end,
+			}}
+			detail.ValuesKey = leaf.key
+			details = append(details, detail)
+		}
+	}
+	return details, diagnostics, nil
+}
+
+// traceHelmValueLeaves finds the images of a chart every leaf of candidates, leaves of layers, ends up
+// in. The chart is rendered once with a marker numbering each candidate appended to its value, and
+// the markers showing up in an image are the keys that image comes from. When that render fails, as
+// a template may not take a marked value, the candidates are traced one render at a time instead.
+// The traced leaves are returned by the key of their image, as given by renderedImageKeys.
+func traceHelmValueLeaves(helmChart *chart.Chart, environment helmEnvironment, layers []helmValuesLayer,
+	candidates []tracedHelmValueLeaf, images []ImageDetails) map[string][]tracedHelmValueLeaf {
+	traced := make(map[string][]tracedHelmValueLeaf)
+	if len(candidates) == 0 {
+		return traced
+	}
+
+	marks := make(map[*yaml.Node]string, len(candidates))
+	for i, candidate := range candidates {
+		marks[candidate.node] = helmTraceMark(i)
+	}
+	matches, err := traceHelmValueMarks(helmChart, environment, layers, marks, images)
+	if err == nil {
+		for key, indices := range matches {
+			slices.Sort(indices)
+			for _, i := range indices {
+				traced[key] = append(traced[key], candidates[i])
+			}
+		}
+		return traced
+	}
+
+	log.Debug().Msgf("Could not trace the values of the chart in one render, tracing them one at a time err: %+v", err)
+	for _, candidate := range candidates {
+		matches, err := traceHelmValueMarks(helmChart, environment, layers, map[*yaml.Node]string{candidate.node: helmTraceMark(0)}, images)
+		if err != nil {
+			log.Debug().Msgf("Could not trace values key %s of %s err: %+v", candidate.key, candidate.file.RelativePath, err)
+			continue
+		}
+		for key := range matches {
+			traced[key] = append(traced[key], candidate)
+		}
+	}
+	return traced
+}
+
+// helmTraceMark is the marker appended to the i-th traced values leaf.
+func helmTraceMark(i int) string {
+	return fmt.Sprintf("%s%d%s", helmTraceMarker, i, helmTraceMarker)
+}
+
+// helmTraceMarkPattern matches the markers of helmTraceMark, capturing the number of their leaf.
+var helmTraceMarkPattern = regexp.MustCompile(helmTraceMarker + `(\d+)` + helmTraceMarker)
+
+// traceHelmValueMarks renders a chart with a copy of layers in which the marked leaves have their
+// marker appended, and returns the numbers of the markers found in each image whose name, without
+// the markers, is the name it has in images, by the key given by renderedImageKeys.
+func traceHelmValueMarks(helmChart *chart.Chart, environment helmEnvironment, layers []helmValuesLayer,
+	marks map[*yaml.Node]string, images []ImageDetails) (map[string][]int, error) {
+	vals, err := environment.values(markedHelmValuesLayers(layers, marks))
+	if err != nil {
+		return nil, err
+	}
+	manifest, _, err := renderHelmChart(helmChart, vals, environment)
+	if err != nil {
+		return nil, err
+	}
+	markedImages, err := extractRenderedImages(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	originals := make(map[string]string, len(images))
+	for i, key := range renderedImageKeys(images) {
+		originals[key] = images[i].Name
+	}
+
+	matches := make(map[string][]int)
+	for i, key := range renderedImageKeys(markedImages) {
+		name := markedImages[i].Name
+		if originals[key] != helmTraceMarkPattern.ReplaceAllString(name, "") {
+			continue
+		}
+		for _, match := range helmTraceMarkPattern.FindAllStringSubmatch(name, -1) {
+			if n, err := strconv.Atoi(match[1]); err == nil && !slices.Contains(matches[key], n) {
+				matches[key] = append(matches[key], n)
+			}
+		}
+	}
+	return matches, nil
+}
+
+// markedHelmValuesLayers copies layers, appending its marker to every leaf of marks in the copy.
+func markedHelmValuesLayers(layers []helmValuesLayer, marks map[*yaml.Node]string) []helmValuesLayer {
+	marked := make([]helmValuesLayer, len(layers))
+	for i, layer := range layers {
+		copies := make(map[*yaml.Node]*yaml.Node)
+		marked[i] = helmValuesLayer{file: layer.file, root: copyYAMLNode(layer.root, marks, copies)}
+	}
+	return marked
+}
+
+// copyYAMLNode deeply copies a YAML tree, appending the marker of marks to the marked scalars of the
+// copy. Aliases of the copy point at the copies of their anchors, recorded in copies.
+func copyYAMLNode(node *yaml.Node, marks map[*yaml.Node]string, copies map[*yaml.Node]*yaml.Node) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	if nodeCopy, ok := copies[node]; ok {
+		return nodeCopy
+	}
+	nodeCopy := *node
+	copies[node] = &nodeCopy
+	if mark, ok := marks[node]; ok {
+		nodeCopy.Value, nodeCopy.Tag = node.Value+mark, "!!str"
+	}
+	nodeCopy.Alias = copyYAMLNode(node.Alias, marks, copies)
+	nodeCopy.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		nodeCopy.Content[i] = copyYAMLNode(child, marks, copies)
+	}
+	return &nodeCopy
+}
+
+// renderedImageKeys identifies rendered images by their template and their position in it, so that
+// the images of two renders of a chart can be matched.
+func renderedImageKeys(images []ImageDetails) []string {
+	counts := make(map[string]int)
+	keys := make([]string, len(images))
+	for i, image := range images {
+		source := image.ImageLocations[0].Path
+		keys[i] = fmt.Sprintf("%s#%d", source, counts[source])
+		counts[source]++
+	}
+	return keys
+}
+
+// imagePartOfImages reports whether a value is a whole part of the name of one of images: a run of
+// its path components, its tag or its digest, such as ghcr.io/org, api or 1.4.2 in
+// ghcr.io/org/api:1.4.2, but not 1 or org/a.
+func imagePartOfImages(value string, images []ImageDetails) bool {
+	if value == "" {
+		return false
+	}
+	for _, image := range images {
+		if isImagePart(value, image.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isImagePart(value, name string) bool {
+	isBoundary := func(c byte) bool { return c == '/' || c == ':' || c == '@' }
+	for offset := 0; offset+len(value) <= len(name); {
+		i := strings.Index(name[offset:], value)
+		if i == -1 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(value)
+		if (start == 0 || isBoundary(name[start-1])) && (end == len(name) || isBoundary(name[end])) {
+			return true
+		}
+		offset = start + 1
+	}
+	return false
+}
+
+// helmValuesFile returns the values.yaml a chart is rendered with. Its relative path is the values
+// file of the chart when it is one, and values.yaml otherwise.
+func helmValuesFile(h types.HelmChartInfo) types.FilePath {
+	relativePath := "values.yaml"
+	if path.Base(filepath.ToSlash(h.ValuesFile)) == "values.yaml" {
+		relativePath = filepath.ToSlash(h.ValuesFile)
+	}
+	return types.FilePath{FullPath: filepath.Join(h.Directory, "values.yaml"), RelativePath: relativePath}
+}
+
+// chartValues parses the values.yaml a chart was loaded with, from its directory or its archive. A
+// chart without one has an empty tree.
+func chartValues(helmChart *chart.Chart) (*yaml.Node, error) {
+	var root yaml.Node
+	for _, file := range helmChart.Raw {
+		if file.Name == chartutil.ValuesfileName {
+			if err := yaml.Unmarshal(file.Data, &root); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return &root, nil
+}
+
+func readHelmValues(filePath string) (*yaml.Node, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+// helmValueLeaves returns the string and number scalars of a values file.
+func helmValueLeaves(root *yaml.Node) []helmValueLeaf {
+	var leaves []helmValueLeaf
+	var walk func(node *yaml.Node, key string)
+	walk = func(node *yaml.Node, key string) {
+		node = resolveAlias(node)
+		if node == nil {
+			return
+		}
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, key)
+			}
+		case yaml.MappingNode:
+			for _, entry := range mappingEntries(node) {
+				childKey := entry.key.Value
+				if key != "" {
+					childKey = key + "." + childKey
+				}
+				walk(entry.value, childKey)
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				walk(child, fmt.Sprintf("%s[%d]", key, i))
+			}
+		case yaml.ScalarNode:
+			if node.Tag != "!!bool" && node.Tag != "!!null" {
+				leaves = append(leaves, helmValueLeaf{key: key, node: node})
+			}
+		}
+	}
+	walk(root, "")
+	return leaves
+}
@@ -0,0 +1,256 @@
+package extractors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
+	"gopkg.in/yaml.v3"
+)
+
+func TestExtractImageDetailsFromHelmFiles(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-values-tracing", ValuesFile: "charts/tracing/values.yaml"},
+	}
+
+	images, err := ExtractImageDetailsFromHelmFiles(helmCharts, HelmOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	value := func(name, key string, line, start, end int) ImageDetails {
+		return ImageDetails{
+			ImageModel: types.ImageModel{Name: name, ImageLocations: []types.ImageLocation{
+				{Origin: types.HelmFileOrigin, Path: "charts/tracing/values.yaml", Line: line, StartIndex: start, EndIndex: end},
+			}},
+			HelmDetails: HelmDetails{ValuesKey: key},
+		}
+	}
+	template := func(name, path string) ImageDetails {
+		return ImageDetails{ImageModel: types.ImageModel{Name: name, ImageLocations: []types.ImageLocation{
+			{Origin: types.HelmFileOrigin, Path: path, Line: -1, StartIndex: -1, EndIndex: -1},
+		}}}
+	}
+	resource := func(kind, workload, container string, details ...ImageDetails) []ImageDetails {
+		for i := range details {
+			details[i].Kind, details[i].Workload, details[i].Container = kind, workload, container
+		}
+		return details
+	}
+
+	var expected []ImageDetails
+	for _, details := range [][]ImageDetails{
+		resource("Deployment", "temp-release-web", "migrate",
+			value("ghcr.io/org/migrations:1.4.2", "global.registry", 1, 12, 23),
+			value("ghcr.io/org/migrations:1.4.2", "api.image.tag", 6, 10, 15)),
+		resource("Deployment", "temp-release-web", "web", template("nginx:1.27", "tracing/templates/deployment.yaml")),
+		resource("Microservice", "temp-release-api", "",
+			value("ghcr.io/org/api:1.4.2", "global.registry", 1, 12, 23),
+			value("ghcr.io/org/api:1.4.2", "api.image.repository", 5, 16, 19),
+			value("ghcr.io/org/api:1.4.2", "api.image.tag", 6, 10, 15)),
+		resource("Microservice", "temp-release-exporter", "", template("quay.io/prometheus/node-exporter:v1.8.0", "tracing/templates/exporter.yaml")),
+		resource("Microservice", "temp-release-worker", "",
+			value("docker.io/library/worker:2.0.1", "worker.image.repository", 9, 16, 22),
+			value("docker.io/library/worker:2.0.1", "worker.image.tag", 10, 9, 14)),
+	} {
+		expected = append(expected, details...)
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+
+	models, err := ExtractImagesWithValuesLocationsFromHelmFiles(helmCharts, HelmOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	if len(models) != len(expected) {
+		t.Errorf("Expected %d images but got %d", len(expected), len(models))
+	}
+}
+
+func TestExtractImageDetailsFromHelmFiles_Environments(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-environments", ValuesFile: "environments/values.yaml"},
+	}
+
+	images, err := ExtractImageDetailsFromHelmFiles(helmCharts, HelmOptions{Environments: true}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	value := func(environment, name, path, key string, line, start, end int) ImageDetails {
+		return ImageDetails{
+			ImageModel: types.ImageModel{Name: name, ImageLocations: []types.ImageLocation{
+				{Origin: types.HelmFileOrigin, Path: path, Line: line, StartIndex: start, EndIndex: end},
+			}},
+			KubernetesDetails: KubernetesDetails{Kind: "Deployment", Workload: "temp-release-shop", Container: "shop"},
+			HelmDetails:       HelmDetails{ValuesKey: key, Environment: environment},
+		}
+	}
+	exporter := ImageDetails{
+		ImageModel: types.ImageModel{Name: "prom/statsd-exporter:v0.26.0", ImageLocations: []types.ImageLocation{
+			{Origin: types.HelmFileOrigin, Path: "environments/templates/deployment.yaml", Line: -1, StartIndex: -1, EndIndex: -1},
+		}},
+		KubernetesDetails: KubernetesDetails{Kind: "Deployment", Workload: "temp-release-shop", Container: "metrics"},
+		HelmDetails:       HelmDetails{Environment: "prod"},
+	}
+	expected := []ImageDetails{
+		value("", "ghcr.io/org/shop:1.0.0", "environments/values.yaml", "image.repository", 1, 14, 30),
+		value("", "ghcr.io/org/shop:1.0.0", "environments/values.yaml", "image.tag", 2, 8, 13),
+		value("dev", "ghcr.io/org/shop-dev:1.0.0", "environments/values.yaml", "image.tag", 2, 8, 13),
+		value("dev", "ghcr.io/org/shop-dev:1.0.0", "environments/values-dev.yaml", "image.repository", 1, 14, 34),
+		value("prod", "ghcr.io/org/shop:1.0.0-prod", "environments/values.yaml", "image.repository", 1, 14, 30),
+		value("prod", "ghcr.io/org/shop:1.0.0-prod", "environments/values-prod.yaml", "image.tag", 1, 8, 18),
+		exporter,
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
+
+func TestExtractImageDetailsFromHelmFiles_Subcharts(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-subcharts/umbrella", ValuesFile: "umbrella/values.yaml"},
+	}
+
+	images, err := ExtractImageDetailsFromHelmFiles(helmCharts, HelmOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	expected := []ImageDetails{
+		{
+			ImageModel: types.ImageModel{Name: "ghcr.io/org/web:2.4.0", ImageLocations: []types.ImageLocation{
+				{Origin: types.HelmFileOrigin, Path: "umbrella/values.yaml", Line: 3, StartIndex: 10, EndIndex: 15},
+			}},
+			KubernetesDetails: KubernetesDetails{Kind: "Deployment", Workload: "temp-release-web", Container: "web"},
+			HelmDetails:       HelmDetails{ValuesKey: "web.image.tag", Subchart: "web"},
+		},
+		{
+			ImageModel: types.ImageModel{Name: "redis:7.2", ImageLocations: []types.ImageLocation{
+				{Origin: types.HelmFileOrigin, Path: "umbrella/charts/cache/templates/statefulset.yaml", Line: -1, StartIndex: -1, EndIndex: -1},
+			}},
+			KubernetesDetails: KubernetesDetails{Kind: "StatefulSet", Workload: "temp-release-cache", Container: "redis"},
+			HelmDetails:       HelmDetails{Subchart: "cache"},
+		},
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
+
+func TestExtractImageDetailsFromHelmFiles_SubchartsPerRender(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-subcharts/umbrella", ValuesFile: "umbrella/values.yaml"},
+	}
+
+	tests := []struct {
+		name     string
+		options  HelmOptions
+		expected []string
+	}{
+		{"Environments", HelmOptions{Environments: true}, []string{
+			"web/", "cache/",
+			"metrics/prod", "web/prod", "cache/prod",
+		}},
+		{"TagsAndConditionPerProfile", HelmOptions{
+			Set:               []string{"tags.cache=false,metrics.enabled=true"},
+			RenderAllBranches: true,
+			RenderProfiles:    []HelmRenderOptions{{}, {KubeVersion: "v1.31.0"}},
+		}, []string{"metrics/", "web/"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			images, err := ExtractImageDetailsFromHelmFiles(helmCharts, test.options, nil)
+			if err != nil {
+				t.Fatalf("Error extracting images: %v", err)
+			}
+			var subcharts []string
+			for _, image := range images {
+				subcharts = append(subcharts, image.Subchart+"/"+image.Environment)
+			}
+			if !reflect.DeepEqual(subcharts, test.expected) {
+				t.Errorf("Expected subcharts %v but got %v", test.expected, subcharts)
+			}
+		})
+	}
+}
+
+func TestExtractImageDetailsFromHelmFiles_Archive(t *testing.T) {
+	helmCharts := []types.HelmChartInfo{
+		{Directory: "../../test_files/helm-archives/releases/shop-1.2.3.tgz", ValuesFile: "shop/values.yaml"},
+	}
+
+	images, err := ExtractImageDetailsFromHelmFiles(helmCharts, HelmOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	image := func(name, path, key string, line, start, end int, container string) ImageDetails {
+		return ImageDetails{
+			ImageModel: types.ImageModel{Name: name, ImageLocations: []types.ImageLocation{
+				{Origin: types.HelmFileOrigin, Path: path, Line: line, StartIndex: start, EndIndex: end},
+			}},
+			KubernetesDetails: KubernetesDetails{Kind: "Deployment", Workload: "temp-release-shop", Container: container},
+			HelmDetails:       HelmDetails{ValuesKey: key},
+		}
+	}
+	expected := []ImageDetails{
+		image("ghcr.io/org/shop:1.2.3", "shop/values.yaml", "image.repository", 1, 14, 30, "shop"),
+		image("ghcr.io/org/shop:1.2.3", "shop/values.yaml", "image.tag", 2, 8, 13, "shop"),
+		image("envoyproxy/envoy:v1.31.0", "shop/templates/deployment.yaml", "", -1, -1, -1, "proxy"),
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
+
+func TestIsImagePart(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"ghcr.io/org/api:1.4.2", true},
+		{"ghcr.io/org", true},
+		{"ghcr.io", true},
+		{"org/api", true},
+		{"api", true},
+		{"1.4.2", true},
+		{"sha256:0123", true},
+		{"1", false},
+		{"4", false},
+		{"org/a", false},
+		{"pi", false},
+	}
+	for _, test := range tests {
+		if isImagePart(test.value, "ghcr.io/org/api:1.4.2@sha256:0123") != test.expected {
+			t.Errorf("Expected %s to be an image part %v", test.value, test.expected)
+		}
+	}
+}
+
+func TestMarkedHelmValuesLayers(t *testing.T) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte("tag: &tag 1.0.0\nsidecar:\n  tag: *tag\nreplicas: 3\n"), &root); err != nil {
+		t.Fatalf("Error parsing values: %v", err)
+	}
+	layers := []helmValuesLayer{{file: types.FilePath{RelativePath: "values.yaml"}, root: &root}}
+	tag := helmValueLeaves(&root)[0].node
+
+	marked := markedHelmValuesLayers(layers, map[*yaml.Node]string{tag: helmTraceMark(0)})
+	var vals map[string]interface{}
+	if err := marked[0].root.Decode(&vals); err != nil {
+		t.Fatalf("Error decoding marked values: %v", err)
+	}
+	expected := map[string]interface{}{
+		"tag":      "1.0.0" + helmTraceMark(0),
+		"sidecar":  map[string]interface{}{"tag": "1.0.0" + helmTraceMark(0)},
+		"replicas": 3,
+	}
+	if !reflect.DeepEqual(vals, expected) {
+		t.Errorf("Expected marked values %+v but got %+v", expected, vals)
+	}
+	if tag.Value != "1.0.0" || tag.Tag != "!!str" {
+		t.Errorf("Expected the original leaf to be left unchanged, got %s %s", tag.Value, tag.Tag)
+	}
+}
@@ -0,0 +1,475 @@
+package extractors
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// helmfileNames are the file names helmfile loads when no --file flag is given.
+var helmfileNames = []string{"helmfile.yaml", "helmfile.yaml.gotmpl", "helmfile.yml"}
+
+// helmfileDefaultEnvironment is the environment helmfile renders releases in when none is selected.
+const helmfileDefaultEnvironment = "default"
+
+// helmfileEnvironment is an environment of a Helmfile, with the entries of its values field: values
+// file paths or inline values.
+type helmfileEnvironment struct {
+	name   string
+	values []*yaml.Node
+}
+
+// helmfileRelease is a release of a Helmfile rendered in an environment.
+type helmfileRelease struct {
+	name, namespace string
+	chart           string
+	// chartNode is the scalar of the chart field, where unresolved charts are reported.
+	chartNode *yaml.Node
+	// values are the entries of its values field: values file paths or inline values.
+	values []*yaml.Node
+	set    []string
+	// installed is false for the releases helmfile does not install, by their installed or condition field.
+	installed bool
+}
+
+// IsHelmfile reports whether a file is a Helmfile, by its name: helmfile.yaml, helmfile.yaml.gotmpl,
+// helmfile.yml, or a YAML file of a helmfile.d directory.
+func IsHelmfile(filePath string) bool {
+	name := filepath.Base(filePath)
+	if slices.Contains(helmfileNames, name) {
+		return true
+	}
+	return filepath.Base(filepath.Dir(filePath)) == "helmfile.d" &&
+		(isYAMLPath(name) || isYAMLPath(strings.TrimSuffix(name, ".gotmpl")))
+}
+
+// ExtractImagesFromHelmfiles renders the releases of Helmfiles in every environment of the Helmfile,
+// through their local chart with the values and set overrides of the release. Releases of remote
+// charts are not fetched, they are passed to report as unresolved, along with the problems found
+// rendering the Helmfile and the charts. The values files and set overrides of options are not applied.
+func ExtractImagesFromHelmfiles(helmfiles []types.FilePath, options HelmOptions, report DiagnosticReporter) ([]types.ImageModel, error) {
+	images, err := ExtractImageDetailsFromHelmfiles(helmfiles, options, report)
+	return ImageModels(images), err
+}
+
+// ExtractImageDetailsFromHelmfiles is ExtractImagesFromHelmfiles returning every image with the
+// release and environment it was rendered for, located at the template rendering it.
+func ExtractImageDetailsFromHelmfiles(helmfiles []types.FilePath, options HelmOptions, report DiagnosticReporter) ([]ImageDetails, error) {
+	return extractHelmfilesImages(helmfiles, options, report, func(helmChart helmfileChart, environment helmEnvironment) ([]ImageDetails, []Diagnostic, error) {
+		return renderHelmEnvironmentImages(helmChart.chart, environment)
+	})
+}
+
+// ExtractImageDetailsWithValuesLocationsFromHelmfiles is ExtractImageDetailsFromHelmfiles locating
+// every image at the values keys it is built from, in the values of the chart, of the release, or
+// inline in the Helmfile, like ExtractImageDetailsFromHelmFiles.
+func ExtractImageDetailsWithValuesLocationsFromHelmfiles(helmfiles []types.FilePath, options HelmOptions, report DiagnosticReporter) ([]ImageDetails, error) {
+	return extractHelmfilesImages(helmfiles, options, report, func(helmChart helmfileChart, environment helmEnvironment) ([]ImageDetails, []Diagnostic, error) {
+		return traceHelmChartImages(helmChart.chart, helmChart.info, environment)
+	})
+}
+
+func extractHelmfilesImages(helmfiles []types.FilePath, options HelmOptions, report DiagnosticReporter,
+	find func(helmfileChart, helmEnvironment) ([]ImageDetails, []Diagnostic, error)) ([]ImageDetails, error) {
+	var images []ImageDetails
+	for _, helmfile := range helmfiles {
+		log.Info().Msgf("going to extract images from the releases of helmfile %s", helmfile.RelativePath)
+		helmfileImages, err := extractHelmfileImages(helmfile, options, report, find)
+		if err != nil {
+			log.Warn().Msgf("could not read helmfile %s err: %+v", helmfile.RelativePath, err)
+			continue
+		}
+		printFoundImagesInFile(helmfile.RelativePath, ImageModels(helmfileImages))
+		images = append(images, helmfileImages...)
+	}
+	return images, nil
+}
+
+func extractHelmfileImages(helmfile types.FilePath, options HelmOptions, report DiagnosticReporter,
+	find func(helmfileChart, helmEnvironment) ([]ImageDetails, []Diagnostic, error)) ([]ImageDetails, error) {
+	content, err := os.ReadFile(helmfile.FullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []ImageDetails
+	var diagnostics []Diagnostic
+	for _, environment := range helmfileEnvironments(helmfile, content) {
+		vals := helmfileEnvironmentValues(helmfile, environment)
+		documents, err := renderHelmfileDocuments(helmfile, content, environment.name, vals)
+		if err != nil {
+			diagnostic := helmTemplateDiagnostic(helmfile.RelativePath, err)
+			diagnostic.Message = fmt.Sprintf("could not render the helmfile in environment %s: %s", environment.name, diagnostic.Message)
+			diagnostics = append(diagnostics, diagnostic)
+			continue
+		}
+
+		repositories, releases := helmfileReleases(documents, vals)
+		for _, release := range releases {
+			if !release.installed {
+				continue
+			}
+			chartPath, ok := resolveHelmfileChart(helmfile, release.chart, repositories)
+			if !ok {
+				line := -1
+				if release.chartNode != nil {
+					line = release.chartNode.Line - 1
+				}
+				diagnostics = append(diagnostics, Diagnostic{
+					Severity: DiagnosticWarning,
+					Path:     helmfile.RelativePath,
+					Line:     line,
+					Message:  fmt.Sprintf("release %s uses the chart %s, which is not available locally and is not fetched", release.name, release.chart),
+				})
+				continue
+			}
+
+			helmChart, err := loadHelmfileChart(chartPath)
+			if err != nil {
+				log.Err(err).Msgf("Could not load the chart of release %s of helmfile %s", release.name, helmfile.RelativePath)
+				continue
+			}
+			annotation := findHelmChartAnnotation(helmChartFileOf(helmChart.chart, helmChart.info))
+			releaseImages := findHelmChartImages(helmChart.info, annotation,
+				helmfileReleaseEnvironments(helmfile, environment, vals, release, options), options.RenderAllBranches, report,
+				func(environment helmEnvironment) ([]ImageDetails, []Diagnostic, error) {
+					return find(helmChart, environment)
+				})
+			for i := range releaseImages {
+				releaseImages[i].Release = release.name
+			}
+			images = append(images, releaseImages...)
+		}
+	}
+	reportHelmDiagnostics(report, diagnostics)
+	return images, nil
+}
+
+// helmfileEnvironments returns the environments of a Helmfile, or its default environment when it
+// declares none. The environments field is read from the Helmfile rendered without values, or with
+// its template actions left out when it does not render without them.
+func helmfileEnvironments(helmfile types.FilePath, content []byte) []helmfileEnvironment {
+	documents, err := renderHelmfileDocuments(helmfile, content, helmfileDefaultEnvironment, map[string]interface{}{})
+	if err != nil {
+		documents = parseYAMLDocuments(helmActionPattern.ReplaceAll(content, nil))
+	}
+
+	var environments []helmfileEnvironment
+	for _, document := range documents {
+		for _, entry := range mappingEntries(mappingValue(document, "environments")) {
+			environment := helmfileEnvironment{name: entry.key.Value}
+			if values := resolveAlias(mappingValue(entry.value, "values")); values != nil && values.Kind == yaml.SequenceNode {
+				environment.values = values.Content
+			}
+			environments = append(environments, environment)
+		}
+	}
+	if len(environments) == 0 {
+		return []helmfileEnvironment{{name: helmfileDefaultEnvironment}}
+	}
+	return environments
+}
+
+// helmfileEnvironmentValues merges the values of an environment, which the Helmfile and the values
+// files of its releases are rendered with.
+func helmfileEnvironmentValues(helmfile types.FilePath, environment helmfileEnvironment) map[string]interface{} {
+	vals := make(map[string]interface{})
+	for _, layer := range helmfileValuesLayers(helmfile, environment.name, vals, environment.values) {
+		var layerVals map[string]interface{}
+		if err := layer.root.Decode(&layerVals); err != nil {
+			log.Debug().Msgf("Could not decode the values of environment %s from %s err: %+v", environment.name, layer.file.RelativePath, err)
+			continue
+		}
+		vals = mergeHelmValues(vals, layerVals)
+	}
+	return vals
+}
+
+// helmfileValuesLayers parses the entries of a values field of a Helmfile: values files, relative to
+// the Helmfile and rendered first when they end in .gotmpl, and inline values. Missing files are skipped.
+func helmfileValuesLayers(helmfile types.FilePath, environment string, vals map[string]interface{}, entries []*yaml.Node) []helmValuesLayer {
+	var layers []helmValuesLayer
+	for _, entry := range entries {
+		entry = resolveAlias(entry)
+		if entry == nil {
+			continue
+		}
+		if entry.Kind == yaml.MappingNode {
+			layers = append(layers, helmValuesLayer{file: helmfile, root: entry})
+			continue
+		}
+		if entry.Kind != yaml.ScalarNode || entry.Value == "" {
+			continue
+		}
+
+		file := relatedFile(helmfile, entry.Value)
+		content, err := readScanFile(scanRoot(helmfile), file)
+		if err != nil {
+			log.Warn().Msgf("could not read values file %s of helmfile %s err: %+v", entry.Value, helmfile.RelativePath, err)
+			continue
+		}
+		if strings.HasSuffix(file.FullPath, ".gotmpl") {
+			if content, err = renderHelmfileTemplate(scanRoot(helmfile), file, content, environment, vals); err != nil {
+				log.Warn().Msgf("could not render values file %s of helmfile %s err: %+v", entry.Value, helmfile.RelativePath, err)
+				continue
+			}
+		}
+		var root yaml.Node
+		if err := yaml.Unmarshal(content, &root); err != nil {
+			log.Warn().Msgf("could not parse values file %s of helmfile %s err: %+v", entry.Value, helmfile.RelativePath, err)
+			continue
+		}
+		layers = append(layers, helmValuesLayer{file: file, root: &root})
+	}
+	return layers
+}
+
+// helmfileReleases returns the repository names and the releases of the documents of a rendered
+// Helmfile. Releases are not installed when their installed field is false, or when their condition
+// names an environment value that is false.
+func helmfileReleases(documents []*yaml.Node, vals map[string]interface{}) ([]string, []helmfileRelease) {
+	var repositories []string
+	var releases []helmfileRelease
+	for _, document := range documents {
+		for _, repository := range resolveSequence(mappingValue(document, "repositories")) {
+			if name := scalarValue(mappingValue(repository, "name")); name != "" {
+				repositories = append(repositories, name)
+			}
+		}
+		for _, node := range resolveSequence(mappingValue(document, "releases")) {
+			release := helmfileRelease{
+				name:      scalarValue(mappingValue(node, "name")),
+				namespace: scalarValue(mappingValue(node, "namespace")),
+				chartNode: scalarNode(mappingValue(node, "chart")),
+				values:    resolveSequence(mappingValue(node, "values")),
+				installed: scalarValue(mappingValue(node, "installed")) != "false",
+			}
+			if release.chartNode == nil {
+				continue
+			}
+			release.chart = release.chartNode.Value
+			if condition := scalarValue(mappingValue(node, "condition")); condition != "" && !helmfileCondition(vals, condition) {
+				release.installed = false
+			}
+			for _, set := range resolveSequence(mappingValue(node, "set")) {
+				if override := helmfileSetOverride(set); override != "" {
+					release.set = append(release.set, override)
+				}
+			}
+			releases = append(releases, release)
+		}
+	}
+	return repositories, releases
+}
+
+// helmfileSetOverride turns an entry of the set field of a release, such as {name: image.tag, value:
+// 1.2.3}, into the --set override helmfile passes to helm.
+func helmfileSetOverride(set *yaml.Node) string {
+	name := scalarValue(mappingValue(set, "name"))
+	if name == "" {
+		return ""
+	}
+	if values := resolveSequence(mappingValue(set, "values")); values != nil {
+		var items []string
+		for _, value := range values {
+			items = append(items, escapeHelmSetValue(scalarValue(value)))
+		}
+		return name + "={" + strings.Join(items, ",") + "}"
+	}
+	return name + "=" + escapeHelmSetValue(scalarValue(mappingValue(set, "value")))
+}
+
+func escapeHelmSetValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`).Replace(value)
+}
+
+// helmfileCondition reports whether the environment value a release condition names, such as
+// metrics.enabled, is not false. Conditions naming no value are met.
+func helmfileCondition(vals map[string]interface{}, condition string) bool {
+	var value interface{} = vals
+	for _, key := range strings.Split(condition, ".") {
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return true
+		}
+		if value, ok = values[key]; !ok {
+			return true
+		}
+	}
+	enabled, ok := value.(bool)
+	return !ok || enabled
+}
+
+// resolveHelmfileChart returns the local chart of a release, a directory or a packaged chart relative
+// to the Helmfile. Charts of a repository of the Helmfile, OCI or URL charts, and charts not found
+// in the scanned directory are not resolved.
+func resolveHelmfileChart(helmfile types.FilePath, chart string, repositories []string) (types.FilePath, bool) {
+	if strings.Contains(chart, "://") {
+		return types.FilePath{}, false
+	}
+	if repository, _, found := strings.Cut(chart, "/"); found && slices.Contains(repositories, repository) {
+		return types.FilePath{}, false
+	}
+	chartPath := relatedFile(helmfile, chart)
+	if _, err := scanFilePath(scanRoot(helmfile), chartPath); err != nil {
+		return types.FilePath{}, false
+	}
+	return chartPath, true
+}
+
+// helmfileChart is the loaded chart of a release, with the chart info it is reported with.
+type helmfileChart struct {
+	info  types.HelmChartInfo
+	chart *chart.Chart
+}
+
+// loadHelmfileChart loads the local chart of a release, a directory or a packaged chart. It is loaded
+// for every release in every environment, and every render of it renders a copy, as helm removes the
+// subcharts a render disables from the chart it renders.
+func loadHelmfileChart(chartPath types.FilePath) (helmfileChart, error) {
+	info := types.HelmChartInfo{Directory: chartPath.FullPath}
+	if !isHelmChartArchive(chartPath.FullPath) {
+		if _, err := os.Stat(filepath.Join(chartPath.FullPath, "values.yaml")); err == nil {
+			info.ValuesFile = path.Join(chartPath.RelativePath, "values.yaml")
+		}
+	}
+	helmChart, err := loadHelmChart(info)
+	if err != nil {
+		return helmfileChart{}, err
+	}
+	return helmfileChart{info: info, chart: helmChart}, nil
+}
+
+// helmfileReleaseEnvironments returns the environments the chart of a release is rendered in: one per
+// render profile of options, with the values, set overrides, name and namespace of the release.
+func helmfileReleaseEnvironments(helmfile types.FilePath, environment helmfileEnvironment, vals map[string]interface{},
+	release helmfileRelease, options HelmOptions) []helmEnvironment {
+	layers := helmfileValuesLayers(helmfile, environment.name, vals, release.values)
+
+	var environments []helmEnvironment
+	for _, render := range options.renderProfiles() {
+		render.ReleaseName = release.name
+		if release.namespace != "" {
+			render.Namespace = release.namespace
+		}
+		environments = append(environments, helmEnvironment{
+			name:     environment.name,
+			parsed:   layers,
+			set:      release.set,
+			render:   render,
+			tolerant: options.Tolerant,
+		})
+	}
+	return environments
+}
+
+// renderHelmfileDocuments renders a Helmfile in an environment and parses its documents.
+func renderHelmfileDocuments(helmfile types.FilePath, content []byte, environment string, vals map[string]interface{}) ([]*yaml.Node, error) {
+	rendered, err := renderHelmfileTemplate(scanRoot(helmfile), helmfile, content, environment, vals)
+	if err != nil {
+		return nil, err
+	}
+	return parseYAMLDocuments(rendered), nil
+}
+
+// renderHelmfileTemplate renders a Helmfile, or a .gotmpl values file of its releases, the way
+// helmfile does: a Go template with the functions of helmfileTemplateFuncs, given the environment and
+// its values.
+func renderHelmfileTemplate(root string, file types.FilePath, content []byte, environment string, vals map[string]interface{}) ([]byte, error) {
+	tmpl, err := template.New(file.RelativePath).Funcs(helmfileTemplateFuncs(root, file)).Option("missingkey=zero").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]interface{}{
+		"Environment": map[string]interface{}{"Name": environment, "Values": vals},
+		"Values":      vals,
+		"StateValues": vals,
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// scanRoot returns the directory a file was found in by the scan: its full path without its relative path.
+func scanRoot(file types.FilePath) string {
+	fullPath := filepath.Clean(file.FullPath)
+	root, ok := strings.CutSuffix(fullPath, filepath.FromSlash(path.Clean(file.RelativePath)))
+	if ok && (root == "" || strings.HasSuffix(root, string(filepath.Separator))) {
+		return filepath.Clean(root)
+	}
+	return filepath.Dir(fullPath)
+}
+
+// readScanFile reads a file referenced by a Helmfile when it is inside root, so that a Helmfile cannot
+// read files of the host running the scan.
+func readScanFile(root string, file types.FilePath) ([]byte, error) {
+	filePath, err := scanFilePath(root, file)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filePath)
+}
+
+// scanFilePath returns the path of a file referenced by a Helmfile with its symbolic links resolved,
+// or an error when it is outside of root.
+func scanFilePath(root string, file types.FilePath) (string, error) {
+	rootPath, err := resolvedPath(root)
+	if err != nil {
+		return "", err
+	}
+	filePath, err := resolvedPath(file.FullPath)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(rootPath, filePath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the scanned directory", file.RelativePath)
+	}
+	return filePath, nil
+}
+
+func resolvedPath(filePath string) (string, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(absPath)
+}
+
+// parseYAMLDocuments parses every document of a YAML stream, up to the first that does not parse.
+func parseYAMLDocuments(content []byte) []*yaml.Node {
+	var documents []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var document yaml.Node
+		if err := decoder.Decode(&document); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Debug().Msgf("Could not parse yaml document err: %+v", err)
+			}
+			return documents
+		}
+		documents = append(documents, resolveAlias(firstContent(&document)))
+	}
+}
+
+// resolveSequence returns the items of a sequence node, or nil when it is not one.
+func resolveSequence(node *yaml.Node) []*yaml.Node {
+	node = resolveAlias(node)
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
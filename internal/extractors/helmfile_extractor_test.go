@@ -0,0 +1,242 @@
+package extractors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
+)
+
+var testHelmfiles = []types.FilePath{
+	{FullPath: "../../test_files/helmfile-testcases/helmfile.yaml", RelativePath: "helmfile.yaml"},
+}
+
+// helmfileImage identifies an image found in a Helmfile by the release and environment it was rendered for.
+type helmfileImage struct {
+	Name, Release, Environment string
+}
+
+func helmfileImages(images []ImageDetails) []helmfileImage {
+	var result []helmfileImage
+	for _, image := range images {
+		result = append(result, helmfileImage{image.Name, image.Release, image.Environment})
+	}
+	return result
+}
+
+func TestIsHelmfile(t *testing.T) {
+	tests := map[string]bool{
+		"helmfile.yaml":                  true,
+		"deploy/helmfile.yml":            true,
+		"helmfile.yaml.gotmpl":           true,
+		"helmfile.d/00-base.yaml":        true,
+		"helmfile.d/10-apps.yaml.gotmpl": true,
+		"helmfile.d/README.md":           false,
+		"values.yaml":                    false,
+		"charts/helmfile.yaml.bak":       false,
+	}
+	for filePath, expected := range tests {
+		if IsHelmfile(filePath) != expected {
+			t.Errorf("Expected IsHelmfile(%s) to be %v", filePath, expected)
+		}
+	}
+}
+
+func TestExtractImageDetailsFromHelmfiles(t *testing.T) {
+	var diagnostics []Diagnostic
+	images, err := ExtractImageDetailsFromHelmfiles(testHelmfiles, HelmOptions{}, func(diagnostic Diagnostic) {
+		diagnostics = append(diagnostics, diagnostic)
+	})
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	expected := []helmfileImage{
+		{"ghcr.io/org/shop:1.0.0", "shop", "default"},
+		{"registry.example.com/shop:2.0.0", "shop", "prod"},
+		{"prom/statsd-exporter:v0.26.0", "metrics", "prod"},
+	}
+	if found := helmfileImages(images); !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected images %+v but got %+v", expected, found)
+	}
+	if location := images[0].ImageLocations[0]; location.Path != "shop/templates/deployment.yaml" {
+		t.Errorf("Expected the image to be located at its template but got %+v", location)
+	}
+
+	expectedDiagnostics := []Diagnostic{
+		{Severity: DiagnosticWarning, Path: "helmfile.yaml", Line: 35,
+			Message: "release redis uses the chart bitnami/redis, which is not available locally and is not fetched"},
+	}
+	if !reflect.DeepEqual(diagnostics, expectedDiagnostics) {
+		t.Errorf("Expected diagnostics %+v but got %+v", expectedDiagnostics, diagnostics)
+	}
+}
+
+func TestExtractImageDetailsFromHelmfiles_EnvironmentFunctions(t *testing.T) {
+	helmfiles := []types.FilePath{
+		{FullPath: "../../test_files/helmfile-env/helmfile.yaml", RelativePath: "helmfile.yaml"},
+		{FullPath: "../../test_files/helmfile-env/broken/helmfile.yaml", RelativePath: "broken/helmfile.yaml"},
+	}
+
+	var diagnostics []Diagnostic
+	images, err := ExtractImageDetailsFromHelmfiles(helmfiles, HelmOptions{}, func(diagnostic Diagnostic) {
+		diagnostics = append(diagnostics, diagnostic)
+	})
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	// env and requiredEnv do not read the environment of the scan, and do not fail the render
+	expected := []helmfileImage{{"ghcr.io/org/web:1.0.0", "web", "default"}}
+	if found := helmfileImages(images); !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected images %+v but got %+v", expected, found)
+	}
+
+	expectedDiagnostics := []Diagnostic{
+		{Severity: DiagnosticError, Path: "broken/helmfile.yaml", Line: 2,
+			Message: "could not render the helmfile in environment default: template: broken/helmfile.yaml:3:18: executing \"broken/helmfile.yaml\" at <required \"cluster is required\" .Values.cluster>: error calling required: cluster is required"},
+	}
+	if !reflect.DeepEqual(diagnostics, expectedDiagnostics) {
+		t.Errorf("Expected diagnostics %+v but got %+v", expectedDiagnostics, diagnostics)
+	}
+}
+
+func TestExtractImageDetailsFromHelmfiles_SubchartsPerEnvironment(t *testing.T) {
+	helmfiles := []types.FilePath{
+		{FullPath: "../../test_files/helmfile-subcharts/helmfile.yaml", RelativePath: "helmfile.yaml"},
+	}
+
+	images, err := ExtractImageDetailsFromHelmfiles(helmfiles, HelmOptions{RenderAllBranches: true}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+	expected := []helmfileImage{
+		{"ghcr.io/org/platform:1.0.0", "platform", "default"},
+		{"quay.io/prometheus/node-exporter:v1.8.0", "platform", "prod"},
+		{"ghcr.io/org/platform:1.0.0", "platform", "prod"},
+	}
+	if found := helmfileImages(images); !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected images %+v but got %+v", expected, found)
+	}
+}
+
+func TestResolveHelmfileChart(t *testing.T) {
+	helmfile := testHelmfiles[0]
+	tests := map[string]bool{
+		"./charts/shop":                 true,
+		"charts/shop":                   true,
+		"bitnami/redis":                 false,
+		"oci://ghcr.io/org/charts/shop": false,
+		"./charts/missing":              false,
+		"../helm-environments":          false,
+	}
+	for chart, expected := range tests {
+		if _, ok := resolveHelmfileChart(helmfile, chart, []string{"bitnami"}); ok != expected {
+			t.Errorf("Expected the chart %s to be resolved %v", chart, expected)
+		}
+	}
+}
+
+func TestExtractImageDetailsWithValuesLocationsFromHelmfiles(t *testing.T) {
+	images, err := ExtractImageDetailsWithValuesLocationsFromHelmfiles(testHelmfiles, HelmOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	type location struct {
+		Name, Release, Environment, ValuesKey, Path string
+		Line                                        int
+	}
+	var found []location
+	for _, image := range images {
+		found = append(found, location{image.Name, image.Release, image.Environment, image.ValuesKey,
+			image.ImageLocations[0].Path, image.ImageLocations[0].Line})
+	}
+	expected := []location{
+		{"ghcr.io/org/shop:1.0.0", "shop", "default", "image.repository", "values/shop.yaml", 1},
+		{"registry.example.com/shop:2.0.0", "shop", "prod", "image.repository", "values/shop-prod.yaml", 1},
+		{"prom/statsd-exporter:v0.26.0", "metrics", "prod", "image.repository", "helmfile.yaml", 31},
+		{"prom/statsd-exporter:v0.26.0", "metrics", "prod", "image.tag", "helmfile.yaml", 32},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected locations %+v but got %+v", expected, found)
+	}
+}
+
+func TestHelmfileSetOverride(t *testing.T) {
+	tests := map[string]string{
+		"{name: image.tag, value: 1.2.3}":  "image.tag=1.2.3",
+		"{name: args, values: [a, 'b,c']}": `args={a,b\,c}`,
+		"{name: path, value: 'C:\\dir'}":   `path=C:\\dir`,
+		"{value: 1.2.3}":                   "",
+	}
+	for set, expected := range tests {
+		node := parseYAMLDocuments([]byte(set))[0]
+		if override := helmfileSetOverride(node); override != expected {
+			t.Errorf("Expected %s to be the override %s but got %s", set, expected, override)
+		}
+	}
+}
+
+func TestRenderHelmfileTemplate_Sandboxed(t *testing.T) {
+	t.Setenv("CX_HELMFILE_SECRET", "secret")
+	helmfile := testHelmfiles[0]
+	root := scanRoot(helmfile)
+
+	tests := []struct {
+		name, template, expected string
+		fails                    bool
+	}{
+		{"ReadFileInsideRoot", `{{ readFile "environments/prod.yaml" | trim }}`, "shopTag: 2.0.0\nreplicas: 3", false},
+		{"ReadFileOutsideRoot", `{{ readFile "../helm-environments/values.yaml" }}`, "", true},
+		{"ReadFileAbsolute", `{{ readFile "/etc/hostname" }}`, "", true},
+		{"Env", `{{ env "CX_HELMFILE_SECRET" | default "apps" }}`, "apps", false},
+		{"ExpandEnv", `{{ expandenv "$CX_HELMFILE_SECRET" }}`, "", false},
+		{"GetHostByName", `{{ getHostByName "example.com" }}`, "", false},
+		{"RequiredEnv", `{{ requiredEnv "CX_HELMFILE_SECRET" }}`, helmRequiredPlaceholder, false},
+		{"Exec", `{{ exec "id" (list) }}`, "", false},
+		{"IsFileInsideRoot", `{{ isFile "environments/prod.yaml" }}`, "true", false},
+		{"IsFileOutsideRoot", `{{ isFile "../helm-environments/values.yaml" }}`, "false", false},
+		{"Get", `{{ get "image.tag" .Values }} {{ get "image.digest" "none" .Values }}`, "1.0 none", false},
+		{"GetMissing", `{{ get "image.digest" .Values }}`, "", true},
+		{"GetOrNil", `{{ getOrNil "image.digest" .Values | default "none" }}`, "none", false},
+		{"Required", `{{ required "image.tag is required" .Values.image.tag }}`, "1.0", false},
+		{"RequiredMissing", `{{ required "image.digest is required" .Values.image.digest }}`, "", true},
+		{"FromYamlToYaml", `{{ "image: {tag: \"2.0\"}" | fromYaml | toYaml }}`, "image:\n    tag: \"2.0\"", false},
+		{"SetValueAtPath", `{{ (setValueAtPath "image.tag" "3.0" .Values).image.tag }}`, "3.0", false},
+		{"Tpl", `{{ tpl "{{ .Environment.Name }}" . }}`, "prod", false},
+		{"Sprig", `{{ .Environment.Name | upper }}`, "PROD", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vals := map[string]interface{}{"image": map[string]interface{}{"tag": "1.0"}}
+			out, err := renderHelmfileTemplate(root, helmfile, []byte(test.template), "prod", vals)
+			if test.fails {
+				if err == nil {
+					t.Errorf("Expected the template to fail but it rendered %q", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if string(out) != test.expected {
+				t.Errorf("Expected %q but got %q", test.expected, out)
+			}
+		})
+	}
+}
+
+func TestScanRoot(t *testing.T) {
+	tests := map[types.FilePath]string{
+		{FullPath: "/scan/deploy/helmfile.yaml", RelativePath: "deploy/helmfile.yaml"}:   "/scan",
+		{FullPath: "/scan/helmfile.yaml", RelativePath: "helmfile.yaml"}:                 "/scan",
+		{FullPath: "/scan/myhelmfile.yaml", RelativePath: "helmfile.yaml"}:               "/scan",
+		{FullPath: "../scan/helmfile.d/apps.yaml", RelativePath: "helmfile.d/apps.yaml"}: "../scan",
+	}
+	for file, expected := range tests {
+		if root := scanRoot(file); root != expected {
+			t.Errorf("Expected the scan root of %+v to be %s but got %s", file, expected, root)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package extractors
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/Masterminds/sprig/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// helmfileTemplateFuncs returns the functions of the templates of a Helmfile, or of a .gotmpl values
+// file of its releases: the sprig functions and the helmfile ones. As the scanned repository is not
+// trusted, the functions reaching out of it are stubbed like helm does: env, expandenv and
+// getHostByName return an empty string, requiredEnv returns helmRequiredPlaceholder, exec runs nothing,
+// and files are only read inside root, the directory being scanned.
+func helmfileTemplateFuncs(root string, file types.FilePath) template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["env"] = func(string) string { return "" }
+	funcs["expandenv"] = func(value string) string {
+		return os.Expand(value, func(string) string { return "" })
+	}
+	funcs["getHostByName"] = func(string) string { return "" }
+	funcs["requiredEnv"] = func(string) string { return helmRequiredPlaceholder }
+	funcs["exec"] = func(string, ...interface{}) string { return "" }
+
+	funcs["get"] = func(path string, args ...interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("get expects 2 or 3 arguments, got %d", len(args)+1)
+		}
+		value, ok := helmfileValueAtPath(args[len(args)-1], path)
+		if ok {
+			return value, nil
+		}
+		if len(args) == 2 {
+			return args[0], nil
+		}
+		return nil, fmt.Errorf("no value exists at path %s", path)
+	}
+	funcs["getOrNil"] = func(path string, values interface{}) interface{} {
+		value, _ := helmfileValueAtPath(values, path)
+		return value
+	}
+	funcs["required"] = func(message string, value interface{}) (interface{}, error) {
+		if value == nil {
+			return nil, fmt.Errorf("%s", message)
+		}
+		if s, ok := value.(string); ok && s == "" {
+			return nil, fmt.Errorf("%s", message)
+		}
+		return value, nil
+	}
+	funcs["setValueAtPath"] = func(path string, value interface{}, values map[string]interface{}) (map[string]interface{}, error) {
+		keys := strings.Split(path, ".")
+		current := values
+		for _, key := range keys[:len(keys)-1] {
+			next, ok := current[key].(map[string]interface{})
+			if !ok {
+				if current[key] != nil {
+					return nil, fmt.Errorf("cannot set %s: %s is not a map", path, key)
+				}
+				next = make(map[string]interface{})
+				current[key] = next
+			}
+			current = next
+		}
+		current[keys[len(keys)-1]] = value
+		return values, nil
+	}
+	funcs["fromYaml"] = func(content string) (map[string]interface{}, error) {
+		values := make(map[string]interface{})
+		err := yaml.Unmarshal([]byte(content), &values)
+		return values, err
+	}
+	funcs["toYaml"] = func(value interface{}) (string, error) {
+		out, err := yaml.Marshal(value)
+		return strings.TrimSuffix(string(out), "\n"), err
+	}
+	funcs["readFile"] = func(name string) (string, error) {
+		out, err := readScanFile(root, relatedFile(file, name))
+		return string(out), err
+	}
+	funcs["isFile"] = func(name string) bool {
+		filePath, err := scanFilePath(root, relatedFile(file, name))
+		if err != nil {
+			return false
+		}
+		info, err := os.Stat(filePath)
+		return err == nil && info.Mode().IsRegular()
+	}
+	funcs["tpl"] = func(text string, data interface{}) (string, error) {
+		tmpl, err := template.New(file.RelativePath).Funcs(funcs).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return "", err
+		}
+		var out bytes.Buffer
+		err = tmpl.Execute(&out, data)
+		return out.String(), err
+	}
+	return funcs
+}
+
+// helmfileValueAtPath returns the value at a dot separated path of values, such as image.tag, and
+// whether it exists. An empty path is values itself.
+func helmfileValueAtPath(values interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return values, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		var ok bool
+		switch current := values.(type) {
+		case map[string]interface{}:
+			values, ok = current[key]
+		case map[interface{}]interface{}:
+			values, ok = current[key]
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return values, true
+}
@@ -0,0 +1,101 @@
+package extractors
+
+import "github.com/Checkmarx/containers-types/types"
+
+// DockerfileSyntax is the Usage of the BuildKit frontend image selected by a # syntax= directive.
+const DockerfileSyntax = "syntax"
+
+// ImageDetails is a single occurrence of an image, with the metadata of that occurrence that
+// types.ImageModel cannot carry, grouped by the kind of file it comes from. Its ImageModel always
+// holds exactly one ImageLocation. The fields of the groups are promoted, and serialized flat.
+type ImageDetails struct {
+	types.ImageModel
+	DockerfileDetails
+	ComposeDetails
+	KubernetesDetails
+	HelmDetails
+	KustomizeDetails
+}
+
+// DockerfileDetails are the details of an image used by a Dockerfile.
+type DockerfileDetails struct {
+	// Platform is the platform the image is pulled for, such as linux/arm64. It is empty when the
+	// file does not pin a platform and none was supplied by the caller, or when the platform the
+	// file pins refers to a variable that is not set.
+	Platform string `json:"platform,omitempty"`
+	// Usage tells how a Dockerfile uses the image: one of the DockerfileEdge kinds, or DockerfileSyntax.
+	Usage string `json:"usage,omitempty"`
+}
+
+// ComposeDetails are the details of an image used by a compose service.
+type ComposeDetails struct {
+	// Service is the compose service using the image, either as its image or as a base image of the
+	// Dockerfile it builds.
+	Service string `json:"service,omitempty"`
+	// Profiles are the compose profiles enabling the service, empty when the service is always enabled.
+	Profiles []string `json:"profiles,omitempty"`
+}
+
+// KubernetesDetails are the details of an image used by a Kubernetes workload, from a manifest, a
+// Kustomize overlay or a rendered Helm chart.
+type KubernetesDetails struct {
+	// Kind, Workload and Container are the kind and name of the Kubernetes resource using the image,
+	// and the name of the container of its pod spec running it.
+	Kind      string `json:"kind,omitempty"`
+	Workload  string `json:"workload,omitempty"`
+	Container string `json:"container,omitempty"`
+}
+
+// HelmDetails are the details of an image rendered from a Helm chart or a Helmfile release.
+type HelmDetails struct {
+	// ValuesKey is the key of the Helm values file the location points at, such as image.tag.
+	ValuesKey string `json:"valuesKey,omitempty"`
+	// Subchart is the path of the Helm subchart whose templates rendered the image, such as redis or
+	// backend/redis, empty for the templates of the chart itself.
+	Subchart string `json:"subchart,omitempty"`
+	// Environment is the values-<environment>.yaml file a Helm chart was rendered with to find the
+	// image, empty for its default values, or the Helmfile environment its release was rendered in.
+	Environment string `json:"environment,omitempty"`
+	// Release is the Helmfile release whose chart rendered the image.
+	Release string `json:"release,omitempty"`
+}
+
+// KustomizeDetails are the details of an image found by building a Kustomize overlay.
+type KustomizeDetails struct {
+	// Kustomization is the overlay built to find the image, Overlay the kustomization, that overlay or
+	// one of its bases, whose images entry set it, and Manifest the file declaring its container.
+	Kustomization string `json:"kustomization,omitempty"`
+	Overlay       string `json:"overlay,omitempty"`
+	Manifest      string `json:"manifest,omitempty"`
+}
+
+// splitImageDetails splits images with several locations into one ImageDetails per location.
+func splitImageDetails(images []ImageDetails) []ImageDetails {
+	var details []ImageDetails
+	for _, image := range images {
+		for _, location := range image.ImageLocations {
+			detail := image
+			detail.ImageLocations = []types.ImageLocation{location}
+			details = append(details, detail)
+		}
+	}
+	return details
+}
+
+// ImageDetailsOf splits images into one ImageDetails per location, for sources that carry no details.
+func ImageDetailsOf(images []types.ImageModel) []ImageDetails {
+	var details []ImageDetails
+	for _, image := range images {
+		details = append(details, ImageDetails{ImageModel: image})
+	}
+	return splitImageDetails(details)
+}
+
+// ImageModels drops the details of images, keeping their name and location.
+func ImageModels(details []ImageDetails) []types.ImageModel {
+	var images []types.ImageModel
+	for _, detail := range details {
+		images = append(images, detail.ImageModel)
+	}
+	return images
+}
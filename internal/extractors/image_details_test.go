@@ -0,0 +1,42 @@
+package extractors
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
+)
+
+func TestImageDetailsOf(t *testing.T) {
+	images := []types.ImageModel{
+		{Name: "nginx:1.25", IsSha: false, ImageLocations: []types.ImageLocation{{Path: "a.yaml", Line: 1}, {Path: "b.yaml", Line: 2}}},
+	}
+	expected := []ImageDetails{
+		{ImageModel: types.ImageModel{Name: "nginx:1.25", ImageLocations: []types.ImageLocation{{Path: "a.yaml", Line: 1}}}},
+		{ImageModel: types.ImageModel{Name: "nginx:1.25", ImageLocations: []types.ImageLocation{{Path: "b.yaml", Line: 2}}}},
+	}
+	details := ImageDetailsOf(images)
+	if !reflect.DeepEqual(details, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, details)
+	}
+	if models := ImageModels(details); len(models) != 2 || models[1].ImageLocations[0].Path != "b.yaml" {
+		t.Errorf("Expected the image models of the details but got %+v", models)
+	}
+}
+
+func TestImageDetails_JSON(t *testing.T) {
+	details := ImageDetails{
+		ImageModel:        types.ImageModel{Name: "nginx:1.25"},
+		KubernetesDetails: KubernetesDetails{Kind: "Deployment", Workload: "web"},
+		HelmDetails:       HelmDetails{ValuesKey: "image.tag"},
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		t.Fatalf("Error marshalling details: %v", err)
+	}
+	expected := `{"Name":"nginx:1.25","ImageLocations":null,"IsSha":false,"kind":"Deployment","workload":"web","valuesKey":"image.tag"}`
+	if string(data) != expected {
+		t.Errorf("Expected %s but got %s", expected, data)
+	}
+}
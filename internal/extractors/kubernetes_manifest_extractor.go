@@ -0,0 +1,154 @@
+package extractors
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// KubernetesManifestOrigin is the Origin of the images found in plain Kubernetes manifests.
+const KubernetesManifestOrigin = "Kubernetes"
+
+// ExtractImagesFromKubernetesManifests extracts the container images of the workloads of Kubernetes
+// manifests, with the line and columns of each image value.
+func ExtractImagesFromKubernetesManifests(filePaths []types.FilePath) ([]types.ImageModel, error) {
+	images, err := ExtractImageDetailsFromKubernetesManifests(filePaths)
+	return ImageModels(images), err
+}
+
+// ExtractImageDetailsFromKubernetesManifests is ExtractImagesFromKubernetesManifests returning every
+// image with the workload and container using it.
+func ExtractImageDetailsFromKubernetesManifests(filePaths []types.FilePath) ([]ImageDetails, error) {
+	var imageNames []ImageDetails
+
+	for _, filePath := range filePaths {
+		log.Debug().Msgf("going to extract images from kubernetes manifest %s", filePath.FullPath)
+		fileImages, err := extractImagesFromKubernetesManifest(filePath)
+		if err != nil {
+			log.Warn().Msgf("could not extract images from kubernetes manifest %s err: %+v", filePath.RelativePath, err)
+			continue
+		}
+		printFoundImagesInFile(filePath.RelativePath, ImageModels(fileImages))
+		imageNames = append(imageNames, fileImages...)
+	}
+
+	return imageNames, nil
+}
+
+func extractImagesFromKubernetesManifest(filePath types.FilePath) ([]ImageDetails, error) {
+	documents, err := readYAMLDocuments(filePath.FullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []ImageDetails
+	for _, document := range documents {
+		for _, resource := range kubernetesResources(resolveAlias(firstContent(document))) {
+			for _, image := range workloadImages(resource) {
+				line, start, end := scalarSpan(image.node)
+				images = append(images, ImageDetails{
+					ImageModel: types.ImageModel{
+						Name: image.node.Value,
+						ImageLocations: []types.ImageLocation{{
+							Origin:     KubernetesManifestOrigin,
+							Path:       filePath.RelativePath,
+							Line:       line,
+							StartIndex: start,
+							EndIndex:   end,
+						}},
+					},
+					KubernetesDetails: image.details(),
+				})
+			}
+		}
+	}
+	return images, nil
+}
+
+// IsKubernetesManifest reports whether a file is YAML holding at least one Kubernetes resource, a
+// document with a top level apiVersion and kind. The file is scanned line by line rather than
+// parsed, so that it is parsed only once, when its images are extracted, where files that are not
+// valid YAML are skipped.
+func IsKubernetesManifest(filePath string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Warn().Msgf("Could not close file: %s err: %+v", file.Name(), err)
+		}
+	}(file)
+
+	scanner := bufio.NewScanner(file)
+	keys := make(map[string]bool)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "---") {
+			clear(keys)
+			continue
+		}
+		if match := manifestKeyPattern.FindStringSubmatch(line); match != nil {
+			keys[match[1]] = true
+			if keys["apiVersion"] && keys["kind"] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// manifestKeyPattern matches a top level apiVersion or kind key with a value.
+var manifestKeyPattern = regexp.MustCompile(`^["']?(apiVersion|kind)["']?[ \t]*:[ \t]*[^\s#]`)
+
+// kubernetesResources returns the resource a document holds, or the items of a List kind such as
+// List or DeploymentList.
+func kubernetesResources(resource *yaml.Node) []*yaml.Node {
+	if !strings.HasSuffix(scalarValue(mappingValue(resource, "kind")), "List") {
+		return []*yaml.Node{resource}
+	}
+	items := mappingValue(resource, "items")
+	if items == nil || items.Kind != yaml.SequenceNode {
+		return nil
+	}
+	var resources []*yaml.Node
+	for _, item := range items.Content {
+		resources = append(resources, kubernetesResources(resolveAlias(item))...)
+	}
+	return resources
+}
+
+// readYAMLDocuments parses every document of a multi-document YAML file.
+func readYAMLDocuments(filePath string) ([]*yaml.Node, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Warn().Msgf("Could not close file: %s err: %+v", file.Name(), err)
+		}
+	}(file)
+
+	var documents []*yaml.Node
+	decoder := yaml.NewDecoder(file)
+	for {
+		var document yaml.Node
+		if err := decoder.Decode(&document); err != nil {
+			if errors.Is(err, io.EOF) {
+				return documents, nil
+			}
+			return nil, err
+		}
+		documents = append(documents, &document)
+	}
+}
@@ -0,0 +1,57 @@
+package extractors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
+)
+
+func TestExtractImageDetailsFromKubernetesManifests(t *testing.T) {
+	filePaths := []types.FilePath{
+		{FullPath: "../../test_files/k8s-manifests/k8s/deployment.yaml", RelativePath: "k8s/deployment.yaml"},
+		{FullPath: "../../test_files/k8s-manifests/k8s/list.yaml", RelativePath: "k8s/list.yaml"},
+		{FullPath: "../../test_files/k8s-manifests/k8s/configmap.yml", RelativePath: "k8s/configmap.yml"},
+		{FullPath: "../../test_files/k8s-manifests/k8s/missing.yaml", RelativePath: "k8s/missing.yaml"},
+	}
+
+	images, err := ExtractImageDetailsFromKubernetesManifests(filePaths)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	image := func(name, path string, line, start, end int, kind, workload, container string) ImageDetails {
+		return ImageDetails{
+			ImageModel: types.ImageModel{Name: name, ImageLocations: []types.ImageLocation{
+				{Origin: KubernetesManifestOrigin, Path: path, Line: line, StartIndex: start, EndIndex: end},
+			}},
+			KubernetesDetails: KubernetesDetails{Kind: kind, Workload: workload, Container: container},
+		}
+	}
+	expected := []ImageDetails{
+		image("ghcr.io/org/migrations:1.1", "k8s/deployment.yaml", 10, 18, 44, "Deployment", "api", "migrate"),
+		image("ghcr.io/org/api:2.0", "k8s/deployment.yaml", 13, 17, 36, "Deployment", "api", "api"),
+		image("ghcr.io/org/backup:1.0", "k8s/list.yaml", 14, 26, 48, "CronJob", "backup", "backup"),
+		image("busybox:1.36", "k8s/list.yaml", 22, 17, 29, "Pod", "debug", "shell"),
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
+
+func TestIsKubernetesManifest(t *testing.T) {
+	tests := map[string]bool{
+		"../../test_files/k8s-manifests/k8s/deployment.yaml":     true,
+		"../../test_files/k8s-manifests/k8s/list.yaml":           true,
+		"../../test_files/k8s-manifests/k8s/configmap.yml":       true,
+		"../../test_files/k8s-manifests/config/settings.yaml":    false,
+		"../../test_files/k8s-manifests/config/split.yaml":       false,
+		"../../test_files/k8s-manifests/chart/Chart.yaml":        false,
+		"../../test_files/helm-testcases/templates/invalid.yaml": false,
+	}
+	for filePath, expected := range tests {
+		if IsKubernetesManifest(filePath) != expected {
+			t.Errorf("Expected IsKubernetesManifest(%s) to be %v", filePath, expected)
+		}
+	}
+}
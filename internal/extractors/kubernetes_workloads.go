@@ -0,0 +1,71 @@
+package extractors
+
+import "gopkg.in/yaml.v3"
+
+// podSpecPaths are the paths from the spec of each workload kind to its pod spec.
+var podSpecPaths = map[string][]string{
+	"Pod":                   {"spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// podContainerFields are the container lists of a pod spec.
+var podContainerFields = []string{"initContainers", "containers", "ephemeralContainers"}
+
+// workloadImage is the image of a container of a Kubernetes workload.
+type workloadImage struct {
+	kind      string
+	name      string
+	container string
+	// node is the image scalar of the container.
+	node *yaml.Node
+}
+
+// details returns the workload and container using the image.
+func (w workloadImage) details() KubernetesDetails {
+	return KubernetesDetails{Kind: w.kind, Workload: w.name, Container: w.container}
+}
+
+// workloadImages returns the images of the containers of a Kubernetes workload document, or nothing
+// when the document is not a workload.
+func workloadImages(document *yaml.Node) []workloadImage {
+	kind := scalarValue(mappingValue(document, "kind"))
+	path, ok := podSpecPaths[kind]
+	if !ok {
+		return nil
+	}
+
+	podSpec := document
+	for _, key := range path {
+		if podSpec = mappingValue(podSpec, key); podSpec == nil {
+			return nil
+		}
+	}
+
+	name := scalarValue(mappingValue(mappingValue(document, "metadata"), "name"))
+	var images []workloadImage
+	for _, field := range podContainerFields {
+		containers := mappingValue(podSpec, field)
+		if containers == nil || containers.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, container := range containers.Content {
+			image := scalarNode(mappingValue(container, "image"))
+			if image == nil || image.Value == "" {
+				continue
+			}
+			images = append(images, workloadImage{
+				kind:      kind,
+				name:      name,
+				container: scalarValue(mappingValue(container, "name")),
+				node:      image,
+			})
+		}
+	}
+	return images
+}
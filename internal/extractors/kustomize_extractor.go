@@ -0,0 +1,407 @@
+package extractors
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Checkmarx/containers-types/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/pkg/util"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// KustomizeOrigin is the Origin of the images found by building Kustomize overlays.
+const KustomizeOrigin = "Kustomize"
+
+// kustomizeOriginAnnotation is the annotation a build records the file each resource comes from in.
+const kustomizeOriginAnnotation = "config.kubernetes.io/origin"
+
+// kustomization is a kustomization file, with the fields that decide the images of its build.
+type kustomization struct {
+	file types.FilePath
+	// resources are its resources, bases and components, relative to its directory.
+	resources []string
+	images    []kustomizeImage
+}
+
+// kustomizeImage is an entry of the images field of a kustomization.
+type kustomizeImage struct {
+	name, newName, newTag, digest, tagSuffix string
+	// node is the scalar of the entry that changes the image: its digest, newTag, tagSuffix or newName.
+	node *yaml.Node
+}
+
+// ExtractImagesFromKustomizations builds Kustomize overlays offline and extracts the images of their
+// workloads, after the images entries of the overlays and their bases are applied.
+func ExtractImagesFromKustomizations(kustomizations []types.FilePath) ([]types.ImageModel, error) {
+	images, err := ExtractImageDetailsFromKustomizations(kustomizations)
+	return ImageModels(images), err
+}
+
+// ExtractImageDetailsFromKustomizations is ExtractImagesFromKustomizations returning every image with
+// the overlay it was built from, the kustomization that set it and the manifest declaring its container.
+func ExtractImageDetailsFromKustomizations(kustomizations []types.FilePath) ([]ImageDetails, error) {
+	var imageNames []ImageDetails
+
+	for _, filePath := range kustomizations {
+		log.Debug().Msgf("going to build kustomization %s", filePath.FullPath)
+		images, err := extractImagesFromKustomization(filePath)
+		if err != nil {
+			log.Warn().Msgf("could not build kustomization %s err: %+v", filePath.RelativePath, err)
+			continue
+		}
+		printFoundImagesInFile(filePath.RelativePath, ImageModels(images))
+		imageNames = append(imageNames, images...)
+	}
+
+	return imageNames, nil
+}
+
+func extractImagesFromKustomization(filePath types.FilePath) ([]ImageDetails, error) {
+	root, err := readKustomization(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLocalKustomization(root, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+
+	rootDir, err := filepath.Abs(filepath.Dir(filePath.FullPath))
+	if err != nil {
+		return nil, err
+	}
+	rootFile, err := filepath.EvalSymlinks(filepath.Join(rootDir, filepath.Base(filePath.FullPath)))
+	if err != nil {
+		return nil, err
+	}
+
+	fSys := kustomizeFileSystem{FileSystem: filesys.MakeFsOnDisk(), root: rootFile}
+	resources, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fSys, rootDir)
+	if err != nil {
+		return nil, err
+	}
+	output, err := resources.AsYaml()
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(strings.NewReader(string(output)))
+	var images []ImageDetails
+	for {
+		var document yaml.Node
+		if decoder.Decode(&document) != nil {
+			break
+		}
+		resource := firstContent(&document)
+		manifest := kustomizeResourceOrigin(resource)
+		for _, image := range workloadImages(resource) {
+			images = append(images, kustomizeImageDetails(root, manifest, image))
+		}
+	}
+	return images, nil
+}
+
+// kustomizeImageDetails locates a built image at the images entry that set it, or at the manifest
+// declaring its container when no images entry changed it. manifest is the path of that manifest
+// relative to the overlay, empty when the build did not record it.
+func kustomizeImageDetails(root *kustomization, manifest string, image workloadImage) ImageDetails {
+	details := ImageDetails{
+		ImageModel: types.ImageModel{
+			Name: image.node.Value,
+			ImageLocations: []types.ImageLocation{{
+				Origin:     KustomizeOrigin,
+				Path:       root.file.RelativePath,
+				Line:       -1,
+				StartIndex: -1,
+				EndIndex:   -1,
+			}},
+		},
+		KubernetesDetails: image.details(),
+		KustomizeDetails:  KustomizeDetails{Kustomization: root.file.RelativePath},
+	}
+	if manifest == "" {
+		return details
+	}
+
+	manifestFile := relatedFile(root.file, manifest)
+	details.Manifest = manifestFile.RelativePath
+	declared := declaredContainerImage(manifestFile.FullPath, image)
+	chain := root.chain(manifestFile.FullPath)
+	if declared == nil || chain == nil {
+		return details
+	}
+
+	name, setter, entry := declared.Value, (*kustomization)(nil), (*kustomizeImage)(nil)
+	for i := len(chain) - 1; i >= 0; i-- {
+		for j := range chain[i].images {
+			if applied, ok := chain[i].images[j].apply(name); ok {
+				name, setter, entry = applied, chain[i], &chain[i].images[j]
+			}
+		}
+	}
+	if name != image.node.Value {
+		// The image was changed by something other than an images entry, such as a patch.
+		return details
+	}
+
+	location := &details.ImageLocations[0]
+	if setter != nil {
+		details.Overlay = setter.file.RelativePath
+		location.Path = setter.file.RelativePath
+		location.Line, location.StartIndex, location.EndIndex = scalarSpan(entry.node)
+	} else {
+		location.Path = manifestFile.RelativePath
+		location.Line, location.StartIndex, location.EndIndex = scalarSpan(declared)
+	}
+	return details
+}
+
+// apply returns the image the entry rewrites name to, and false when the entry does not match name.
+func (i kustomizeImage) apply(image string) (string, bool) {
+	name, tag, digest := util.SplitImageName(image)
+	if name != i.name {
+		return image, false
+	}
+	if i.newName != "" {
+		name = i.newName
+	}
+	switch {
+	case i.newTag != "" && i.digest != "":
+		tag, digest = i.newTag, i.digest
+	case i.newTag != "":
+		tag, digest = i.newTag, ""
+	case i.digest != "":
+		tag, digest = "", i.digest
+	case i.tagSuffix != "":
+		tag, digest = tag+i.tagSuffix, ""
+	}
+	if tag != "" {
+		name += ":" + tag
+	}
+	if digest != "" {
+		name += "@" + digest
+	}
+	return name, true
+}
+
+// declaredContainerImage returns the image scalar of the container of a manifest that a built image
+// comes from. Overlays may prefix or suffix workload names, so a workload whose name is part of the
+// built name matches.
+func declaredContainerImage(manifest string, built workloadImage) *yaml.Node {
+	documents, err := readYAMLDocuments(manifest)
+	if err != nil {
+		return nil
+	}
+	var match *yaml.Node
+	for _, document := range documents {
+		for _, resource := range kubernetesResources(resolveAlias(firstContent(document))) {
+			for _, image := range workloadImages(resource) {
+				if image.kind != built.kind || image.container != built.container || !strings.Contains(built.name, image.name) {
+					continue
+				}
+				if image.name == built.name {
+					return image.node
+				}
+				if match == nil {
+					match = image.node
+				}
+			}
+		}
+	}
+	return match
+}
+
+// chain returns the kustomizations from k down to the one listing manifest as a resource, or nil when
+// manifest is not a resource of k or of its bases.
+func (k *kustomization) chain(manifest string) []*kustomization {
+	dir := filepath.Dir(k.file.FullPath)
+	for _, resource := range k.resources {
+		resourcePath := filepath.Join(dir, resource)
+		if resourcePath == filepath.Clean(manifest) {
+			return []*kustomization{k}
+		}
+		base := findKustomization(relatedFile(k.file, resource))
+		if base == nil {
+			continue
+		}
+		if chain := base.chain(manifest); chain != nil {
+			return append([]*kustomization{k}, chain...)
+		}
+	}
+	return nil
+}
+
+// checkLocalKustomization fails when a kustomization or one of its bases lists a resource that is not
+// on disk, which a build would try to fetch.
+func checkLocalKustomization(k *kustomization, visited map[string]bool) error {
+	if visited[k.file.FullPath] {
+		return nil
+	}
+	visited[k.file.FullPath] = true
+
+	for _, resource := range k.resources {
+		resourcePath := relatedFile(k.file, resource)
+		if _, err := os.Stat(resourcePath.FullPath); err != nil {
+			return fmt.Errorf("resource %s of %s is not a local file or directory, remote resources are not fetched", resource, k.file.RelativePath)
+		}
+		if base := findKustomization(resourcePath); base != nil {
+			if err := checkLocalKustomization(base, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// KustomizationRoots returns the kustomizations that no other kustomization lists as a base or a
+// component, the overlays to build.
+func KustomizationRoots(kustomizations []types.FilePath) []types.FilePath {
+	bases := make(map[string]bool)
+	var candidates []types.FilePath
+	for _, filePath := range kustomizations {
+		k, err := readKustomization(filePath)
+		if err != nil {
+			log.Warn().Msgf("could not read kustomization %s err: %+v", filePath.RelativePath, err)
+			continue
+		}
+		for _, resource := range k.resources {
+			bases[filepath.Clean(relatedFile(k.file, resource).FullPath)] = true
+		}
+		candidates = append(candidates, filePath)
+	}
+
+	var roots []types.FilePath
+	for _, filePath := range candidates {
+		if !bases[filepath.Clean(filepath.Dir(filePath.FullPath))] {
+			roots = append(roots, filePath)
+		}
+	}
+	return roots
+}
+
+// KustomizationFileNames are the file names Kustomize reads the kustomization of a directory from.
+func KustomizationFileNames() []string {
+	return konfig.RecognizedKustomizationFileNames()
+}
+
+// findKustomization reads the kustomization of a directory, or returns nil when dir is not one.
+func findKustomization(dir types.FilePath) *kustomization {
+	for _, name := range KustomizationFileNames() {
+		file := types.FilePath{FullPath: filepath.Join(dir.FullPath, name), RelativePath: path.Join(dir.RelativePath, name)}
+		if info, err := os.Stat(file.FullPath); err != nil || info.IsDir() {
+			continue
+		}
+		k, err := readKustomization(file)
+		if err != nil {
+			log.Debug().Msgf("could not read kustomization %s err: %+v", file.RelativePath, err)
+			return nil
+		}
+		return k
+	}
+	return nil
+}
+
+func readKustomization(filePath types.FilePath) (*kustomization, error) {
+	documents, err := readYAMLDocuments(filePath.FullPath)
+	if err != nil {
+		return nil, err
+	}
+	k := &kustomization{file: filePath}
+	if len(documents) == 0 {
+		return k, nil
+	}
+
+	content := resolveAlias(firstContent(documents[0]))
+	if content == nil || content.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("kustomization %s is not a mapping", filePath.RelativePath)
+	}
+	for _, field := range []string{"resources", "bases", "components"} {
+		k.resources = append(k.resources, sequenceValues(mappingValue(content, field))...)
+	}
+
+	images := mappingValue(content, "images")
+	if images == nil || images.Kind != yaml.SequenceNode {
+		return k, nil
+	}
+	for _, entry := range images.Content {
+		image := kustomizeImage{
+			name:      scalarValue(mappingValue(entry, "name")),
+			newName:   scalarValue(mappingValue(entry, "newName")),
+			newTag:    scalarValue(mappingValue(entry, "newTag")),
+			digest:    scalarValue(mappingValue(entry, "digest")),
+			tagSuffix: scalarValue(mappingValue(entry, "tagSuffix")),
+		}
+		for _, field := range []string{"digest", "newTag", "tagSuffix", "newName", "name"} {
+			if image.node = scalarNode(mappingValue(entry, field)); image.node != nil {
+				break
+			}
+		}
+		k.images = append(k.images, image)
+	}
+	return k, nil
+}
+
+// kustomizeResourceOrigin returns the path, relative to the overlay, of the file a built resource
+// comes from, or "" when it was generated or fetched.
+func kustomizeResourceOrigin(resource *yaml.Node) string {
+	annotation := scalarValue(mappingValue(mappingValue(mappingValue(resource, "metadata"), "annotations"), kustomizeOriginAnnotation))
+	var origin struct {
+		Path string `yaml:"path"`
+		Repo string `yaml:"repo"`
+	}
+	if annotation == "" || yaml.Unmarshal([]byte(annotation), &origin) != nil || origin.Repo != "" {
+		return ""
+	}
+	return origin.Path
+}
+
+// kustomizeFileSystem reads files from disk, adding the originAnnotations build option to the root
+// kustomization so that the build records the file every resource comes from.
+type kustomizeFileSystem struct {
+	filesys.FileSystem
+	root string
+}
+
+func (fs kustomizeFileSystem) ReadFile(path string) ([]byte, error) {
+	content, err := fs.FileSystem.ReadFile(path)
+	if err != nil {
+		return content, err
+	}
+	if resolved, resolveErr := filepath.EvalSymlinks(path); resolveErr != nil || resolved != fs.root {
+		return content, nil
+	}
+	return withOriginAnnotations(content)
+}
+
+// withOriginAnnotations adds originAnnotations to the buildMetadata of a kustomization.
+func withOriginAnnotations(content []byte) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	root := firstContent(&document)
+	if root == nil || root.Kind != yaml.MappingNode {
+		return content, nil
+	}
+
+	option := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "originAnnotations"}
+	buildMetadata := mappingValue(root, "buildMetadata")
+	switch {
+	case buildMetadata == nil:
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "buildMetadata"},
+			&yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{option}})
+	case buildMetadata.Kind == yaml.SequenceNode:
+		if !slices.Contains(sequenceValues(buildMetadata), option.Value) {
+			buildMetadata.Content = append(buildMetadata.Content, option)
+		}
+	}
+	return yaml.Marshal(&document)
+}
@@ -0,0 +1,95 @@
+package extractors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Checkmarx/containers-types/types"
+)
+
+const kustomizeTestcases = "../../test_files/kustomize-testcases/"
+
+func kustomizationFile(dir string) types.FilePath {
+	return types.FilePath{FullPath: kustomizeTestcases + dir + "/kustomization.yaml", RelativePath: dir + "/kustomization.yaml"}
+}
+
+func TestKustomizationRoots(t *testing.T) {
+	kustomizations := []types.FilePath{
+		kustomizationFile("base"),
+		kustomizationFile("overlays/prod"),
+		kustomizationFile("overlays/remote"),
+		kustomizationFile("overlays/staging"),
+	}
+
+	expected := []types.FilePath{
+		kustomizationFile("overlays/prod"),
+		kustomizationFile("overlays/remote"),
+		kustomizationFile("overlays/staging"),
+	}
+	if roots := KustomizationRoots(kustomizations); !reflect.DeepEqual(roots, expected) {
+		t.Errorf("Expected roots %+v but got %+v", expected, roots)
+	}
+}
+
+func TestExtractImageDetailsFromKustomizations(t *testing.T) {
+	kustomizations := []types.FilePath{
+		kustomizationFile("overlays/prod"),
+		kustomizationFile("overlays/remote"),
+		kustomizationFile("overlays/staging"),
+	}
+
+	images, err := ExtractImageDetailsFromKustomizations(kustomizations)
+	if err != nil {
+		t.Fatalf("Error extracting images: %v", err)
+	}
+
+	image := func(name, path string, line, start, end int, kind, workload, container, overlay, setter, manifest string) ImageDetails {
+		return ImageDetails{
+			ImageModel: types.ImageModel{Name: name, ImageLocations: []types.ImageLocation{
+				{Origin: KustomizeOrigin, Path: path, Line: line, StartIndex: start, EndIndex: end},
+			}},
+			KubernetesDetails: KubernetesDetails{Kind: kind, Workload: workload, Container: container},
+			KustomizeDetails:  KustomizeDetails{Kustomization: overlay + "/kustomization.yaml", Overlay: setter, Manifest: manifest},
+		}
+	}
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	expected := []ImageDetails{
+		image("registry.example.com/api:2.1.0", "overlays/prod/kustomization.yaml", 7, 12, 17,
+			"Deployment", "prod-api", "api", "overlays/prod", "overlays/prod/kustomization.yaml", "base/deployment.yaml"),
+		image("nginx@"+digest, "overlays/prod/kustomization.yaml", 9, 12, 83,
+			"Deployment", "prod-api", "proxy", "overlays/prod", "overlays/prod/kustomization.yaml", "base/deployment.yaml"),
+		image("busybox:1.36", "base/kustomization.yaml", 7, 13, 17,
+			"CronJob", "prod-cleanup", "cleanup", "overlays/prod", "base/kustomization.yaml", "base/cronjob.yaml"),
+		image("staging.example.com/api:rc", "overlays/staging/kustomization.yaml", 7, 12, 14,
+			"Deployment", "api", "api", "overlays/staging", "overlays/staging/kustomization.yaml", "base/deployment.yaml"),
+		image("nginx:1.25", "base/deployment.yaml", 11, 17, 27,
+			"Deployment", "api", "proxy", "overlays/staging", "", "base/deployment.yaml"),
+		image("busybox:1.36", "base/kustomization.yaml", 7, 13, 17,
+			"CronJob", "cleanup", "cleanup", "overlays/staging", "base/kustomization.yaml", "base/cronjob.yaml"),
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, images)
+	}
+}
+
+func TestKustomizeImageApply(t *testing.T) {
+	tests := []struct {
+		entry    kustomizeImage
+		image    string
+		expected string
+		matched  bool
+	}{
+		{kustomizeImage{name: "nginx", newTag: "1.27"}, "nginx:1.25", "nginx:1.27", true},
+		{kustomizeImage{name: "nginx", newName: "mirror.io/nginx"}, "nginx:1.25", "mirror.io/nginx:1.25", true},
+		{kustomizeImage{name: "nginx", digest: "sha256:abc"}, "nginx:1.25", "nginx@sha256:abc", true},
+		{kustomizeImage{name: "nginx", tagSuffix: "-alpine"}, "nginx:1.25", "nginx:1.25-alpine", true},
+		{kustomizeImage{name: "localhost:5000/app", newTag: "v2"}, "localhost:5000/app:v1", "localhost:5000/app:v2", true},
+		{kustomizeImage{name: "nginx", newTag: "1.27"}, "nginx-exporter:1.0", "nginx-exporter:1.0", false},
+	}
+	for _, test := range tests {
+		image, matched := test.entry.apply(test.image)
+		if image != test.expected || matched != test.matched {
+			t.Errorf("Expected %+v to rewrite %s to %s (%v) but got %s (%v)", test.entry, test.image, test.expected, test.matched, image, matched)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package imagesExtractor
+
+import "github.com/Checkmarx/containers-images-extractor/internal/extractors"
+
+// DockerfileGraph is the multi-stage build graph of a Dockerfile: its stages, what each stage is
+// built FROM, its COPY --from and RUN --mount=from edges, and which stages the target needs.
+// It can be exported with its JSON and DOT methods.
+type DockerfileGraph = extractors.DockerfileGraph
+
+// DockerfileGraphStage is a build stage of a DockerfileGraph.
+type DockerfileGraphStage = extractors.DockerfileGraphStage
+
+// DockerfileGraphEdge is a FROM, COPY --from or RUN --mount=from edge of a DockerfileGraph.
+type DockerfileGraphEdge = extractors.DockerfileGraphEdge
+
+// Kinds of the edges of a DockerfileGraph.
+const (
+	DockerfileEdgeFrom  = extractors.DockerfileEdgeFrom
+	DockerfileEdgeCopy  = extractors.DockerfileEdgeCopy
+	DockerfileEdgeMount = extractors.DockerfileEdgeMount
+)
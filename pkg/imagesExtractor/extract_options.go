@@ -0,0 +1,62 @@
+package imagesExtractor
+
+import "github.com/Checkmarx/containers-images-extractor/internal/extractors"
+
+// ExtractOptions customizes how images are extracted from the files returned by ExtractFiles.
+type ExtractOptions struct {
+	// Dockerfiles holds the build settings of each Dockerfile, keyed by the Dockerfile RelativePath.
+	Dockerfiles map[string]DockerfileBuildOptions
+	// Compose selects the compose services to report, by active profiles or by name.
+	Compose ComposeOptions
+	// Helm sets the values files and overrides Helm charts are rendered with, whether they are
+	// rendered once per environment values file, and the cluster and release they are rendered for.
+	Helm HelmOptions
+	// TraceHelmValues makes the line-info and details extraction locate each image of a Helm chart at
+	// the values.yaml keys it is built from. Otherwise the line-info extraction scans the chart files
+	// for image: lines, and the details extraction locates each image at the template rendering it.
+	TraceHelmValues bool
+	// OnDiagnostic, when set, receives the problems found in the scanned files that kept an image
+	// from being extracted, such as a required compose variable without a value.
+	OnDiagnostic func(Diagnostic)
+}
+
+// DockerfileBuildOptions holds the docker build flags that change which images a Dockerfile pulls.
+type DockerfileBuildOptions = extractors.DockerfileBuildOptions
+
+// ComposeOptions holds the docker compose flags that change which services are run: the active
+// profiles and the services to run. With the zero value every service is reported.
+type ComposeOptions = extractors.ComposeOptions
+
+// HelmOptions holds the helm install flags that change the values charts are rendered with: values
+// files, --set overrides, and rendering once per values-*.yaml environment file, along with the
+// cluster capabilities and release they are rendered for, and whether the templates failing to render
+// are left out instead of the whole chart. The line-info extraction only renders charts when
+// TraceHelmValues is set.
+type HelmOptions = extractors.HelmOptions
+
+// HelmRenderOptions holds the helm template flags that change the cluster and release a chart is
+// rendered for: kube version, API versions, release name, namespace and upgrade.
+type HelmRenderOptions = extractors.HelmRenderOptions
+
+// DefaultHelmRenderProfiles returns the capability profiles charts are rendered for when
+// HelmOptions.RenderAllBranches is set without RenderProfiles.
+func DefaultHelmRenderProfiles() []HelmRenderOptions {
+	return extractors.DefaultHelmRenderProfiles()
+}
+
+// Diagnostic is a problem found in a file that kept an image from being extracted, or made the
+// extracted image name unreliable.
+type Diagnostic = extractors.Diagnostic
+
+// Severities of a Diagnostic.
+const (
+	DiagnosticError   = extractors.DiagnosticError
+	DiagnosticWarning = extractors.DiagnosticWarning
+)
+
+func resolveExtractOptions(options []ExtractOptions) ExtractOptions {
+	if len(options) > 0 {
+		return options[0]
+	}
+	return ExtractOptions{}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/Checkmarx/containers-images-extractor/internal/extractors"
@@ -12,6 +13,8 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ImagesExtractor extracts images from Dockerfiles, Docker Compose files and Helm charts. The file types
+// types.FileImages has no field for are extracted only by a ScanFilesExtractor.
 type ImagesExtractor interface {
 	ExtractAndMergeImagesFromFiles(files types.FileImages, images []types.ImageModel,
 		settingsFiles map[string]map[string]string) ([]types.ImageModel, error)
@@ -20,6 +23,21 @@ type ImagesExtractor interface {
 	ExtractAndMergeImagesFromFilesWithLineInfo(files types.FileImages, images []types.ImageModel, settingsFiles map[string]map[string]string) ([]types.ImageModel, error)
 }
 
+// ScanFilesExtractor is an ImagesExtractor also extracting images with ExtractOptions, from the file
+// types types.FileImages has no field for, and as image details or Dockerfile build graphs. Files
+// found by ExtractFiles are extracted with options as ScanFiles{FileImages: files}.
+type ScanFilesExtractor interface {
+	ImagesExtractor
+	ExtractScanFiles(scanPath string, isFullHelmDirectory ...bool) (ScanFiles, map[string]map[string]string, string, error)
+	ExtractAndMergeImagesFromScanFiles(files ScanFiles, images []types.ImageModel,
+		settingsFiles map[string]map[string]string, options ...ExtractOptions) ([]types.ImageModel, error)
+	ExtractAndMergeImagesFromScanFilesWithLineInfo(files ScanFiles, images []types.ImageModel,
+		settingsFiles map[string]map[string]string, options ...ExtractOptions) ([]types.ImageModel, error)
+	ExtractImageDetailsFromFiles(files types.FileImages, settingsFiles map[string]map[string]string, options ...ExtractOptions) ([]ImageDetails, error)
+	ExtractImageDetailsFromScanFiles(files ScanFiles, settingsFiles map[string]map[string]string, options ...ExtractOptions) ([]ImageDetails, error)
+	ExtractDockerfileGraphs(files types.FileImages, settingsFiles map[string]map[string]string, options ...ExtractOptions) ([]DockerfileGraph, error)
+}
+
 type imagesExtractor struct {
 }
 
@@ -27,54 +45,108 @@ func NewImagesExtractor() ImagesExtractor {
 	return &imagesExtractor{}
 }
 
+// NewScanFilesExtractor returns the extractor of NewImagesExtractor as a ScanFilesExtractor.
+func NewScanFilesExtractor() ScanFilesExtractor {
+	return &imagesExtractor{}
+}
+
 func (ie *imagesExtractor) ExtractAndMergeImagesFromFiles(files types.FileImages, images []types.ImageModel,
 	settingsFiles map[string]map[string]string) ([]types.ImageModel, error) {
-	dockerfileImages, err := extractors.ExtractImagesFromDockerfiles(files.Dockerfile, settingsFiles)
+	return ie.ExtractAndMergeImagesFromScanFiles(ScanFiles{FileImages: files}, images, settingsFiles)
+}
+
+// ExtractAndMergeImagesFromScanFiles is ExtractAndMergeImagesFromFiles for every file type found by ExtractScanFiles.
+func (ie *imagesExtractor) ExtractAndMergeImagesFromScanFiles(files ScanFiles, images []types.ImageModel,
+	settingsFiles map[string]map[string]string, options ...ExtractOptions) ([]types.ImageModel, error) {
+	opts := resolveExtractOptions(options)
+
+	dockerfileImages, err := extractors.ExtractImagesFromDockerfilesWithOptions(files.Dockerfile, settingsFiles, opts.Dockerfiles)
 	if err != nil {
 		log.Err(err).Msg("Could not extract images from docker files")
 		return nil, err
 	}
 
-	dockerComposeFileImages, err := extractors.ExtractImagesFromDockerComposeFiles(files.DockerCompose, settingsFiles)
+	dockerComposeFileImages, err := extractors.ExtractImagesFromDockerComposeFilesWithOptions(files.DockerCompose, settingsFiles, opts.Compose, opts.OnDiagnostic)
 	if err != nil {
 		log.Err(err).Msg("Could not extract images from docker compose files")
 		return nil, err
 	}
 
-	helmImages, extErr := extractors.ExtractImagesFromHelmFiles(files.Helm)
+	helmImages, extErr := extractors.ExtractImagesFromHelmFilesWithOptions(files.Helm, opts.Helm, opts.OnDiagnostic)
 	if extErr != nil {
 		log.Err(extErr).Msg("Could not extract images from helm files")
 		return nil, extErr
 	}
 
-	imagesFromFiles := mergeImages(images, dockerfileImages, dockerComposeFileImages, helmImages)
+	helmfileImages, extErr := extractors.ExtractImagesFromHelmfiles(files.Helmfiles, opts.Helm, opts.OnDiagnostic)
+	if extErr != nil {
+		log.Err(extErr).Msg("Could not extract images from helmfiles")
+		return nil, extErr
+	}
+	helmImages = append(helmImages, helmfileImages...)
+
+	kubernetesImages, err := extractors.ExtractImagesFromKubernetesManifests(files.Kubernetes)
+	if err != nil {
+		log.Err(err).Msg("Could not extract images from kubernetes manifests")
+		return nil, err
+	}
+
+	kustomizeImages, err := extractors.ExtractImagesFromKustomizations(files.Kustomizations)
+	if err != nil {
+		log.Err(err).Msg("Could not extract images from kustomize overlays")
+		return nil, err
+	}
+	kubernetesImages = append(kubernetesImages, kustomizeImages...)
+
+	imagesFromFiles := mergeImages(images, dockerfileImages, dockerComposeFileImages, append(helmImages, kubernetesImages...))
 
 	return imagesFromFiles, nil
 }
 
+// ExtractFiles returns the Dockerfiles, Docker Compose files and Helm charts under scanPath. Plain
+// Kubernetes manifests, Kustomizations and Helmfiles are found only by ScanFilesExtractor.ExtractScanFiles.
 func (ie *imagesExtractor) ExtractFiles(scanPath string, isFullHelmDirectory ...bool) (types.FileImages, map[string]map[string]string, string, error) {
+	files, envVars, filesPath, err := ie.ExtractScanFiles(scanPath, isFullHelmDirectory...)
+	return files.FileImages, envVars, filesPath, err
+}
+
+// ExtractScanFiles is ExtractFiles also returning the file types types.FileImages has no field for,
+// such as plain Kubernetes manifests.
+func (ie *imagesExtractor) ExtractScanFiles(scanPath string, isFullHelmDirectory ...bool) (ScanFiles, map[string]map[string]string, string, error) {
 	// Default to true (current behavior) if not provided
 	fullHelmDir := true
 	if len(isFullHelmDirectory) > 0 {
 		fullHelmDir = isFullHelmDirectory[0]
 	}
 
+	// A packaged chart is scanned from the archive rather than extracted
+	if info, err := os.Stat(scanPath); err == nil && !info.IsDir() && isHelmChartArchiveName(info.Name()) {
+		if helmChart, ok := helmChartArchiveInfo(scanPath); ok {
+			log.Debug().Msgf("Scanning packaged helm chart %s", scanPath)
+			return ScanFiles{FileImages: types.FileImages{Helm: []types.HelmChartInfo{helmChart}}}, map[string]map[string]string{}, scanPath, nil
+		}
+	}
+
 	filesPath, err := extractCompressedPath(scanPath)
 	if err != nil {
 		log.Err(err).Msgf("Could not extract compressed folder")
-		return types.FileImages{}, nil, scanPath, err
+		return ScanFiles{}, nil, scanPath, err
 	}
 
-	var f types.FileImages
+	var f ScanFiles
 	envFiles := make(map[string][]string)
+	chartDirs := make(map[string]bool)
+	kustomizationDirs := make(map[string]bool)
+	kustomizationFileNames := extractors.KustomizationFileNames()
+	var kustomizations []types.FilePath
 
 	err = filepath.Walk(filesPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		// Check if the current path matches the Dockerfile pattern
-		if dockerfilePattern.MatchString(info.Name()) {
+		// Check if the current path is a Dockerfile, by its name or its content
+		if isDockerfile(path, getRelativePath(filesPath, path), info) {
 			f.Dockerfile = append(f.Dockerfile, types.FilePath{
 				FullPath:     path,
 				RelativePath: getRelativePath(filesPath, path),
@@ -89,6 +161,32 @@ func (ie *imagesExtractor) ExtractFiles(scanPath string, isFullHelmDirectory ...
 			})
 		}
 
+		// Check if the current path is a kustomization, built later when it is an overlay
+		if !info.IsDir() && slices.Contains(kustomizationFileNames, info.Name()) {
+			kustomizations = append(kustomizations, types.FilePath{
+				FullPath:     path,
+				RelativePath: getRelativePath(filesPath, path),
+			})
+		}
+
+		// Check if the current path is a Helmfile, whose releases are rendered later
+		if !info.IsDir() && extractors.IsHelmfile(path) {
+			f.Helmfiles = append(f.Helmfiles, types.FilePath{
+				FullPath:     path,
+				RelativePath: getRelativePath(filesPath, path),
+			})
+		}
+
+		// Check if the current path is a Kubernetes manifest outside of Helm charts and kustomizations
+		if isKubernetesManifest(path, info) &&
+			!insideDirectoryWith(filepath.Dir(path), filesPath, []string{"Chart.yaml"}, chartDirs) &&
+			!insideDirectoryWith(filepath.Dir(path), filesPath, kustomizationFileNames, kustomizationDirs) {
+			f.Kubernetes = append(f.Kubernetes, types.FilePath{
+				FullPath:     path,
+				RelativePath: getRelativePath(filesPath, path),
+			})
+		}
+
 		if strings.HasSuffix(info.Name(), ".env") || strings.HasSuffix(info.Name(), ".env_cxcontainers") {
 			dir := filepath.Dir(path)
 			envFiles[dir] = append(envFiles[dir], path)
@@ -119,39 +217,164 @@ func (ie *imagesExtractor) ExtractFiles(scanPath string, isFullHelmDirectory ...
 		}
 	}
 
+	f.Kustomizations = extractors.KustomizationRoots(kustomizations)
+
 	printFilePaths(f.Dockerfile, "Successfully found dockerfiles")
 	printFilePaths(f.DockerCompose, "Successfully found docker compose files")
+	printFilePaths(f.Kubernetes, "Successfully found kubernetes manifests")
+	printFilePaths(f.Kustomizations, "Successfully found kustomize overlays")
+	printFilePaths(f.Helmfiles, "Successfully found helmfiles")
 
 	envVars := parseEnvFiles(envFiles)
 	return f, envVars, filesPath, nil
 }
 
 func (ie *imagesExtractor) ExtractAndMergeImagesFromFilesWithLineInfo(files types.FileImages, images []types.ImageModel, settingsFiles map[string]map[string]string) ([]types.ImageModel, error) {
-	dockerfileImages, err := extractors.ExtractImagesFromDockerfiles(files.Dockerfile, settingsFiles)
+	return ie.ExtractAndMergeImagesFromScanFilesWithLineInfo(ScanFiles{FileImages: files}, images, settingsFiles)
+}
+
+// ExtractAndMergeImagesFromScanFilesWithLineInfo is ExtractAndMergeImagesFromFilesWithLineInfo for every
+// file type found by ExtractScanFiles.
+func (ie *imagesExtractor) ExtractAndMergeImagesFromScanFilesWithLineInfo(files ScanFiles, images []types.ImageModel,
+	settingsFiles map[string]map[string]string, options ...ExtractOptions) ([]types.ImageModel, error) {
+	opts := resolveExtractOptions(options)
+
+	dockerfileImages, err := extractors.ExtractImagesFromDockerfilesWithOptions(files.Dockerfile, settingsFiles, opts.Dockerfiles)
 	if err != nil {
 		log.Err(err).Msg("Could not extract images from docker files")
 		return nil, err
 	}
-	dockerComposeFileImages := []types.ImageModel{}
-	for _, filePath := range files.DockerCompose {
-		composeImages, err := extractors.ExtractImagesWithLineNumbersFromDockerComposeFile(filePath)
-		if err != nil {
-			log.Err(err).Msgf("Could not extract images with line info from docker compose file: %s", filePath.FullPath)
-			continue
-		}
-		dockerComposeFileImages = append(dockerComposeFileImages, composeImages...)
+	dockerComposeFileImages, err := extractors.ExtractImagesWithLineNumbersFromDockerComposeFiles(files.DockerCompose, settingsFiles, opts.Compose, opts.OnDiagnostic)
+	if err != nil {
+		log.Err(err).Msg("Could not extract images with line info from docker compose files")
+		return nil, err
 	}
 
-	helmImages, extErr := extractors.ExtractImagesWithLineNumbersFromHelmFiles(files.Helm)
+	helmImages, extErr := extractHelmImagesWithLineInfo(files.Helm, opts)
 	if extErr != nil {
 		log.Err(extErr).Msg("Could not extract images from helm files")
 		return nil, extErr
 	}
 
-	imagesFromFiles := mergeImages(images, dockerfileImages, dockerComposeFileImages, helmImages)
+	helmfileDetails, extErr := extractHelmfileImageDetails(files.Helmfiles, opts)
+	if extErr != nil {
+		log.Err(extErr).Msg("Could not extract images from helmfiles")
+		return nil, extErr
+	}
+	helmImages = append(helmImages, extractors.ImageModels(helmfileDetails)...)
+
+	kubernetesImages, err := extractors.ExtractImagesFromKubernetesManifests(files.Kubernetes)
+	if err != nil {
+		log.Err(err).Msg("Could not extract images from kubernetes manifests")
+		return nil, err
+	}
+
+	kustomizeImages, err := extractors.ExtractImagesFromKustomizations(files.Kustomizations)
+	if err != nil {
+		log.Err(err).Msg("Could not extract images from kustomize overlays")
+		return nil, err
+	}
+	kubernetesImages = append(kubernetesImages, kustomizeImages...)
+
+	imagesFromFiles := mergeImages(images, dockerfileImages, dockerComposeFileImages, append(helmImages, kubernetesImages...))
 	return imagesFromFiles, nil
 }
 
+// ExtractImageDetailsFromFiles returns every occurrence of an image in files, with line info and the
+// metadata of the occurrence. The images of rendered Helm charts carry the workload and container
+// they were rendered for, and have line info only with TraceHelmValues. Unlike
+// ExtractAndMergeImagesFromFilesWithLineInfo, occurrences of the same image are not merged.
+func (ie *imagesExtractor) ExtractImageDetailsFromFiles(files types.FileImages, settingsFiles map[string]map[string]string, options ...ExtractOptions) ([]ImageDetails, error) {
+	return ie.ExtractImageDetailsFromScanFiles(ScanFiles{FileImages: files}, settingsFiles, options...)
+}
+
+// ExtractImageDetailsFromScanFiles is ExtractImageDetailsFromFiles for every file type found by ExtractScanFiles.
+func (ie *imagesExtractor) ExtractImageDetailsFromScanFiles(files ScanFiles, settingsFiles map[string]map[string]string, options ...ExtractOptions) ([]ImageDetails, error) {
+	opts := resolveExtractOptions(options)
+
+	details, err := extractors.ExtractImageDetailsFromDockerfiles(files.Dockerfile, settingsFiles, opts.Dockerfiles)
+	if err != nil {
+		log.Err(err).Msg("Could not extract images from docker files")
+		return nil, err
+	}
+
+	composeDetails, err := extractors.ExtractImageDetailsFromDockerComposeFiles(files.DockerCompose, settingsFiles, opts.Compose, opts.OnDiagnostic)
+	if err != nil {
+		log.Err(err).Msg("Could not extract images with line info from docker compose files")
+		return nil, err
+	}
+	details = append(details, composeDetails...)
+
+	if opts.TraceHelmValues {
+		helmDetails, extErr := extractors.ExtractImageDetailsFromHelmFiles(files.Helm, opts.Helm, opts.OnDiagnostic)
+		if extErr != nil {
+			log.Err(extErr).Msg("Could not extract images from helm files")
+			return nil, extErr
+		}
+		details = append(details, helmDetails...)
+	} else {
+		helmDetails, extErr := extractors.ExtractRenderedImageDetailsFromHelmFiles(files.Helm, opts.Helm, opts.OnDiagnostic)
+		if extErr != nil {
+			log.Err(extErr).Msg("Could not extract images from helm files")
+			return nil, extErr
+		}
+		details = append(details, helmDetails...)
+	}
+
+	helmfileDetails, err := extractHelmfileImageDetails(files.Helmfiles, opts)
+	if err != nil {
+		log.Err(err).Msg("Could not extract images from helmfiles")
+		return nil, err
+	}
+	details = append(details, helmfileDetails...)
+
+	kubernetesDetails, err := extractors.ExtractImageDetailsFromKubernetesManifests(files.Kubernetes)
+	if err != nil {
+		log.Err(err).Msg("Could not extract images from kubernetes manifests")
+		return nil, err
+	}
+	details = append(details, kubernetesDetails...)
+
+	kustomizeDetails, err := extractors.ExtractImageDetailsFromKustomizations(files.Kustomizations)
+	if err != nil {
+		log.Err(err).Msg("Could not extract images from kustomize overlays")
+		return nil, err
+	}
+	details = append(details, kustomizeDetails...)
+
+	return details, nil
+}
+
+// extractHelmImagesWithLineInfo locates the images of Helm charts either at the values keys they are
+// built from or at the image: lines of the chart files, as selected by opts.
+func extractHelmImagesWithLineInfo(helmCharts []types.HelmChartInfo, opts ExtractOptions) ([]types.ImageModel, error) {
+	if opts.TraceHelmValues {
+		return extractors.ExtractImagesWithValuesLocationsFromHelmFiles(helmCharts, opts.Helm, opts.OnDiagnostic)
+	}
+	return extractors.ExtractImagesWithLineNumbersFromHelmFiles(helmCharts)
+}
+
+// extractHelmfileImageDetails locates the images of the releases of Helmfiles either at the values
+// keys they are built from or at the templates rendering them, as selected by opts.
+func extractHelmfileImageDetails(helmfiles []types.FilePath, opts ExtractOptions) ([]ImageDetails, error) {
+	if opts.TraceHelmValues {
+		return extractors.ExtractImageDetailsWithValuesLocationsFromHelmfiles(helmfiles, opts.Helm, opts.OnDiagnostic)
+	}
+	return extractors.ExtractImageDetailsFromHelmfiles(helmfiles, opts.Helm, opts.OnDiagnostic)
+}
+
+// ExtractDockerfileGraphs returns the multi-stage build graph of every Dockerfile in files.
+func (ie *imagesExtractor) ExtractDockerfileGraphs(files types.FileImages, settingsFiles map[string]map[string]string, options ...ExtractOptions) ([]DockerfileGraph, error) {
+	opts := resolveExtractOptions(options)
+
+	graphs, err := extractors.ExtractDockerfileGraphs(files.Dockerfile, settingsFiles, opts.Dockerfiles)
+	if err != nil {
+		log.Err(err).Msg("Could not extract build graphs from docker files")
+		return nil, err
+	}
+	return graphs, nil
+}
+
 func parseEnvFiles(envFiles map[string][]string) map[string]map[string]string {
 	envVars := make(map[string]map[string]string)
 
@@ -1,7 +1,11 @@
 package imagesExtractor
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
@@ -424,7 +428,7 @@ func TestDockerComposeExtractorWithLineNumbersAndIndices(t *testing.T) {
 		RelativePath: "docker-compose-4.yml",
 	}
 
-	result, err := extractors.ExtractImagesWithLineNumbersFromDockerComposeFile(filePath)
+	result, err := extractors.ExtractImagesWithLineNumbersFromDockerComposeFile(filePath, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -614,3 +618,701 @@ func TestExtractFilesWithFullHelmDirectoryFalseDefaultBehavior(t *testing.T) {
 		t.Errorf("Settings files mismatch between default and true calls")
 	}
 }
+
+func TestExtractAndMergeImagesFromFilesWithOptions(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files := types.FileImages{
+		Dockerfile: []types.FilePath{
+			{FullPath: "../../test_files/imageExtraction/dockerfiles/Dockerfile", RelativePath: "Dockerfile"},
+		},
+	}
+	options := ExtractOptions{
+		Dockerfiles: map[string]DockerfileBuildOptions{
+			"Dockerfile": {Target: "publish"},
+		},
+	}
+
+	for _, lineInfo := range []bool{false, true} {
+		var result []types.ImageModel
+		var err error
+		if lineInfo {
+			result, err = extractor.ExtractAndMergeImagesFromScanFilesWithLineInfo(ScanFiles{FileImages: files}, nil, nil, options)
+		} else {
+			result, err = extractor.ExtractAndMergeImagesFromScanFiles(ScanFiles{FileImages: files}, nil, nil, options)
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := []types.ImageModel{
+			{Name: "mcr.microsoft.com/dotnet/sdk:6.0", ImageLocations: []types.ImageLocation{{Origin: types.DockerFileOrigin, Path: "Dockerfile", FinalStage: true, Line: 1, StartIndex: 5, EndIndex: 37}}},
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Expected %+v but got %+v", expected, result)
+		}
+	}
+}
+
+func TestExtractDockerfileGraphs(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files := types.FileImages{
+		Dockerfile: []types.FilePath{
+			{FullPath: "../../test_files/imageExtraction/dockerfiles/Dockerfile", RelativePath: "Dockerfile"},
+		},
+	}
+	options := ExtractOptions{
+		Dockerfiles: map[string]DockerfileBuildOptions{
+			"Dockerfile": {Target: "publish"},
+		},
+	}
+
+	graphs, err := extractor.ExtractDockerfileGraphs(files, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(graphs) != 1 {
+		t.Fatalf("Expected 1 graph but got %d", len(graphs))
+	}
+
+	for _, stage := range graphs[0].Stages {
+		shipped := stage.Name == "build" || stage.Name == "publish"
+		if stage.Shipped != shipped {
+			t.Errorf("Expected stage %d (%s) shipped=%v but got %v", stage.Index, stage.Name, shipped, stage.Shipped)
+		}
+		if stage.Required != shipped {
+			t.Errorf("Expected stage %d (%s) required=%v but got %v", stage.Index, stage.Name, shipped, stage.Required)
+		}
+	}
+}
+
+func TestExtractImageDetailsFromFiles(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files := types.FileImages{
+		Dockerfile: []types.FilePath{
+			{FullPath: "../../test_files/dockerfile-testcases/Dockerfile.platform", RelativePath: "Dockerfile.platform"},
+		},
+		DockerCompose: []types.FilePath{
+			{FullPath: "../../test_files/imageExtraction/dockerCompose/docker-compose-4.yaml", RelativePath: "docker-compose-4.yaml"},
+		},
+	}
+	options := ExtractOptions{
+		Dockerfiles: map[string]DockerfileBuildOptions{
+			"Dockerfile.platform": {BuildPlatform: "linux/amd64", Platform: "linux/arm64"},
+		},
+	}
+
+	details, err := extractor.ExtractImageDetailsFromFiles(files, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var dockerfileImages, composeImages int
+	for _, detail := range details {
+		if len(detail.ImageLocations) != 1 {
+			t.Errorf("Expected image %s to have exactly one location, but got %d", detail.Name, len(detail.ImageLocations))
+			continue
+		}
+		switch detail.ImageLocations[0].Origin {
+		case types.DockerFileOrigin:
+			dockerfileImages++
+			if detail.Platform == "" {
+				t.Errorf("Expected image %s to have a platform", detail.Name)
+			}
+			if detail.Usage == DockerfileSyntax && detail.Name != "docker/dockerfile:1.7" {
+				t.Errorf("Expected the syntax image to be docker/dockerfile:1.7, got %s", detail.Name)
+			}
+		case types.DockerComposeFileOrigin:
+			composeImages++
+		}
+	}
+	if dockerfileImages != 4 {
+		t.Errorf("Expected 4 dockerfile images but got %d", dockerfileImages)
+	}
+	if composeImages == 0 {
+		t.Errorf("Expected docker compose images")
+	}
+}
+
+func TestExtractFiles_DockerfileNames(t *testing.T) {
+	extractor := NewImagesExtractor()
+
+	files, _, _, err := extractor.ExtractFiles("../../test_files/dockerfile-naming")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []types.FilePath{
+		{FullPath: "../../test_files/dockerfile-naming/Containerfile", RelativePath: "Containerfile"},
+		{FullPath: "../../test_files/dockerfile-naming/Containerfile.prod", RelativePath: "Containerfile.prod"},
+		{FullPath: "../../test_files/dockerfile-naming/app.dockerfile", RelativePath: "app.dockerfile"},
+		{FullPath: "../../test_files/dockerfile-naming/build/docker/api.Dockerfile", RelativePath: "build/docker/api.Dockerfile"},
+		{FullPath: "../../test_files/dockerfile-naming/node_modules/base-image/Dockerfile", RelativePath: "node_modules/base-image/Dockerfile"},
+		{FullPath: "../../test_files/dockerfile-naming/runtime", RelativePath: "runtime"},
+		{FullPath: "../../test_files/dockerfile-naming/worker.build", RelativePath: "worker.build"},
+	}
+	if !reflect.DeepEqual(files.Dockerfile, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, files.Dockerfile)
+	}
+}
+
+func TestExtractFiles_ComposeSpecificationNames(t *testing.T) {
+	extractor := NewImagesExtractor()
+
+	files, _, _, err := extractor.ExtractFiles("../../test_files/compose-testcases/override")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []types.FilePath{
+		{FullPath: "../../test_files/compose-testcases/override/compose.override.yaml", RelativePath: "compose.override.yaml"},
+		{FullPath: "../../test_files/compose-testcases/override/compose.prod.yaml", RelativePath: "compose.prod.yaml"},
+		{FullPath: "../../test_files/compose-testcases/override/compose.yaml", RelativePath: "compose.yaml"},
+	}
+	if !reflect.DeepEqual(files.DockerCompose, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, files.DockerCompose)
+	}
+
+	images, err := extractor.ExtractAndMergeImagesFromFilesWithLineInfo(files, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, image := range images {
+		if image.Name == "ghcr.io/org/api:1.0" {
+			t.Errorf("Expected the image replaced by compose.override.yaml not to be reported")
+		}
+	}
+}
+
+func TestExtractAndMergeImagesFromFiles_OnDiagnostic(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files := types.FileImages{
+		DockerCompose: []types.FilePath{
+			{FullPath: "../../test_files/compose-testcases/interpolation/docker-compose.yaml", RelativePath: "docker-compose.yaml"},
+		},
+	}
+	var diagnostics []Diagnostic
+	options := ExtractOptions{
+		OnDiagnostic: func(diagnostic Diagnostic) {
+			diagnostics = append(diagnostics, diagnostic)
+		},
+	}
+
+	images, err := extractor.ExtractAndMergeImagesFromScanFiles(ScanFiles{FileImages: files}, nil, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, image := range images {
+		if strings.Contains(image.Name, "$") {
+			t.Errorf("Expected interpolated image names, got %s", image.Name)
+		}
+	}
+
+	var errorCount int
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == DiagnosticError {
+			errorCount++
+		}
+	}
+	// Without env files the required REGISTRY and TOOLS_IMAGE variables are missing, and the images of
+	// web, worker and cache are not valid references.
+	if errorCount != 5 {
+		t.Errorf("Expected 5 error diagnostics but got %+v", diagnostics)
+	}
+}
+
+func TestExtractImageDetailsFromFiles_ComposeProfiles(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files := types.FileImages{
+		DockerCompose: []types.FilePath{
+			{FullPath: "../../test_files/compose-testcases/profiles/compose.yaml", RelativePath: "compose.yaml"},
+		},
+	}
+	options := ExtractOptions{Compose: ComposeOptions{Profiles: []string{"debug"}}}
+
+	images, err := extractor.ExtractImageDetailsFromFiles(files, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	profiles := make(map[string][]string)
+	for _, image := range images {
+		profiles[image.Name] = image.Profiles
+	}
+	expected := map[string][]string{
+		"nginx:1.27":               nil,
+		"ghcr.io/org/api:2.0":      nil,
+		"ghcr.io/org/debugger:0.3": {"debug", "tooling"},
+	}
+	if !reflect.DeepEqual(profiles, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, profiles)
+	}
+
+	lineImages, err := extractor.ExtractAndMergeImagesFromScanFilesWithLineInfo(ScanFiles{FileImages: files}, nil, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(lineImages) != 3 {
+		t.Errorf("Expected the images of the 3 enabled services but got %+v", lineImages)
+	}
+}
+
+func TestExtractAndMergeImagesFromFiles_ComposeVariablesAgree(t *testing.T) {
+	extractor := NewImagesExtractor()
+
+	files, settingsFiles, _, err := extractor.ExtractFiles("../../test_files/imageExtraction/dockerCompose")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	settingsFiles["../../test_files/imageExtraction/dockerCompose"] = map[string]string{"MARKETER_IMAGE": "source.azure.io/api:3.18"}
+
+	images, err := extractor.ExtractAndMergeImagesFromFiles(files, nil, settingsFiles)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lineImages, err := extractor.ExtractAndMergeImagesFromFilesWithLineInfo(files, nil, settingsFiles)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var names, lineNames []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	for _, image := range lineImages {
+		lineNames = append(lineNames, image.Name)
+		if strings.Contains(image.Name, "$") {
+			t.Errorf("Expected line info image names to be interpolated, got %s", image.Name)
+		}
+	}
+	if !reflect.DeepEqual(names, lineNames) {
+		t.Errorf("Expected both extraction modes to report %v but got %v", names, lineNames)
+	}
+	if !slices.Contains(lineNames, "source.azure.io/api:3.18") {
+		t.Errorf("Expected the MARKETER_IMAGE variable to be resolved, got %v", lineNames)
+	}
+}
+
+func TestExtractImageDetailsFromFiles_TraceHelmValues(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files, _, _, err := extractor.ExtractFiles("../../test_files/helm-values-tracing")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	options := ExtractOptions{TraceHelmValues: true}
+
+	details, err := extractor.ExtractImageDetailsFromFiles(files, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	keys := make(map[string][]string)
+	for _, detail := range details {
+		keys[detail.Name] = append(keys[detail.Name], detail.ValuesKey)
+	}
+	expected := map[string][]string{
+		"ghcr.io/org/api:1.4.2":                   {"global.registry", "api.image.repository", "api.image.tag"},
+		"quay.io/prometheus/node-exporter:v1.8.0": {""},
+		"docker.io/library/worker:2.0.1":          {"worker.image.repository", "worker.image.tag"},
+		"ghcr.io/org/migrations:1.4.2":            {"global.registry", "api.image.tag"},
+		"nginx:1.27":                              {""},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected values keys %+v but got %+v", expected, keys)
+	}
+
+	images, err := extractor.ExtractAndMergeImagesFromScanFilesWithLineInfo(ScanFiles{FileImages: files}, nil, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, image := range images {
+		if image.Name != "ghcr.io/org/api:1.4.2" {
+			continue
+		}
+		expectedLocation := types.ImageLocation{Origin: types.HelmFileOrigin, Path: "values.yaml", Line: 6, StartIndex: 10, EndIndex: 15}
+		if len(image.ImageLocations) != 3 || image.ImageLocations[2] != expectedLocation {
+			t.Errorf("Expected the tag to be located at %+v, got %+v", expectedLocation, image.ImageLocations)
+		}
+	}
+}
+
+func TestExtractImageDetailsFromFiles_HelmWorkloads(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files, _, _, err := extractor.ExtractFiles("../../test_files/helm-values-tracing")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Without TraceHelmValues the rendered images still carry the workload and container they come from
+	details, err := extractor.ExtractImageDetailsFromFiles(files, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	workloads := make(map[string]string)
+	for _, detail := range details {
+		if detail.Kind == "Deployment" {
+			workloads[detail.Name] = detail.Workload + "/" + detail.Container + " " + detail.ImageLocations[0].Path
+		}
+	}
+	expected := map[string]string{
+		"ghcr.io/org/migrations:1.4.2": "temp-release-web/migrate tracing/templates/deployment.yaml",
+		"nginx:1.27":                   "temp-release-web/web tracing/templates/deployment.yaml",
+	}
+	if !reflect.DeepEqual(workloads, expected) {
+		t.Errorf("Expected workloads %+v but got %+v", expected, workloads)
+	}
+}
+
+func TestExtractAndMergeImagesFromFiles_HelmEnvironments(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files, _, _, err := extractor.ExtractFiles("../../test_files/helm-environments")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	options := ExtractOptions{Helm: HelmOptions{Environments: true, Set: []string{"image.repository=mirror.io/shop"}}}
+
+	images, err := extractor.ExtractAndMergeImagesFromScanFiles(ScanFiles{FileImages: files}, nil, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	slices.Sort(names)
+	expected := []string{"mirror.io/shop:1.0.0", "mirror.io/shop:1.0.0-prod", "prom/statsd-exporter:v0.26.0"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected images %v but got %v", expected, names)
+	}
+
+	options.TraceHelmValues = true
+	details, err := extractor.ExtractImageDetailsFromFiles(files, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	environments := make(map[string][]string)
+	for _, detail := range details {
+		environments[detail.Environment] = append(environments[detail.Environment], detail.Name+" "+detail.ValuesKey)
+	}
+	expectedEnvironments := map[string][]string{
+		"":     {"mirror.io/shop:1.0.0 image.tag"},
+		"dev":  {"mirror.io/shop:1.0.0 image.tag"},
+		"prod": {"mirror.io/shop:1.0.0-prod image.tag", "prom/statsd-exporter:v0.26.0 "},
+	}
+	if !reflect.DeepEqual(environments, expectedEnvironments) {
+		t.Errorf("Expected images per environment %+v but got %+v", expectedEnvironments, environments)
+	}
+}
+
+func TestExtractAndMergeImagesFromFiles_HelmRenderAllBranches(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files, _, _, err := extractor.ExtractFiles("../../test_files/helm-capabilities")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		options  ExtractOptions
+		expected []string
+	}{
+		{"DefaultCapabilities", ExtractOptions{}, []string{"busybox:1.28", "nginx:1.25.3"}},
+		{"KubeVersion", ExtractOptions{Helm: HelmOptions{Render: HelmRenderOptions{KubeVersion: "v1.30.0", IsUpgrade: true}}},
+			[]string{"busybox:1.36", "ghcr.io/org/migrate:1.0.0", "nginx:1.25.3"}},
+		{"RenderAllBranches", ExtractOptions{Helm: HelmOptions{RenderAllBranches: true}}, []string{
+			"busybox:1.28", "busybox:1.36", "ghcr.io/org/migrate:1.0.0", "nginx/nginx-prometheus-exporter:1.1.0", "nginx:1.25.3",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			images, err := extractor.ExtractAndMergeImagesFromScanFiles(ScanFiles{FileImages: files}, nil, nil, test.options)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var names []string
+			for _, image := range images {
+				names = append(names, image.Name)
+			}
+			slices.Sort(names)
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("Expected images %v but got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestExtractImageDetailsFromFiles_HelmTolerant(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files, _, _, err := extractor.ExtractFiles("../../test_files/helm-tolerant")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var diagnostics []string
+	options := ExtractOptions{
+		Helm:            HelmOptions{Tolerant: true, Set: []string{"image.tag=1.4.0"}},
+		TraceHelmValues: true,
+		OnDiagnostic: func(diagnostic Diagnostic) {
+			diagnostics = append(diagnostics, diagnostic.Path)
+		},
+	}
+
+	details, err := extractor.ExtractImageDetailsFromFiles(files, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var names []string
+	for _, detail := range details {
+		names = append(names, detail.Name)
+	}
+	slices.Sort(names)
+	expected := []string{"busybox:1.36", "ghcr.io/org/api:1.4.0", "nginx:1.25.3"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected images %v but got %v", expected, names)
+	}
+	expectedDiagnostics := []string{"tolerant/templates/migrations.yaml", "tolerant/templates/worker.yaml"}
+	if !reflect.DeepEqual(diagnostics, expectedDiagnostics) {
+		t.Errorf("Expected diagnostics for %v but got %v", expectedDiagnostics, diagnostics)
+	}
+}
+
+func TestExtractAndMergeImagesFromFiles_HelmChartAnnotation(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files, _, _, err := extractor.ExtractFiles("../../test_files/helm-annotations")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	images, err := extractor.ExtractAndMergeImagesFromFilesWithLineInfo(files, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var annotated []string
+	for _, image := range images {
+		for _, location := range image.ImageLocations {
+			if location.Origin == HelmChartAnnotationOrigin {
+				annotated = append(annotated, fmt.Sprintf("%s %s:%d", image.Name, location.Path, location.Line))
+			}
+		}
+	}
+	slices.Sort(annotated)
+	expected := []string{
+		"busybox:1.35 Chart.yaml:12",
+		"docker.io/library/nginx:1.25.3 Chart.yaml:10",
+		"nginx/nginx-prometheus-exporter@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef Chart.yaml:17",
+	}
+	if !reflect.DeepEqual(annotated, expected) {
+		t.Errorf("Expected annotated images %v but got %v", expected, annotated)
+	}
+
+	var diagnostics []string
+	options := ExtractOptions{OnDiagnostic: func(diagnostic Diagnostic) {
+		diagnostics = append(diagnostics, diagnostic.Message)
+	}}
+	if _, err := extractor.ExtractAndMergeImagesFromScanFiles(ScanFiles{FileImages: files}, nil, nil, options); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedDiagnostics := []string{
+		"image busybox:1.35 of the artifacthub.io/images annotation is not rendered by the chart",
+		"image busybox:1.36 rendered by the chart is missing from the artifacthub.io/images annotation",
+	}
+	if !reflect.DeepEqual(diagnostics, expectedDiagnostics) {
+		t.Errorf("Expected diagnostics %v but got %v", expectedDiagnostics, diagnostics)
+	}
+}
+
+func TestExtractScanFiles_KubernetesManifests(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files, _, _, err := extractor.ExtractScanFiles("../../test_files/k8s-manifests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []types.FilePath{
+		{FullPath: "../../test_files/k8s-manifests/k8s/configmap.yml", RelativePath: "k8s/configmap.yml"},
+		{FullPath: "../../test_files/k8s-manifests/k8s/deployment.yaml", RelativePath: "k8s/deployment.yaml"},
+		{FullPath: "../../test_files/k8s-manifests/k8s/list.yaml", RelativePath: "k8s/list.yaml"},
+	}
+	if !reflect.DeepEqual(files.Kubernetes, expected) {
+		t.Errorf("Expected kubernetes manifests %+v but got %+v", expected, files.Kubernetes)
+	}
+	if len(files.Helm) != 1 {
+		t.Errorf("Expected the chart to be found as a helm chart, got %+v", files.Helm)
+	}
+
+	images, err := extractor.ExtractAndMergeImagesFromScanFilesWithLineInfo(files, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	locations := make(map[string][]types.ImageLocation)
+	for _, image := range images {
+		locations[image.Name] = image.ImageLocations
+	}
+	expectedLocation := []types.ImageLocation{{Origin: KubernetesManifestOrigin, Path: "k8s/deployment.yaml", Line: 13, StartIndex: 17, EndIndex: 36}}
+	if !reflect.DeepEqual(locations["ghcr.io/org/api:2.0"], expectedLocation) {
+		t.Errorf("Expected %+v but got %+v", expectedLocation, locations["ghcr.io/org/api:2.0"])
+	}
+	for _, name := range []string{"ghcr.io/org/migrations:1.1", "ghcr.io/org/backup:1.0", "busybox:1.36"} {
+		if _, ok := locations[name]; !ok {
+			t.Errorf("Expected image %s to be extracted, got %+v", name, images)
+		}
+	}
+
+	merged, err := extractor.ExtractAndMergeImagesFromScanFiles(files, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(merged) != len(images) {
+		t.Errorf("Expected both extraction modes to find %d images but got %d", len(images), len(merged))
+	}
+
+	details, err := extractor.ExtractImageDetailsFromScanFiles(files, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var workloads []string
+	for _, detail := range details {
+		if detail.ImageLocations[0].Origin == KubernetesManifestOrigin {
+			workloads = append(workloads, detail.Kind+"/"+detail.Workload+"/"+detail.Container)
+		}
+	}
+	expectedWorkloads := []string{"Deployment/api/migrate", "Deployment/api/api", "CronJob/backup/backup", "Pod/debug/shell"}
+	if !reflect.DeepEqual(workloads, expectedWorkloads) {
+		t.Errorf("Expected workloads %v but got %v", expectedWorkloads, workloads)
+	}
+}
+
+func TestExtractScanFiles_KustomizeOverlays(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files, _, _, err := extractor.ExtractScanFiles("../../test_files/kustomize-testcases")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var overlays []string
+	for _, kustomization := range files.Kustomizations {
+		overlays = append(overlays, kustomization.RelativePath)
+	}
+	expectedOverlays := []string{
+		"overlays/prod/kustomization.yaml",
+		"overlays/remote/kustomization.yaml",
+		"overlays/staging/kustomization.yaml",
+	}
+	if !reflect.DeepEqual(overlays, expectedOverlays) {
+		t.Errorf("Expected overlays %v but got %v", expectedOverlays, overlays)
+	}
+	if len(files.Kubernetes) != 0 {
+		t.Errorf("Expected the manifests of the base to be built rather than scanned, got %+v", files.Kubernetes)
+	}
+
+	images, err := extractor.ExtractAndMergeImagesFromScanFiles(files, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	slices.Sort(names)
+	expectedNames := []string{
+		"busybox:1.36",
+		"nginx:1.25",
+		"nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		"registry.example.com/api:2.1.0",
+		"staging.example.com/api:rc",
+	}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Expected images %v but got %v", expectedNames, names)
+	}
+}
+
+func TestExtractScanFiles_Helmfiles(t *testing.T) {
+	extractor := NewScanFilesExtractor()
+
+	files, _, _, err := extractor.ExtractScanFiles("../../test_files/helmfile-testcases")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedHelmfiles := []types.FilePath{
+		{FullPath: "../../test_files/helmfile-testcases/helmfile.yaml", RelativePath: "helmfile.yaml"},
+	}
+	if !reflect.DeepEqual(files.Helmfiles, expectedHelmfiles) {
+		t.Errorf("Expected helmfiles %+v but got %+v", expectedHelmfiles, files.Helmfiles)
+	}
+
+	var diagnostics []string
+	options := ExtractOptions{OnDiagnostic: func(diagnostic Diagnostic) {
+		diagnostics = append(diagnostics, fmt.Sprintf("%s:%d %s", diagnostic.Path, diagnostic.Line, diagnostic.Message))
+	}}
+	details, err := extractor.ExtractImageDetailsFromScanFiles(files, nil, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var releases []string
+	for _, detail := range details {
+		if detail.Release != "" {
+			releases = append(releases, fmt.Sprintf("%s %s/%s", detail.Name, detail.Release, detail.Environment))
+		}
+	}
+	expectedReleases := []string{
+		"ghcr.io/org/shop:1.0.0 shop/default",
+		"registry.example.com/shop:2.0.0 shop/prod",
+		"prom/statsd-exporter:v0.26.0 metrics/prod",
+	}
+	if !reflect.DeepEqual(releases, expectedReleases) {
+		t.Errorf("Expected release images %v but got %v", expectedReleases, releases)
+	}
+	expectedDiagnostics := []string{
+		"helmfile.yaml:35 release redis uses the chart bitnami/redis, which is not available locally and is not fetched",
+	}
+	if !reflect.DeepEqual(diagnostics, expectedDiagnostics) {
+		t.Errorf("Expected diagnostics %v but got %v", expectedDiagnostics, diagnostics)
+	}
+}
+
+func TestExtractFiles_HelmChartArchives(t *testing.T) {
+	extractor := NewImagesExtractor()
+	expectedChart := types.HelmChartInfo{Directory: "../../test_files/helm-archives/releases/shop-1.2.3.tgz", ValuesFile: "shop/values.yaml"}
+
+	for _, scanPath := range []string{"../../test_files/helm-archives", expectedChart.Directory} {
+		files, _, filesPath, err := extractor.ExtractFiles(scanPath)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(files.Helm, []types.HelmChartInfo{expectedChart}) {
+			t.Errorf("Expected the packaged chart %+v when scanning %s but got %+v", expectedChart, scanPath, files.Helm)
+		}
+		if filesPath != scanPath {
+			t.Errorf("Expected %s to be scanned in place, got %s", scanPath, filesPath)
+		}
+
+		images, err := extractor.ExtractAndMergeImagesFromFiles(files, nil, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var names []string
+		for _, image := range images {
+			names = append(names, image.Name)
+		}
+		slices.Sort(names)
+		expected := []string{"envoyproxy/envoy:v1.31.0", "ghcr.io/org/shop:1.2.3"}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("Expected images %v but got %v", expected, names)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join("../../test_files/helm-archives/releases", DirToExtractTar)); !os.IsNotExist(err) {
+		t.Errorf("Expected the packaged chart not to be extracted")
+	}
+}
@@ -0,0 +1,22 @@
+package imagesExtractor
+
+import (
+	"github.com/Checkmarx/containers-images-extractor/internal/extractors"
+)
+
+// ImageDetails is a single occurrence of an image, with metadata such as the platform it is pulled
+// for. Its ImageModel always holds exactly one ImageLocation.
+type ImageDetails = extractors.ImageDetails
+
+// DockerfileDetails, ComposeDetails, KubernetesDetails, HelmDetails and KustomizeDetails are the
+// details of ImageDetails specific to the kind of file an image comes from.
+type (
+	DockerfileDetails = extractors.DockerfileDetails
+	ComposeDetails    = extractors.ComposeDetails
+	KubernetesDetails = extractors.KubernetesDetails
+	HelmDetails       = extractors.HelmDetails
+	KustomizeDetails  = extractors.KustomizeDetails
+)
+
+// DockerfileSyntax is the Usage of the BuildKit frontend image selected by a # syntax= directive.
+const DockerfileSyntax = extractors.DockerfileSyntax
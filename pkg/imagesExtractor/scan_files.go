@@ -0,0 +1,30 @@
+package imagesExtractor
+
+import (
+	"github.com/Checkmarx/containers-images-extractor/internal/extractors"
+	"github.com/Checkmarx/containers-types/types"
+)
+
+// ScanFiles are the files found by ExtractScanFiles: the files of types.FileImages, and the file
+// types it has no field for.
+type ScanFiles struct {
+	types.FileImages
+	// Kubernetes are the plain Kubernetes manifests found outside of Helm charts.
+	Kubernetes []types.FilePath
+	// Kustomizations are the kustomization files of the Kustomize overlays found, the kustomizations
+	// that are not a base of another one. Manifests inside a kustomization directory are built with it
+	// rather than listed in Kubernetes.
+	Kustomizations []types.FilePath
+	// Helmfiles are the Helmfiles found, whose releases are rendered from their local charts.
+	Helmfiles []types.FilePath
+}
+
+// KubernetesManifestOrigin is the Origin of the images found in plain Kubernetes manifests.
+const KubernetesManifestOrigin = extractors.KubernetesManifestOrigin
+
+// KustomizeOrigin is the Origin of the images found by building Kustomize overlays.
+const KustomizeOrigin = extractors.KustomizeOrigin
+
+// HelmChartAnnotationOrigin is the Origin of the images declared by the artifacthub.io/images
+// annotation of the Chart.yaml of Helm charts.
+const HelmChartAnnotationOrigin = extractors.HelmChartAnnotationOrigin
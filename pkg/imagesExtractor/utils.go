@@ -1,21 +1,60 @@
 package imagesExtractor
 
 import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/Checkmarx/containers-images-extractor/internal/extractors"
 	"github.com/Checkmarx/containers-types/types"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
 	"github.com/rs/zerolog/log"
 )
 
 var (
-	dockerfilePattern    = regexp.MustCompile(`^Dockerfile(?:-[a-zA-Z0-9]+|\.[a-zA-Z0-9.]+|[a-zA-Z0-9.]+)?$`)
-	dockerComposePattern = regexp.MustCompile(`docker-compose(-[a-zA-Z0-9]+)?(\.yml|\.yaml)$`)
+	dockerfilePattern    = regexp.MustCompile(`^(?:Dockerfile|Containerfile)(?:-[a-zA-Z0-9]+|\.[a-zA-Z0-9.]+|[a-zA-Z0-9.]+)?$|^[\w.-]+\.(?i:dockerfile|containerfile)$`)
+	dockerComposePattern = regexp.MustCompile(`(?:docker-compose|^compose)(?:[-.][a-zA-Z0-9]+)*\.(?:yml|yaml)$`)
 )
 
+// knownFileExtensions are the extensions of source, documentation, data and binary files, which are
+// never opened to check whether they are Dockerfiles.
+var knownFileExtensions = map[string]bool{
+	".go": true, ".py": true, ".js": true, ".mjs": true, ".cjs": true, ".ts": true, ".tsx": true, ".jsx": true,
+	".java": true, ".kt": true, ".scala": true, ".groovy": true, ".gradle": true, ".rb": true, ".php": true,
+	".rs": true, ".c": true, ".h": true, ".cc": true, ".cpp": true, ".hpp": true, ".cs": true, ".swift": true,
+	".sh": true, ".bash": true, ".ps1": true, ".bat": true, ".cmd": true, ".sql": true, ".tf": true, ".hcl": true,
+	".md": true, ".rst": true, ".txt": true, ".adoc": true, ".html": true, ".css": true, ".svg": true,
+	".json": true, ".yaml": true, ".yml": true, ".toml": true, ".xml": true, ".ini": true, ".cfg": true,
+	".conf": true, ".properties": true, ".env": true, ".lock": true, ".sum": true, ".mod": true, ".csv": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".ico": true, ".pdf": true, ".woff": true,
+	".woff2": true, ".ttf": true, ".zip": true, ".tar": true, ".gz": true, ".tgz": true, ".jar": true,
+	".war": true, ".class": true, ".so": true, ".dll": true, ".exe": true, ".a": true, ".o": true, ".pyc": true,
+}
+
+// unsniffedDirectories are vendored dependency, version control and build output directories, whose
+// files are only recognized as Dockerfiles by their name.
+var unsniffedDirectories = map[string]bool{
+	"vendor": true, "node_modules": true, "bower_components": true, "third_party": true, ".venv": true,
+	"venv": true, "__pycache__": true, ".git": true, ".hg": true, ".svn": true, "bin": true, "obj": true,
+}
+
+// maxSniffedDockerfileSize is the size above which a file is not opened to check whether it is a Dockerfile.
+const maxSniffedDockerfileSize = 1 << 20
+
+// maxSniffedManifestSize is the size above which a YAML file is not parsed to check whether it is a Kubernetes manifest.
+const maxSniffedManifestSize = 4 << 20
+
+// maxSniffedArchiveEntries is the number of entries of a .tgz or .tar.gz read to find the Chart.yaml of a packaged chart.
+// helm package writes <name>/Chart.yaml among the first entries of the archive.
+const maxSniffedArchiveEntries = 32
+
 func IsValidFolderPath(path string) (bool, error) {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
@@ -32,6 +71,118 @@ func DeleteDirectory(dirPath string) error {
 	return nil
 }
 
+// isDockerfile reports whether a file is a Dockerfile, either by its name or, for files without an
+// extension or with an unknown one outside of vendored and build output directories, by its first
+// instruction.
+func isDockerfile(path, relativePath string, info os.FileInfo) bool {
+	if info.IsDir() {
+		return false
+	}
+	if dockerfilePattern.MatchString(info.Name()) {
+		return true
+	}
+	if !info.Mode().IsRegular() || info.Size() == 0 || info.Size() > maxSniffedDockerfileSize ||
+		knownFileExtensions[strings.ToLower(filepath.Ext(info.Name()))] || dockerComposePattern.MatchString(info.Name()) ||
+		insideUnsniffedDirectory(relativePath) {
+		return false
+	}
+	return hasDockerfileContent(path)
+}
+
+// insideUnsniffedDirectory reports whether a relative path is inside a directory whose files are
+// not opened to check whether they are Dockerfiles.
+func insideUnsniffedDirectory(relativePath string) bool {
+	dirs := strings.Split(filepath.ToSlash(filepath.Dir(relativePath)), "/")
+	return slices.ContainsFunc(dirs, func(dir string) bool {
+		return unsniffedDirectories[dir]
+	})
+}
+
+// isKubernetesManifest reports whether a file is a YAML file, other than a compose file, holding
+// Kubernetes resources.
+func isKubernetesManifest(path string, info os.FileInfo) bool {
+	if info.IsDir() || !info.Mode().IsRegular() || info.Size() == 0 || info.Size() > maxSniffedManifestSize ||
+		!isYAMLFile(path) || dockerComposePattern.MatchString(info.Name()) {
+		return false
+	}
+	return extractors.IsKubernetesManifest(path)
+}
+
+// insideDirectoryWith reports whether dir, or one of its parents up to root, holds one of the files
+// names, such as the Chart.yaml of a Helm chart. Results are cached in dirs, keyed by directory.
+func insideDirectoryWith(dir, root string, names []string, dirs map[string]bool) bool {
+	inside, ok := dirs[dir]
+	if ok {
+		return inside
+	}
+	for _, name := range names {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+			inside = true
+			break
+		}
+	}
+	if parent := filepath.Dir(dir); !inside && dir != filepath.Clean(root) && parent != dir {
+		inside = insideDirectoryWith(parent, root, names, dirs)
+	}
+	dirs[dir] = inside
+	return inside
+}
+
+// hasDockerfileContent reports whether the first instruction of a file, after comments, parser
+// directives and blank lines, is a well formed FROM or ARG.
+func hasDockerfileContent(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Warn().Msgf("Could not close file: %s err: %+v", file.Name(), err)
+		}
+	}(file)
+
+	scanner := bufio.NewScanner(file)
+	var instruction string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.ContainsRune(line, 0) {
+			return false
+		}
+		if instruction == "" && (line == "" || strings.HasPrefix(line, "#")) {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") || strings.HasSuffix(line, "`") {
+			instruction += line[:len(line)-1] + " "
+			continue
+		}
+		instruction += line
+		break
+	}
+
+	return isDockerfileStart(strings.TrimSpace(instruction))
+}
+
+// isDockerfileStart reports whether an instruction parses as an ARG, or as a FROM whose base is an
+// image reference or a variable, as the first instruction of a Dockerfile must.
+func isDockerfileStart(instruction string) bool {
+	dockerfile, err := parser.Parse(strings.NewReader(instruction))
+	if err != nil || len(dockerfile.AST.Children) != 1 {
+		return false
+	}
+	parsed, err := instructions.ParseInstruction(dockerfile.AST.Children[0])
+	if err != nil {
+		return false
+	}
+	switch cmd := parsed.(type) {
+	case *instructions.ArgCommand:
+		return true
+	case *instructions.Stage:
+		return strings.Contains(cmd.BaseName, "$") || extractors.IsImageReference(cmd.BaseName)
+	}
+	return false
+}
+
 func findHelmCharts(baseDir string) ([]types.HelmChartInfo, error) {
 	var helmCharts []types.HelmChartInfo
 
@@ -40,31 +191,29 @@ func findHelmCharts(baseDir string) ([]types.HelmChartInfo, error) {
 			return err
 		}
 
+		// Subcharts are rendered as dependencies of their parent chart
+		if info.IsDir() && info.Name() == "charts" && isHelmChart(filepath.Dir(path)) {
+			return filepath.SkipDir
+		}
+
+		// Packaged charts are loaded from the archive, their paths are the paths inside it
+		if !info.IsDir() && isHelmChartArchiveName(info.Name()) {
+			if helmChart, ok := helmChartArchiveInfo(path); ok {
+				helmCharts = append(helmCharts, helmChart)
+			}
+			return nil
+		}
+
 		if info.IsDir() && isHelmChart(path) {
 
+			var relativeValuesPath string
 			valuesFile := filepath.Join(path, "values.yaml")
-			relativeValuesPath, _ := filepath.Rel(baseDir, valuesFile)
-			relativeValuesPath = filepath.ToSlash(relativeValuesPath)
-
-			templatesDir := filepath.Join(path, "templates")
-
-			var templateFiles []types.FilePath
-			err = filepath.Walk(templatesDir, func(templatePath string, templateInfo os.FileInfo, templateErr error) error {
-				if templateErr != nil {
-					return templateErr
-				}
-				if !templateInfo.IsDir() && isYAMLFile(templatePath) {
-					relativeTemplatePath, _ := filepath.Rel(baseDir, templatePath)
-					relativeTemplatePath = filepath.ToSlash(relativeTemplatePath)
-					templateFiles = append(templateFiles, types.FilePath{
-						FullPath:     templatePath,
-						RelativePath: relativeTemplatePath,
-					})
-				}
-
-				return nil
-			})
+			if _, err := os.Stat(valuesFile); err == nil {
+				relativeValuesPath, _ = filepath.Rel(baseDir, valuesFile)
+				relativeValuesPath = filepath.ToSlash(relativeValuesPath)
+			}
 
+			templateFiles, err := findChartTemplateFiles(baseDir, path)
 			if err != nil {
 				return err
 			}
@@ -84,6 +233,47 @@ func findHelmCharts(baseDir string) ([]types.HelmChartInfo, error) {
 	return helmCharts, err
 }
 
+// findChartTemplateFiles returns the YAML templates of a chart and of the unpacked subcharts in its
+// charts directory.
+func findChartTemplateFiles(baseDir, chartDir string) ([]types.FilePath, error) {
+	var templateFiles []types.FilePath
+
+	templatesDir := filepath.Join(chartDir, "templates")
+	if info, err := os.Stat(templatesDir); err == nil && info.IsDir() {
+		err = filepath.Walk(templatesDir, func(templatePath string, templateInfo os.FileInfo, templateErr error) error {
+			if templateErr != nil {
+				return templateErr
+			}
+			if !templateInfo.IsDir() && isYAMLFile(templatePath) {
+				templateFiles = append(templateFiles, types.FilePath{
+					FullPath:     templatePath,
+					RelativePath: getRelativePath(baseDir, templatePath),
+				})
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	subcharts, _ := os.ReadDir(filepath.Join(chartDir, "charts"))
+	for _, subchart := range subcharts {
+		subchartDir := filepath.Join(chartDir, "charts", subchart.Name())
+		if !subchart.IsDir() || !isHelmChart(subchartDir) {
+			continue
+		}
+		subchartTemplates, err := findChartTemplateFiles(baseDir, subchartDir)
+		if err != nil {
+			return nil, err
+		}
+		templateFiles = append(templateFiles, subchartTemplates...)
+	}
+
+	return templateFiles, nil
+}
+
 func extractCompressedPath(inputPath string) (string, error) {
 	if fileInfo, err := os.Stat(inputPath); err == nil && fileInfo.IsDir() {
 		return inputPath, nil
@@ -114,16 +304,61 @@ func isYAMLFile(filePath string) bool {
 	return ext == ".yml" || ext == ".yaml"
 }
 
+// isHelmChart reports whether a directory holds a Chart.yaml. Charts without values or templates,
+// such as umbrella charts made of dependencies only, are charts too.
 func isHelmChart(directory string) bool {
-	chartFilePath := filepath.Join(directory, "Chart.yaml")
-	valuesFilePath := filepath.Join(directory, "values.yaml")
-	templatesDirPath := filepath.Join(directory, "templates")
+	info, err := os.Stat(filepath.Join(directory, "Chart.yaml"))
+	return err == nil && !info.IsDir()
+}
 
-	_, errChart := os.Stat(chartFilePath)
-	_, errValues := os.Stat(valuesFilePath)
-	_, errTemplatesDir := os.Stat(templatesDirPath)
+func isHelmChartArchiveName(name string) bool {
+	return strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".tar.gz")
+}
+
+// helmChartArchiveInfo returns the chart of a packaged chart archive, an archive with a Chart.yaml in
+// its top directory as helm package creates it. The chart is not extracted: its Directory is the
+// archive, and its ValuesFile is the path of the values.yaml inside the archive.
+func helmChartArchiveInfo(archivePath string) (types.HelmChartInfo, bool) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return types.HelmChartInfo{}, false
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Warn().Msgf("Could not close file: %s err: %+v", file.Name(), err)
+		}
+	}(file)
 
-	return errChart == nil && errValues == nil && errTemplatesDir == nil
+	gzr, err := gzip.NewReader(file)
+	if err != nil {
+		return types.HelmChartInfo{}, false
+	}
+	defer gzr.Close()
+
+	// The archive is read only as long as its entries are in its first top directory, and up to
+	// maxSniffedArchiveEntries entries, so that other large tarballs are not decompressed in full.
+	tr := tar.NewReader(gzr)
+	firstDir := ""
+	for entries := 0; entries < maxSniffedArchiveEntries; entries++ {
+		header, err := tr.Next()
+		if err != nil {
+			return types.HelmChartInfo{}, false
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		chartDir, name, _ := strings.Cut(strings.TrimPrefix(header.Name, "./"), "/")
+		if firstDir == "" {
+			firstDir = chartDir
+		} else if chartDir != firstDir {
+			return types.HelmChartInfo{}, false
+		}
+		if name == "Chart.yaml" && header.Typeflag == tar.TypeReg {
+			return types.HelmChartInfo{Directory: archivePath, ValuesFile: chartDir + "/values.yaml"}, true
+		}
+	}
+	return types.HelmChartInfo{}, false
 }
 
 func getContainerResolutionFullPath(folderPath string) (string, error) {
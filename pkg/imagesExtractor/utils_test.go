@@ -1,6 +1,9 @@
 package imagesExtractor
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
 	"github.com/Checkmarx/containers-types/types"
 	"os"
 	"path/filepath"
@@ -36,6 +39,30 @@ func TestFindHelmCharts(t *testing.T) {
 	}
 }
 
+func TestFindHelmCharts_Subcharts(t *testing.T) {
+	baseDir := "../../test_files/helm-subcharts"
+
+	helmCharts, err := findHelmCharts(baseDir)
+	if err != nil {
+		t.Fatalf("Error finding Helm charts: %v", err)
+	}
+
+	expectedChart := types.HelmChartInfo{
+		Directory:  filepath.Join(baseDir, "umbrella"),
+		ValuesFile: "umbrella/values.yaml",
+		TemplateFiles: []types.FilePath{
+			{FullPath: "../../test_files/helm-subcharts/umbrella/charts/metrics/templates/daemonset.yaml", RelativePath: "umbrella/charts/metrics/templates/daemonset.yaml"},
+			{FullPath: "../../test_files/helm-subcharts/umbrella/charts/web/templates/deployment.yaml", RelativePath: "umbrella/charts/web/templates/deployment.yaml"},
+		},
+	}
+	if len(helmCharts) != 1 {
+		t.Fatalf("Expected the subcharts to belong to their parent chart, got %+v", helmCharts)
+	}
+	if !reflect.DeepEqual(helmCharts[0], expectedChart) {
+		t.Errorf("Retrieved Helm chart info does not match expected:\nGot: %+v\nExpected: %+v", helmCharts[0], expectedChart)
+	}
+}
+
 func TestIsValidFolderPath(t *testing.T) {
 	baseDir := "../../test_files/imageExtraction"
 
@@ -154,6 +181,32 @@ func TestIsYAMLFile(t *testing.T) {
 	}
 }
 
+func TestDockerfilePattern(t *testing.T) {
+	for _, name := range []string{"Dockerfile", "Dockerfile-2", "Dockerfile.ubi9", "Containerfile", "Containerfile.prod", "app.dockerfile", "api.Dockerfile", "web.Containerfile"} {
+		if !dockerfilePattern.MatchString(name) {
+			t.Errorf("Expected %s to be recognized as a Dockerfile name", name)
+		}
+	}
+	for _, name := range []string{"dockerfile.go", "my-Dockerfile", ".dockerignore", "docker-compose.yaml"} {
+		if dockerfilePattern.MatchString(name) {
+			t.Errorf("Expected %s not to be recognized as a Dockerfile name", name)
+		}
+	}
+}
+
+func TestIsDockerfileStart(t *testing.T) {
+	for _, instruction := range []string{"FROM node:20-alpine", "from scratch", "FROM --platform=linux/amd64 eclipse-temurin:21-jre AS runtime", "FROM ${BASE}", "ARG BASE=alpine:3.20"} {
+		if !isDockerfileStart(instruction) {
+			t.Errorf("Expected %q to be recognized as the start of a Dockerfile", instruction)
+		}
+	}
+	for _, instruction := range []string{"From here:", "from the docs", "FROM ubuntu:22.04 is the base image of the service.", "FROM_IMAGE=alpine", "ARGUMENTS=none"} {
+		if isDockerfileStart(instruction) {
+			t.Errorf("Expected %q not to be recognized as the start of a Dockerfile", instruction)
+		}
+	}
+}
+
 func TestIsHelmChart(t *testing.T) {
 	// Test case for Helm chart directory
 	helmChartDir := "../../test_files/imageExtraction/helm"
@@ -161,6 +214,13 @@ func TestIsHelmChart(t *testing.T) {
 		t.Errorf("Expected %s to be recognized as a Helm chart directory", helmChartDir)
 	}
 
+	// Test case for an umbrella chart without templates, and a chart without values
+	for _, chartDir := range []string{"../../test_files/helm-subcharts/umbrella", "../../test_files/helm-subcharts/umbrella/charts/metrics"} {
+		if !isHelmChart(chartDir) {
+			t.Errorf("Expected %s to be recognized as a Helm chart directory", chartDir)
+		}
+	}
+
 	// Test case for non-Helm chart directory
 	nonHelmChartDir := "/path/to/non/helm/chart"
 	if isHelmChart(nonHelmChartDir) {
@@ -168,6 +228,53 @@ func TestIsHelmChart(t *testing.T) {
 	}
 }
 
+func TestHelmChartArchiveInfo(t *testing.T) {
+	archive := "../../test_files/helm-archives/releases/shop-1.2.3.tgz"
+	chart, ok := helmChartArchiveInfo(archive)
+	if !ok || chart.Directory != archive || chart.ValuesFile != "shop/values.yaml" {
+		t.Errorf("Expected %s to be recognized as a Helm chart archive, got %+v", archive, chart)
+	}
+
+	// The Chart.yaml at the end of these archives is never read: the archives are rejected at the first
+	// entry outside their first top directory, or once maxSniffedArchiveEntries entries were read.
+	var layered, large []string
+	layered = append(layered, "docs/README.md", "chart/Chart.yaml")
+	for i := 0; i < maxSniffedArchiveEntries; i++ {
+		large = append(large, fmt.Sprintf("data/part-%d.bin", i))
+	}
+	large = append(large, "data/Chart.yaml")
+	for name, entries := range map[string][]string{"layered.tar.gz": layered, "large.tgz": large} {
+		archive := writeTarGz(t, name, entries)
+		if chart, ok := helmChartArchiveInfo(archive); ok {
+			t.Errorf("Expected %s not to be recognized as a Helm chart archive, got %+v", name, chart)
+		}
+	}
+}
+
+// writeTarGz writes a .tar.gz holding empty files with the given names to a temporary directory.
+func writeTarGz(t *testing.T, name string, entries []string) string {
+	archive := filepath.Join(t.TempDir(), name)
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("Error creating archive: %v", err)
+	}
+	defer file.Close()
+	gzw := gzip.NewWriter(file)
+	tw := tar.NewWriter(gzw)
+	for _, entry := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: entry, Typeflag: tar.TypeReg, Mode: 0644}); err != nil {
+			t.Fatalf("Error writing archive: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Error writing archive: %v", err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatalf("Error writing archive: %v", err)
+	}
+	return archive
+}
+
 func TestGetContainerResolutionFullPath(t *testing.T) {
 	// Test case for getting container resolution full path
 	folderPath := "/path/to/folder"
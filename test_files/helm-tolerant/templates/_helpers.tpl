@@ -0,0 +1,3 @@
+{{- define "tolerant.image" -}}
+{{ .Values.image.repository }}:{{ required "image.tag is required" .Values.image.tag }}
+{{- end }}